@@ -0,0 +1,21 @@
+package api
+
+// Limits of the paginated routes, checked by the server and respected by the
+// client's iterators.
+const (
+	MaxChildrenLimit   = 1000
+	MaxChildrenOffset  = 10000
+	MaxSearchLimit     = 100
+	MaxSearchOffset    = 1000
+	MaxCrossRefsLimit  = 1000
+	MaxCrossRefsOffset = 100000
+)
+
+// HeaderExportStatus is the trailer of a subtree export, set to
+// ExportComplete once the whole subtree was written. The status of the
+// response is sent before the subtree is read, so a failure while streaming
+// can only be told by its absence.
+const (
+	HeaderExportStatus = "Export-Status"
+	ExportComplete     = "complete"
+)
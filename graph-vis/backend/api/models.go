@@ -0,0 +1,152 @@
+// Package api defines the JSON models of the backend API, shared by the server
+// and the Go client.
+package api
+
+import "time"
+
+type TaxonBase struct {
+	Rank       string `json:"rank"`
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	WikidataId string `json:"wikidataId,omitempty"`
+	// LegacyKey is the database-generated key the taxon had before it was
+	// moved to a stable key.
+	LegacyKey string `json:"legacyKey,omitempty"`
+	// Curated is set on taxa edited by hand, which the scraper leaves alone.
+	Curated bool `json:"curated,omitempty"`
+}
+
+type TaxonResponse struct {
+	TaxonBase
+	Id string `json:"id"`
+}
+
+type MRCAPathResponse struct {
+	Taxon    TaxonResponse   `json:"taxon"`
+	Path     []TaxonResponse `json:"path"`
+	Distance int             `json:"distance"`
+}
+
+type MRCAResponse struct {
+	MRCA      TaxonResponse      `json:"mrca"`
+	Paths     []MRCAPathResponse `json:"paths"`
+	Distance  *int               `json:"distance,omitempty"`
+	Distances [][]int            `json:"distances,omitempty"`
+}
+
+type Rank struct {
+	Name  string `json:"name"`
+	Order int    `json:"order"`
+	Count int64  `json:"count"`
+}
+
+type CrossRef struct {
+	TaxonId        string    `json:"taxonId"`
+	Source         string    `json:"source"`
+	Status         string    `json:"status"`
+	ExternalId     string    `json:"externalId,omitempty"`
+	ExternalName   string    `json:"externalName,omitempty"`
+	ExternalStatus string    `json:"externalStatus,omitempty"`
+	Conflicts      []string  `json:"conflicts,omitempty"`
+	MatchedAt      time.Time `json:"matchedAt"`
+}
+
+type SnapshotConfig struct {
+	SeedURL       string `json:"seedUrl"`
+	AllowedDomain string `json:"allowedDomain"`
+	MaxTreeDepth  int    `json:"maxTreeDepth"`
+	KingdomName   string `json:"kingdomName"`
+}
+
+// Snapshot is the record of a completed crawl. Snapshots of a graph are
+// numbered in order by Seq.
+type Snapshot struct {
+	Id         string         `json:"id"`
+	Seq        int            `json:"seq"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Config     SnapshotConfig `json:"config"`
+	Taxa       int            `json:"taxa"`
+}
+
+// TaxonVersion is the state of a taxon from snapshot ValidFrom up to but
+// excluding ValidTo, which is nil while the version is current.
+type TaxonVersion struct {
+	TaxonId    string `json:"taxonId"`
+	Rank       string `json:"rank"`
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	WikidataId string `json:"wikidataId,omitempty"`
+	Parent     string `json:"parent,omitempty"`
+	ValidFrom  int    `json:"validFrom"`
+	ValidTo    *int   `json:"validTo"`
+}
+
+type TaxonChange struct {
+	From TaxonVersion `json:"from"`
+	To   TaxonVersion `json:"to"`
+}
+
+type SnapshotDiff struct {
+	From    Snapshot       `json:"from"`
+	To      Snapshot       `json:"to"`
+	Added   []TaxonVersion `json:"added"`
+	Removed []TaxonVersion `json:"removed"`
+	Renamed []TaxonChange  `json:"renamed"`
+	Moved   []TaxonChange  `json:"moved"`
+}
+
+// TaxonInput is the body of a request creating a taxon. The ID defaults to
+// the stable key of the scientific name.
+type TaxonInput struct {
+	Id         string `json:"id"`
+	Rank       string `json:"rank"`
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	WikidataId string `json:"wikidataId"`
+	Parent     string `json:"parent"`
+}
+
+// TaxonPatch is the body of a request editing a taxon. Only the given fields
+// are changed.
+type TaxonPatch struct {
+	Name       *string `json:"name"`
+	Url        *string `json:"url"`
+	WikidataId *string `json:"wikidataId"`
+	Parent     *string `json:"parent"`
+}
+
+// AuditTaxon is the state of a taxon recorded in the audit log.
+type AuditTaxon struct {
+	TaxonResponse
+	Parent string `json:"parent,omitempty"`
+}
+
+// AuditEntry records a curation change.
+type AuditEntry struct {
+	TaxonId    string      `json:"taxonId"`
+	Action     string      `json:"action"`
+	User       string      `json:"user"`
+	At         time.Time   `json:"at"`
+	Before     *AuditTaxon `json:"before,omitempty"`
+	After      *AuditTaxon `json:"after,omitempty"`
+	MergedInto string      `json:"mergedInto,omitempty"`
+}
+
+// GraphConfig identifies a graph served by the backend.
+type GraphConfig struct {
+	Name     string `json:"name"`
+	Database string `json:"database"`
+}
+
+// APIError is the error envelope returned to API clients.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
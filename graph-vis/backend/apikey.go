@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognise.
+const apiKeyPrefix = "ak_"
+
+// NewAPIKey generates an API key. The returned token, of the form
+// `ak_<id>_<secret>`, is given to the client; only the hash of the secret is
+// kept in the returned APIKey.
+func NewAPIKey(name string) (APIKey, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{
+		Id:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashAPIKeySecret(hex.EncodeToString(secret)),
+		CreatedAt: time.Now().UTC(),
+	}
+	return key, apiKeyPrefix + key.Id + "_" + hex.EncodeToString(secret), nil
+}
+
+// hashAPIKeySecret hashes the secret part of an API key. Secrets are random,
+// so a fast hash suffices.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits an API key token into its ID and secret.
+func parseAPIKey(token string) (id string, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// verifyAPIKey returns the stored key of an API key token if the token's
+// secret matches and the key has not been revoked.
+func verifyAPIKey(repo APIKeyRepo, token string) (APIKey, error) {
+	id, secret, ok := parseAPIKey(token)
+	if !ok {
+		return APIKey{}, newSvcError(ErrInvalid, "Malformed API key")
+	}
+	key, err := repo.GetAPIKey(id)
+	if errors.Is(err, ErrNotFound) {
+		return key, newSvcError(ErrInvalid, "Unknown API key")
+	} else if err != nil {
+		return key, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(key.Hash)) != 1 {
+		return key, newSvcError(ErrInvalid, "Unknown API key")
+	}
+	if key.RevokedAt != nil {
+		return key, newSvcError(ErrInvalid, "API key '%s' was revoked", key.Id)
+	}
+	return key, nil
+}
+
+const apiKeyUsage = `Usage: backend apikey <command> [flags]
+
+Commands:
+  create -name <name> [-rate <requests/s>] [-burst <requests>]
+                 Issue an API key. The key is shown once.
+  list           List API keys with their usage.
+  revoke <id>    Revoke an API key.
+`
+
+// runAPIKeyCommand manages the API keys stored in the default graph's
+// database.
+func runAPIKeyCommand(repo APIKeyRepo, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, apiKeyUsage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ExitOnError)
+		name := flags.String("name", "", "Name of the client the key is issued to.")
+		rate := flags.Float64("rate", 0, "Requests per second allowed, overriding RATE_LIMIT_KEY.")
+		burst := flags.Int("burst", 0, "Requests allowed in a burst, overriding RATE_LIMIT_KEY_BURST.")
+		flags.Parse(args[1:])
+		if *name == "" {
+			return errors.New("missing -name")
+		}
+		key, token, err := NewAPIKey(*name)
+		if err != nil {
+			return err
+		}
+		key.Rate, key.Burst = *rate, *burst
+		if err := repo.CreateAPIKey(key); err != nil {
+			return err
+		}
+		fmt.Printf("Created API key '%s' for %s:\n%s\n", key.Id, key.Name, token)
+	case "list":
+		keys, err := repo.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED\tREQUESTS\tLIMITED\tLAST USED\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", key.Id, key.Name, key.CreatedAt.Format(time.RFC3339),
+				key.Requests, key.Limited, formatTime(key.LastUsedAt), formatTime(key.RevokedAt))
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("expected the ID of the key to revoke")
+		}
+		if err := repo.RevokeAPIKey(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key '%s'\n", args[1])
+	default:
+		fmt.Fprint(os.Stderr, apiKeyUsage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+// ArangoTaxonRepo is a TaxonRepo backed by an ArangoDB graph.
+type ArangoTaxonRepo struct {
+	db       arango.Database
+	graph    string
+	maxDepth int
+}
+
+func NewArangoTaxonRepo(db arango.Database, cfg Config) *ArangoTaxonRepo {
+	return &ArangoTaxonRepo{db: db, graph: cfg.GraphName, maxDepth: len(cfg.TaxonRanks) - 1}
+}
+
+func (repo *ArangoTaxonRepo) Ready(ctx context.Context) error {
+	exists, err := repo.db.GraphExists(ctx, repo.graph)
+	if err != nil {
+		return wrapDBError(err, "Database unavailable")
+	}
+	if !exists {
+		return newSvcError(ErrUnavailable, "Graph '%s' not found", repo.graph)
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) Get(rank string, id string) (Taxon, error) {
+	taxon := Taxon{}
+	col, err := repo.db.Collection(nil, rank)
+	if err != nil {
+		return taxon, wrapDBError(err, "Unknown rank '%s'", rank)
+	}
+	_, err = col.ReadDocument(nil, id, &taxon)
+	if err != nil {
+		return taxon, wrapDBError(err, "Taxon '%s/%s' not found", rank, id)
+	}
+	return taxon, nil
+}
+
+func (repo *ArangoTaxonRepo) GetChildren(rank string, id string) ([]Taxon, error) {
+	query := "FOR v IN 1..1 INBOUND @start GRAPH @graph RETURN v"
+	bindVars := map[string]interface{}{
+		"start": fmt.Sprintf("%s/%s", rank, id),
+		"graph": repo.graph,
+	}
+	taxa, err := repo.queryTaxa(query, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to query children of '%s/%s'", rank, id)
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) GetLineage(rank string, id string, root string) ([]Taxon, error) {
+	taxa := []Taxon{}
+	query := `FOR v, e, p IN 0..@depth OUTBOUND @start GRAPH @graph
+		FILTER LOWER(v.rank) == @root
+		LIMIT 1
+		RETURN p.vertices`
+	bindVars := map[string]interface{}{
+		"start": fmt.Sprintf("%s/%s", rank, id),
+		"graph": repo.graph,
+		"depth": repo.maxDepth,
+		"root":  root,
+	}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to query lineage of '%s/%s'", rank, id)
+	}
+	defer cursor.Close()
+	_, err = cursor.ReadDocument(nil, &taxa)
+	if arango.IsNoMoreDocuments(err) {
+		return taxa, newSvcError(ErrNotFound, "No lineage found for taxon '%s/%s'", rank, id)
+	} else if err != nil {
+		return taxa, wrapDBError(err, "Failed to read lineage of '%s/%s'", rank, id)
+	}
+	// Traversal paths start at the given taxon. Reverse to start at the root.
+	for i, j := 0, len(taxa)-1; i < j; i, j = i+1, j-1 {
+		taxa[i], taxa[j] = taxa[j], taxa[i]
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) FindByWikidataId(rank string, qid string) ([]Taxon, error) {
+	query := `FOR t IN @@coll
+		FILTER t.wikidataId == @qid
+		RETURN t`
+	bindVars := map[string]interface{}{
+		"@coll": rank,
+		"qid":   qid,
+	}
+	taxa, err := repo.queryTaxa(query, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to find Wikidata item '%s' in rank '%s'", qid, rank)
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) FindByLegacyKey(rank string, key string) ([]Taxon, error) {
+	query := `FOR t IN @@coll
+		FILTER t.legacyKey == @key
+		RETURN t`
+	bindVars := map[string]interface{}{
+		"@coll": rank,
+		"key":   key,
+	}
+	taxa, err := repo.queryTaxa(query, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to find legacy key '%s' in rank '%s'", key, rank)
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) FindByUrls(rank string, urls []string) ([]Taxon, error) {
+	query := `FOR t IN @@coll
+		FILTER t.url IN @urls
+		RETURN t`
+	bindVars := map[string]interface{}{
+		"@coll": rank,
+		"urls":  urls,
+	}
+	taxa, err := repo.queryTaxa(query, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to find page '%s' in rank '%s'", urls[0], rank)
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) Search(rank string, query string, limit int) ([]Taxon, error) {
+	aql := `FOR t IN @@coll
+		FILTER CONTAINS(LOWER(t.name), LOWER(@query))
+		SORT t.name
+		LIMIT @limit
+		RETURN t`
+	bindVars := map[string]interface{}{
+		"@coll": rank,
+		"query": query,
+		"limit": limit,
+	}
+	taxa, err := repo.queryTaxa(aql, bindVars)
+	if err != nil {
+		return taxa, wrapDBError(err, "Failed to search rank '%s'", rank)
+	}
+	return taxa, nil
+}
+
+func (repo *ArangoTaxonRepo) Count(rank string) (int64, error) {
+	col, err := repo.db.Collection(nil, rank)
+	if err != nil {
+		return 0, wrapDBError(err, "Unknown rank '%s'", rank)
+	}
+	count, err := col.Count(nil)
+	if err != nil {
+		return 0, wrapDBError(err, "Failed to count rank '%s'", rank)
+	}
+	return count, nil
+}
+
+func (repo *ArangoTaxonRepo) CountDescendants(rank string, id string) (int64, error) {
+	var count int64
+	query := `FOR v IN 1..@depth INBOUND @start GRAPH @graph
+		OPTIONS {order: "bfs", uniqueVertices: "global"}
+		COLLECT WITH COUNT INTO n
+		RETURN n`
+	bindVars := map[string]interface{}{
+		"start": fmt.Sprintf("%s/%s", rank, id),
+		"graph": repo.graph,
+		"depth": repo.maxDepth,
+	}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return count, wrapDBError(err, "Failed to count descendants of '%s/%s'", rank, id)
+	}
+	defer cursor.Close()
+	if _, err = cursor.ReadDocument(nil, &count); err != nil {
+		return count, wrapDBError(err, "Failed to count descendants of '%s/%s'", rank, id)
+	}
+	return count, nil
+}
+
+func (repo *ArangoTaxonRepo) GetChildrenBatch(ids []string) (map[string][]Taxon, error) {
+	return repo.getNeighboursBatch(ids, "INBOUND")
+}
+
+func (repo *ArangoTaxonRepo) GetParentsBatch(ids []string) (map[string][]Taxon, error) {
+	return repo.getNeighboursBatch(ids, "OUTBOUND")
+}
+
+func (repo *ArangoTaxonRepo) GetLineageBatch(ids []string, root string) (map[string][]Taxon, error) {
+	lineages := map[string][]Taxon{}
+	query := `FOR id IN @ids
+		LET path = FIRST(
+			FOR v, e, p IN 0..@depth OUTBOUND id GRAPH @graph
+				FILTER LOWER(v.rank) == @root
+				LIMIT 1
+				RETURN p.vertices)
+		FILTER path != null
+		RETURN {id: id, taxa: REVERSE(path)}`
+	bindVars := map[string]interface{}{
+		"ids":   ids,
+		"graph": repo.graph,
+		"depth": repo.maxDepth,
+		"root":  root,
+	}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return lineages, wrapDBError(err, "Failed to query lineages of %d taxa", len(ids))
+	}
+	defer cursor.Close()
+	for {
+		var row struct {
+			Id   string  `json:"id"`
+			Taxa []Taxon `json:"taxa"`
+		}
+		_, err := cursor.ReadDocument(nil, &row)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return lineages, wrapDBError(err, "Failed to read lineages of %d taxa", len(ids))
+		}
+		lineages[row.Id] = row.Taxa
+	}
+	return lineages, nil
+}
+
+func (repo *ArangoTaxonRepo) CountDescendantsBatch(ids []string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	query := `FOR id IN @ids
+		LET n = LENGTH(
+			FOR v IN 1..@depth INBOUND id GRAPH @graph
+				OPTIONS {order: "bfs", uniqueVertices: "global"}
+				RETURN 1)
+		RETURN {id: id, count: n}`
+	bindVars := map[string]interface{}{
+		"ids":   ids,
+		"graph": repo.graph,
+		"depth": repo.maxDepth,
+	}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return counts, wrapDBError(err, "Failed to count descendants of %d taxa", len(ids))
+	}
+	defer cursor.Close()
+	for {
+		var row struct {
+			Id    string `json:"id"`
+			Count int64  `json:"count"`
+		}
+		_, err := cursor.ReadDocument(nil, &row)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return counts, wrapDBError(err, "Failed to read descendant counts of %d taxa", len(ids))
+		}
+		counts[row.Id] = row.Count
+	}
+	return counts, nil
+}
+
+// getNeighboursBatch returns the taxa adjacent to each of the given taxa in
+// the given traversal direction with a single query.
+func (repo *ArangoTaxonRepo) getNeighboursBatch(ids []string, direction string) (map[string][]Taxon, error) {
+	neighbours := map[string][]Taxon{}
+	query := fmt.Sprintf(`FOR id IN @ids
+		FOR v IN 1..1 %s id GRAPH @graph
+			RETURN {id: id, taxon: v}`, direction)
+	bindVars := map[string]interface{}{
+		"ids":   ids,
+		"graph": repo.graph,
+	}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return neighbours, wrapDBError(err, "Failed to query neighbours of %d taxa", len(ids))
+	}
+	defer cursor.Close()
+	for {
+		var row struct {
+			Id    string `json:"id"`
+			Taxon Taxon  `json:"taxon"`
+		}
+		_, err := cursor.ReadDocument(nil, &row)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return neighbours, wrapDBError(err, "Failed to read neighbours of %d taxa", len(ids))
+		}
+		neighbours[row.Id] = append(neighbours[row.Id], row.Taxon)
+	}
+	return neighbours, nil
+}
+
+func (repo *ArangoTaxonRepo) WalkSubtree(rank string, id string, fn func(SubtreeNode) error) error {
+	query := `FOR v, e, p IN 0..@depth INBOUND @start GRAPH @graph
+		OPTIONS {order: "dfs", uniqueVertices: "path"}
+		RETURN {taxon: v, parent: e._to, depth: LENGTH(p.edges)}`
+	bindVars := map[string]interface{}{
+		"start": fmt.Sprintf("%s/%s", rank, id),
+		"graph": repo.graph,
+		"depth": repo.maxDepth,
+	}
+	ctx := arango.WithQueryStream(context.Background(), true)
+	cursor, err := repo.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return wrapDBError(err, "Failed to query subtree of '%s/%s'", rank, id)
+	}
+	defer cursor.Close()
+	for {
+		var row struct {
+			Taxon  Taxon  `json:"taxon"`
+			Parent string `json:"parent"`
+			Depth  int    `json:"depth"`
+		}
+		_, err := cursor.ReadDocument(ctx, &row)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return wrapDBError(err, "Failed to read subtree of '%s/%s'", rank, id)
+		}
+		if err := fn(SubtreeNode{Taxon: row.Taxon, Parent: row.Parent, Depth: row.Depth}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) GetCrossRefs(rank string, id string) ([]CrossRef, error) {
+	query := `FOR x IN @@coll
+		FILTER x.taxonId == @taxonId
+		SORT x.source
+		RETURN x`
+	bindVars := map[string]interface{}{
+		"@coll":   CrossRefCollName,
+		"taxonId": fmt.Sprintf("%s/%s", rank, id),
+	}
+	crossRefs, err := repo.queryCrossRefs(query, bindVars)
+	if err != nil {
+		return crossRefs, wrapDBError(err, "Failed to query cross-references of '%s/%s'", rank, id)
+	}
+	return crossRefs, nil
+}
+
+func (repo *ArangoTaxonRepo) ListCrossRefs(source string, status string, offset int, limit int) ([]CrossRef, error) {
+	query := `FOR x IN @@coll
+		FILTER (@source == "" OR x.source == @source) AND (@status == "" OR x.status == @status)
+		SORT x.taxonId, x.source
+		LIMIT @offset, @limit
+		RETURN x`
+	bindVars := map[string]interface{}{
+		"@coll":  CrossRefCollName,
+		"source": source,
+		"status": status,
+		"offset": offset,
+		"limit":  limit,
+	}
+	crossRefs, err := repo.queryCrossRefs(query, bindVars)
+	if err != nil {
+		return crossRefs, wrapDBError(err, "Failed to query cross-references")
+	}
+	return crossRefs, nil
+}
+
+func (repo *ArangoTaxonRepo) ListSnapshots() ([]Snapshot, error) {
+	query := `FOR s IN @@coll
+		FILTER s.graph == @graph AND s.status == "complete"
+		SORT s.seq
+		RETURN MERGE(UNSET(s, "_key", "_id", "_rev", "graph", "status"), {id: s._key})`
+	bindVars := map[string]interface{}{
+		"@coll": SnapshotCollName,
+		"graph": repo.graph,
+	}
+	snapshots, err := queryDocuments[Snapshot](repo.db, query, bindVars)
+	if err != nil {
+		return snapshots, wrapDBError(err, "Failed to query snapshots")
+	}
+	return snapshots, nil
+}
+
+func (repo *ArangoTaxonRepo) GetSnapshotTaxa(seq int) ([]TaxonVersion, error) {
+	query := `FOR v IN @@coll
+		FILTER v.graph == @graph AND v.validFrom <= @seq AND (v.validTo == null OR v.validTo > @seq)
+		SORT v.taxonId
+		RETURN v`
+	bindVars := map[string]interface{}{
+		"@coll": TaxonVersionCollName,
+		"graph": repo.graph,
+		"seq":   seq,
+	}
+	versions, err := queryDocuments[TaxonVersion](repo.db, query, bindVars)
+	if err != nil {
+		return versions, wrapDBError(err, "Failed to query taxa of snapshot %d", seq)
+	}
+	return versions, nil
+}
+
+// queryDocuments runs an AQL query over a collection which the scraper only
+// creates once it is needed, so a missing collection yields no results.
+func queryDocuments[T any](db arango.Database, query string, bindVars map[string]interface{}) ([]T, error) {
+	docs := []T{}
+	cursor, err := db.Query(arango.WithQueryStream(context.Background(), true), query, bindVars)
+	if arango.IsNotFound(err) {
+		return docs, nil
+	} else if err != nil {
+		return docs, err
+	}
+	defer cursor.Close()
+	for {
+		var doc T
+		_, err := cursor.ReadDocument(nil, &doc)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return docs, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// queryCrossRefs runs an AQL query returning cross-reference documents. The
+// collection only exists once a checklist has been matched, so a missing
+// collection yields no results.
+func (repo *ArangoTaxonRepo) queryCrossRefs(query string, bindVars map[string]interface{}) ([]CrossRef, error) {
+	crossRefs := []CrossRef{}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if arango.IsNotFound(err) {
+		return crossRefs, nil
+	} else if err != nil {
+		return crossRefs, err
+	}
+	defer cursor.Close()
+	for {
+		var crossRef CrossRef
+		_, err := cursor.ReadDocument(nil, &crossRef)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return crossRefs, err
+		}
+		crossRefs = append(crossRefs, crossRef)
+	}
+	return crossRefs, nil
+}
+
+// queryTaxa runs an AQL query returning taxon documents.
+func (repo *ArangoTaxonRepo) queryTaxa(query string, bindVars map[string]interface{}) ([]Taxon, error) {
+	taxa := []Taxon{}
+	cursor, err := repo.db.Query(nil, query, bindVars)
+	if err != nil {
+		return taxa, err
+	}
+	defer cursor.Close()
+	for {
+		var taxon Taxon
+		_, err := cursor.ReadDocument(nil, &taxon)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return taxa, err
+		}
+		taxa = append(taxa, taxon)
+	}
+	return taxa, nil
+}
+
+// rankOf returns the rank of a document ID, which is its collection name.
+func rankOf(id string) string {
+	rank, _, _ := strings.Cut(id, "/")
+	return rank
+}
+
+// execQuery runs an AQL query which returns no results.
+func execQuery(db arango.Database, query string, bindVars map[string]interface{}) error {
+	cursor, err := db.Query(nil, query, bindVars)
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
+// insertCreatingCollection inserts a document into a collection which is
+// created on first use.
+func insertCreatingCollection(ctx context.Context, db arango.Database, name string, doc interface{}) error {
+	coll, err := db.Collection(nil, name)
+	if arango.IsNotFound(err) {
+		coll, err = db.CreateCollection(nil, name, nil)
+		if arango.IsConflict(err) {
+			coll, err = db.Collection(nil, name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = coll.CreateDocument(ctx, doc)
+	return err
+}
+
+func (repo *ArangoTaxonRepo) CreateTaxon(taxon Taxon, parent string) error {
+	coll, err := repo.db.Collection(nil, rankOf(taxon.Id))
+	if err != nil {
+		return wrapDBError(err, "Unknown rank '%s'", rankOf(taxon.Id))
+	}
+	doc := struct {
+		Key string `json:"_key"`
+		TaxonBase
+	}{Key: taxon.Key(), TaxonBase: taxon.TaxonBase}
+	if _, err := coll.CreateDocument(nil, doc); err != nil {
+		return wrapDBError(err, "Failed to create taxon '%s'", taxon.Id)
+	}
+	if parent == "" {
+		return nil
+	}
+	return repo.SetParent(taxon, parent)
+}
+
+func (repo *ArangoTaxonRepo) UpdateTaxon(taxon Taxon) error {
+	coll, err := repo.db.Collection(nil, rankOf(taxon.Id))
+	if err != nil {
+		return wrapDBError(err, "Unknown rank '%s'", rankOf(taxon.Id))
+	}
+	patch := map[string]interface{}{
+		"name":       taxon.Name,
+		"url":        taxon.Url,
+		"wikidataId": nil,
+		"curated":    true,
+	}
+	if taxon.WikidataId != "" {
+		patch["wikidataId"] = taxon.WikidataId
+	}
+	if _, err := coll.UpdateDocument(arango.WithKeepNull(context.Background(), false), taxon.Key(), patch); err != nil {
+		return wrapDBError(err, "Failed to update taxon '%s'", taxon.Id)
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) SetParent(taxon Taxon, parent string) error {
+	edges := rankOf(parent) + "Members"
+	err := execQuery(repo.db, `FOR e IN @@edges
+		FILTER e._from == @id
+		REMOVE e IN @@edges`, map[string]interface{}{"@edges": edges, "id": taxon.Id})
+	if err == nil {
+		err = execQuery(repo.db, `INSERT {_from: @id, _to: @parent, curated: true} INTO @@edges`,
+			map[string]interface{}{"@edges": edges, "id": taxon.Id, "parent": parent})
+	}
+	if err != nil {
+		return wrapDBError(err, "Failed to set parent of '%s' to '%s'", taxon.Id, parent)
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) DeleteTaxon(taxon Taxon, mergeInto string) error {
+	rank := rankOf(taxon.Id)
+	if mergeInto != "" {
+		err := execQuery(repo.db, `FOR e IN @@edges
+			FILTER e._to == @id
+			UPDATE e WITH {_to: @into, curated: true} IN @@edges`,
+			map[string]interface{}{"@edges": rank + "Members", "id": taxon.Id, "into": mergeInto})
+		// The lowest rank has no edge collection of its own.
+		if err != nil && !arango.IsNotFound(err) {
+			return wrapDBError(err, "Failed to move children of '%s'", taxon.Id)
+		}
+		err = execQuery(repo.db, `FOR x IN @@coll
+			FILTER x.taxonId == @id
+			UPDATE x WITH {taxonId: @into} IN @@coll`,
+			map[string]interface{}{"@coll": CrossRefCollName, "id": taxon.Id, "into": mergeInto})
+		if err != nil && !arango.IsNotFound(err) {
+			return wrapDBError(err, "Failed to move cross-references of '%s'", taxon.Id)
+		}
+	}
+
+	// Removing the vertex through the graph also removes its edges.
+	graph, err := repo.db.Graph(nil, repo.graph)
+	if err != nil {
+		return wrapDBError(err, "Failed to open graph '%s'", repo.graph)
+	}
+	coll, err := graph.VertexCollection(nil, rank)
+	if err != nil {
+		return wrapDBError(err, "Unknown rank '%s'", rank)
+	}
+	if _, err := coll.RemoveDocument(nil, taxon.Key()); err != nil {
+		return wrapDBError(err, "Failed to delete taxon '%s'", taxon.Id)
+	}
+
+	tombstone := map[string]interface{}{
+		"_key":       strings.Replace(taxon.Id, "/", ":", 1),
+		"taxonId":    taxon.Id,
+		"mergedInto": mergeInto,
+		"deletedAt":  time.Now().UTC(),
+	}
+	ctx := arango.WithOverwriteMode(context.Background(), arango.OverwriteModeReplace)
+	if err := insertCreatingCollection(ctx, repo.db, DeletedTaxonCollName, tombstone); err != nil {
+		return wrapDBError(err, "Failed to record deletion of '%s'", taxon.Id)
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) AddAuditEntry(entry AuditEntry) error {
+	if err := insertCreatingCollection(nil, repo.db, AuditCollName, entry); err != nil {
+		return wrapDBError(err, "Failed to record change to '%s'", entry.TaxonId)
+	}
+	return nil
+}
+
+func (repo *ArangoTaxonRepo) ListAuditEntries(taxonId string) ([]AuditEntry, error) {
+	query := `FOR a IN @@coll
+		FILTER a.taxonId == @id OR a.mergedInto == @id
+		SORT a.at
+		RETURN a`
+	bindVars := map[string]interface{}{
+		"@coll": AuditCollName,
+		"id":    taxonId,
+	}
+	entries, err := queryDocuments[AuditEntry](repo.db, query, bindVars)
+	if err != nil {
+		return entries, wrapDBError(err, "Failed to query changes to '%s'", taxonId)
+	}
+	return entries, nil
+}
+
+func (repo *ArangoTaxonRepo) GetVersion() (GraphVersion, error) {
+	version := GraphVersion{}
+	coll, err := repo.db.Collection(nil, GraphVersionCollName)
+	if err == nil {
+		_, err = coll.ReadDocument(nil, repo.graph, &version)
+	}
+	if arango.IsNotFound(err) {
+		return version, nil
+	}
+	return version, wrapDBError(err, "Failed to read version of graph '%s'", repo.graph)
+}
+
+func (repo *ArangoTaxonRepo) BumpVersion() error {
+	query := `UPSERT {_key: @graph}
+		INSERT {_key: @graph, version: 1, updatedAt: @now}
+		UPDATE {version: OLD.version + 1, updatedAt: @now}
+		IN @@coll`
+	bindVars := map[string]interface{}{
+		"@coll": GraphVersionCollName,
+		"graph": repo.graph,
+		"now":   time.Now().UTC(),
+	}
+	err := execQuery(repo.db, query, bindVars)
+	if arango.IsNotFound(err) {
+		// Created by the first bump.
+		if _, err = repo.db.CreateCollection(nil, GraphVersionCollName, nil); err == nil || arango.IsConflict(err) {
+			err = execQuery(repo.db, query, bindVars)
+		}
+	}
+	return wrapDBError(err, "Failed to bump version of graph '%s'", repo.graph)
+}
+
+// ArangoAPIKeyRepo is an APIKeyRepo storing keys in an ArangoDB collection.
+type ArangoAPIKeyRepo struct {
+	db arango.Database
+}
+
+func NewArangoAPIKeyRepo(db arango.Database) *ArangoAPIKeyRepo {
+	return &ArangoAPIKeyRepo{db: db}
+}
+
+func (repo *ArangoAPIKeyRepo) GetAPIKey(id string) (APIKey, error) {
+	key := APIKey{}
+	coll, err := repo.db.Collection(nil, APIKeyCollName)
+	if err != nil {
+		return key, wrapDBError(err, "API key '%s' not found", id)
+	}
+	if _, err := coll.ReadDocument(nil, id, &key); err != nil {
+		return key, wrapDBError(err, "API key '%s' not found", id)
+	}
+	return key, nil
+}
+
+func (repo *ArangoAPIKeyRepo) ListAPIKeys() ([]APIKey, error) {
+	query := "FOR k IN @@coll SORT k.createdAt RETURN k"
+	keys, err := queryDocuments[APIKey](repo.db, query, map[string]interface{}{"@coll": APIKeyCollName})
+	if err != nil {
+		return keys, wrapDBError(err, "Failed to query API keys")
+	}
+	return keys, nil
+}
+
+func (repo *ArangoAPIKeyRepo) CreateAPIKey(key APIKey) error {
+	if err := insertCreatingCollection(nil, repo.db, APIKeyCollName, key); err != nil {
+		return wrapDBError(err, "Failed to create API key '%s'", key.Id)
+	}
+	return nil
+}
+
+func (repo *ArangoAPIKeyRepo) RevokeAPIKey(id string) error {
+	coll, err := repo.db.Collection(nil, APIKeyCollName)
+	if err == nil {
+		_, err = coll.UpdateDocument(nil, id, map[string]interface{}{"revokedAt": time.Now().UTC()})
+	}
+	return wrapDBError(err, "Failed to revoke API key '%s'", id)
+}
+
+func (repo *ArangoAPIKeyRepo) AddAPIKeyUsage(usage map[string]APIKeyUsage) error {
+	query := `FOR id IN ATTRIBUTES(@usage)
+		LET u = @usage[id]
+		FOR k IN @@coll
+			FILTER k._key == id
+			UPDATE k WITH {
+				requests: k.requests + u.requests,
+				limited: k.limited + u.limited,
+				lastUsedAt: u.lastUsedAt
+			} IN @@coll`
+	bindVars := map[string]interface{}{"@coll": APIKeyCollName, "usage": usage}
+	return wrapDBError(execQuery(repo.db, query, bindVars), "Failed to record API key usage")
+}
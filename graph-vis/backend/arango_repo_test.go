@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+// fakeDatabase is an arango.Database holding documents in memory. Only the
+// methods used by the tests are implemented.
+type fakeDatabase struct {
+	arango.Database
+	colls map[string]*fakeCollection
+}
+
+func newFakeDatabase(names ...string) *fakeDatabase {
+	db := &fakeDatabase{colls: map[string]*fakeCollection{}}
+	for _, name := range names {
+		db.colls[name] = &fakeCollection{docs: []interface{}{}}
+	}
+	return db
+}
+
+func (db *fakeDatabase) Collection(ctx context.Context, name string) (arango.Collection, error) {
+	coll, ok := db.colls[name]
+	if !ok {
+		return nil, arango.ArangoError{HasError: true, Code: http.StatusNotFound, ErrorMessage: "collection not found"}
+	}
+	return coll, nil
+}
+
+type fakeCollection struct {
+	arango.Collection
+	docs []interface{}
+}
+
+func (coll *fakeCollection) CreateDocument(ctx context.Context, document interface{}) (arango.DocumentMeta, error) {
+	coll.docs = append(coll.docs, document)
+	return arango.DocumentMeta{}, nil
+}
+
+func TestArangoCreateTaxon(t *testing.T) {
+	db := newFakeDatabase("genus", "species")
+	repo := NewArangoTaxonRepo(db, Config{GraphName: "taxonomy", TaxonRanks: TaxonRanks})
+
+	// The service capitalises the rank, while collections are lower case.
+	taxon := Taxon{TaxonBase: TaxonBase{Rank: "Genus", Name: "Felis", Curated: true}, Id: "genus/felis"}
+	if err := repo.CreateTaxon(taxon, ""); err != nil {
+		t.Fatalf("Expected the taxon to be created, got %v", err)
+	}
+	if docs := db.colls["genus"].docs; len(docs) != 1 {
+		t.Errorf("Expected the taxon in the genus collection, got %v", docs)
+	}
+
+	taxon = Taxon{TaxonBase: TaxonBase{Rank: "Tribe", Name: "Felini"}, Id: "tribe/felini"}
+	var svcErr *SvcError
+	if err := repo.CreateTaxon(taxon, ""); !errors.As(err, &svcErr) || svcErr.Kind != ErrNotFound {
+		t.Errorf("Expected an unknown rank to be rejected, got %v", err)
+	}
+}
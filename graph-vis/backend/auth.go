@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	http "net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// jwksRefreshInterval limits how often the key set is reloaded for tokens
+// signed with an unknown key, whether or not the last load succeeded.
+const jwksRefreshInterval = time.Minute
+
+// JWKS is a JSON Web Key Set loaded from a file or URL. Keys are loaded on
+// first use and reloaded when a token names an unknown key, so signing keys
+// can be rotated without restarting.
+type JWKS struct {
+	source string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	attemptedAt time.Time
+	loadErr     error
+}
+
+func NewJWKS(source string, timeout time.Duration) *JWKS {
+	return &JWKS{source: source, client: &http.Client{Timeout: timeout}}
+}
+
+// Key returns the public key with the given key ID. A token without a key ID
+// can only be verified against a set of one key.
+func (ks *JWKS) Key(kid string) (interface{}, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.lookup(kid)
+	if !ok && time.Since(ks.attemptedAt) > jwksRefreshInterval {
+		ks.attemptedAt = time.Now()
+		ks.loadErr = ks.load()
+		key, ok = ks.lookup(kid)
+	}
+	if !ok && ks.loadErr != nil {
+		// The key may be in the set that could not be loaded.
+		return nil, &SvcError{Kind: ErrUnavailable, Message: "Failed to load signing keys", Err: ks.loadErr}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+	return key, nil
+}
+
+func (ks *JWKS) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *JWKS) load() error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(ks.source, "https://") || strings.HasPrefix(ks.source, "http://") {
+		data, err = ks.fetch()
+	} else {
+		data, err = os.ReadFile(ks.source)
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+func (ks *JWKS) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", ks.source, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// jwk is a JSON Web Key as defined by RFC 7517. Only the members of RSA and
+// EC public keys are read.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA and EC signing keys of a JSON Web Key Set by key
+// ID. Keys of other types or for encryption are skipped.
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key '%s': %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	// Conversion checks the point is on the curve.
+	if _, err := key.ECDH(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Principal is the authenticated user of a request.
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether the principal was granted the role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies bearer tokens signed with a key from a JWKS.
+type Authenticator struct {
+	keys       *JWKS
+	issuer     string
+	audience   string
+	rolesClaim string
+}
+
+// NewAuthenticator returns an authenticator for the configured JWKS, or nil if
+// none is configured.
+func NewAuthenticator(cfg Config) *Authenticator {
+	if cfg.AuthJWKS == "" {
+		return nil
+	}
+	return &Authenticator{
+		keys:       NewJWKS(cfg.AuthJWKS, 5*time.Second),
+		issuer:     cfg.AuthIssuer,
+		audience:   cfg.AuthAudience,
+		rolesClaim: cfg.AuthRolesClaim,
+	}
+}
+
+// Authenticate verifies the signature of a token, that it has not expired and
+// that it was issued by the configured issuer for the configured audience.
+func (a *Authenticator) Authenticate(tokenString string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method '%s'", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.Key(kid)
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil {
+			err = validationErr.Inner
+		}
+		return Principal{}, err
+	}
+	// Parsing only checks the expiry if there is one.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return Principal{}, errors.New("token has no expiry")
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return Principal{}, errors.New("unexpected token issuer")
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return Principal{}, errors.New("unexpected token audience")
+	}
+
+	principal := Principal{Roles: []string{}}
+	principal.Subject, _ = claims["sub"].(string)
+	switch roles := claims[a.rolesClaim].(type) {
+	case string:
+		// Space separated, as in the OAuth scope claim.
+		principal.Roles = strings.Fields(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if r, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, r)
+			}
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	http "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	echo "github.com/labstack/echo/v4"
+)
+
+// testSigningKey signs the bearer tokens of test requests. Its public key is
+// served to the router from a JWKS file.
+var testSigningKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// writeTestJWKS writes a JWKS holding the public key of testSigningKey.
+func writeTestJWKS(t *testing.T) string {
+	t.Helper()
+	pub := testSigningKey.PublicKey
+	jwks := map[string]interface{}{"keys": []map[string]string{{
+		"kid": "test",
+		"kty": "EC",
+		"use": "sig",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}}}
+	data, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+// testToken returns a token signed by testSigningKey for the test issuer and
+// audience, expiring in an hour, with the given claims added. Claims set to
+// nil are left out.
+func testToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{
+		"iss": "https://auth.example.org/",
+		"aud": []string{"https://api.example.org"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+		if v == nil {
+			delete(all, k)
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, all)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(testSigningKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestAuth(t *testing.T) {
+	router := newTestRouter(t)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherSigned, _ := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": "https://auth.example.org/", "aud": "https://api.example.org",
+		"exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"curator"},
+	}).SignedString(otherKey)
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": "https://auth.example.org/", "aud": "https://api.example.org",
+		"exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"curator"},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	curator := jwt.MapClaims{"sub": "bob", "roles": []string{"curator"}}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		auth   string
+		status int
+	}{
+		{"public read", http.MethodGet, "/api/v1/taxon/species/9", "", http.StatusOK},
+		{"no token", http.MethodDelete, "/api/v1/taxon/species/9", "", http.StatusUnauthorized},
+		{"not bearer", http.MethodDelete, "/api/v1/taxon/species/9", "Basic Ym9iOnB3", http.StatusUnauthorized},
+		{"malformed", http.MethodGet, "/api/v1/taxon/species/9", "Bearer abc", http.StatusUnauthorized},
+		{"other key", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + otherSigned, http.StatusUnauthorized},
+		{"unsigned", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + unsigned, http.StatusUnauthorized},
+		{"expired", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, jwt.MapClaims{"roles": []string{"curator"}, "exp": time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized},
+		{"no expiry", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, jwt.MapClaims{"roles": []string{"curator"}, "exp": nil}), http.StatusUnauthorized},
+		{"wrong issuer", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, jwt.MapClaims{"roles": []string{"curator"}, "iss": "https://evil.example.org/"}), http.StatusUnauthorized},
+		{"wrong audience", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, jwt.MapClaims{"roles": []string{"curator"}, "aud": "https://other.example.org"}), http.StatusUnauthorized},
+		{"missing role", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, jwt.MapClaims{"roles": "reader"}), http.StatusForbidden},
+		{"curator reads audit", http.MethodGet, "/api/v1/taxon/species/9/audit", "Bearer " + testToken(t, curator), http.StatusForbidden},
+		{"curator deletes", http.MethodDelete, "/api/v1/taxon/species/9", "Bearer " + testToken(t, curator), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.auth != "" {
+			req.Header.Set(echo.HeaderAuthorization, tt.auth)
+		}
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rec.Code, rec.Body.String())
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+			t.Errorf("%s: expected WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestAuthPrivateReads(t *testing.T) {
+	router := newTestRouterWith(t, func(cfg *Config) { cfg.AuthPublicReads = false })
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/species/9", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected read without token to fail with status 401, got %d", rec.Code)
+	}
+	var resp struct{ Data TaxonResponse }
+	if code := send(t, router, http.MethodGet, "/api/v1/taxon/species/9", "", &resp); code != http.StatusOK {
+		t.Errorf("Expected read with token to succeed, got status %d", code)
+	}
+}
+
+func TestJWKSReloadRateLimit(t *testing.T) {
+	fetches := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	// While the JWKS URL is down, it is fetched at most once per interval.
+	ks := NewJWKS(down.URL, time.Second)
+	for _, kid := range []string{"a", "b", ""} {
+		var svcErr *SvcError
+		if _, err := ks.Key(kid); !errors.As(err, &svcErr) || svcErr.Kind != ErrUnavailable {
+			t.Errorf("Expected the keys to be unavailable, got %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected one fetch, got %d", fetches)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kid": "oct", "kty": "oct", "k": "c2VjcmV0"},
+	}})
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatalf("Failed to parse JWKS: %v", err)
+	}
+	if len(keys) != 1 || !rsaKey.PublicKey.Equal(keys["rsa"]) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	bad := `{"keys": [{"kid": "ec", "kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`
+	if _, err := ParseJWKS([]byte(bad)); err == nil {
+		t.Errorf("Expected point off the curve to be rejected")
+	}
+}
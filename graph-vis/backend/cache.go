@@ -0,0 +1,207 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lookupCache is a bounded LRU cache of taxon lookups. Entries expire after
+// the TTL, and the whole cache is purged when the graph version changes, which
+// the scraper bumps after each crawl and curation after each change.
+type lookupCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	// generation is incremented by each purge, so that a lookup started
+	// before a purge does not add a stale result.
+	generation uint64
+	// current is the graph version last read, if known.
+	current GraphVersion
+	known   bool
+
+	hits, misses, evictions uint64
+
+	// version reads the graph version. checkMu serialises the checks, which
+	// run at most once per checkInterval.
+	version       func() (GraphVersion, error)
+	checkInterval time.Duration
+	checkMu       sync.Mutex
+	checkedAt     time.Time
+}
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// CacheStats are the counters of a lookup cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+func newLookupCache(size int, ttl time.Duration, checkInterval time.Duration, version func() (GraphVersion, error)) *lookupCache {
+	return &lookupCache{
+		size:          size,
+		ttl:           ttl,
+		order:         list.New(),
+		entries:       map[string]*list.Element{},
+		version:       version,
+		checkInterval: checkInterval,
+	}
+}
+
+// sync purges the cache if the graph version changed since the last check
+// and returns the generation that results may be added under. Lookups do not
+// wait for a check already running in another request.
+func (c *lookupCache) sync() uint64 {
+	if c.checkMu.TryLock() {
+		c.mu.Lock()
+		due := !c.known || time.Since(c.checkedAt) >= c.checkInterval
+		c.mu.Unlock()
+		if due {
+			// On error, keep serving the cache; the lookups themselves will
+			// report an unavailable database.
+			if v, err := c.version(); err == nil {
+				c.mu.Lock()
+				if v.Version != c.current.Version {
+					c.purge()
+				}
+				c.current, c.known = v, true
+				c.mu.Unlock()
+				c.checkedAt = time.Now()
+			}
+		}
+		c.checkMu.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Get returns the cached value of key, if any and not expired.
+func (c *lookupCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if ok && c.ttl > 0 && time.Now().After(elem.Value.(*cacheEntry).expiresAt) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Add caches value under key unless the cache was purged since generation,
+// evicting the least recently used entry if the cache is full.
+func (c *lookupCache) Add(key string, value interface{}, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *lookupCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// Version returns the graph version read by the last check, if any.
+func (c *lookupCache) Version() (GraphVersion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current, c.known
+}
+
+// Purge removes all entries and forgets the graph version, so that the next
+// lookup reads it again.
+func (c *lookupCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+	c.known = false
+}
+
+func (c *lookupCache) purge() {
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.generation++
+}
+
+func (c *lookupCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Entries: c.order.Len()}
+}
+
+// cached returns the result of load, cached under key. Errors are not cached.
+// Cached slices are shared between requests and must not be modified.
+func cached[T any](svc *TaxonSvc, key string, load func() (T, error)) (T, error) {
+	if svc.cache == nil {
+		return load()
+	}
+	generation := svc.cache.sync()
+	if value, ok := svc.cache.Get(key); ok {
+		return value.(T), nil
+	}
+	value, err := load()
+	if err == nil {
+		svc.cache.Add(key, value, generation)
+	}
+	return value, err
+}
+
+// cachedBatch looks up many taxa at once through the cache. Each taxon's value
+// is cached under the prefix and its document ID, as for single lookups, and
+// only the taxa missing from the cache are loaded. Taxa left out of the loaded
+// values are not cached.
+func cachedBatch[T any](svc *TaxonSvc, prefix string, ids []string, load func(ids []string) (map[string]T, error)) (map[string]T, error) {
+	if svc.cache == nil {
+		return load(ids)
+	}
+	generation := svc.cache.sync()
+	values := map[string]T{}
+	missing := []string{}
+	for _, id := range ids {
+		if value, ok := svc.cache.Get(prefix + id); ok {
+			values[id] = value.(T)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+	loaded, err := load(missing)
+	if err != nil {
+		return nil, err
+	}
+	for id, value := range loaded {
+		svc.cache.Add(prefix+id, value, generation)
+		values[id] = value
+	}
+	return values, nil
+}
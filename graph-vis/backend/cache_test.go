@@ -0,0 +1,149 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	version := func() (GraphVersion, error) { return GraphVersion{}, nil }
+	cache := newLookupCache(2, 0, time.Minute, version)
+	generation := cache.sync()
+	cache.Add("a", 1, generation)
+	cache.Add("b", 2, generation)
+	cache.Get("a")
+	cache.Add("c", 3, generation)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected the least recently used entry to be evicted")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Errorf("Expected a = 1, got %v %v", v, ok)
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 2, Misses: 1, Evictions: 1, Entries: 2}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Results of lookups started before a purge are dropped.
+	cache.Purge()
+	cache.Add("a", 1, generation)
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Expected a stale result not to be cached")
+	}
+
+	cache = newLookupCache(2, time.Millisecond, time.Minute, version)
+	cache.Add("a", 1, cache.sync())
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Expected the entry to expire")
+	}
+}
+
+// countingRepo counts the taxa lookups reaching the repo.
+type countingRepo struct {
+	*MemoryTaxonRepo
+	gets     int
+	children int
+	lineages int
+	// batched holds the IDs of each batch lookup of children.
+	batched [][]string
+}
+
+func (repo *countingRepo) Get(rank string, id string) (Taxon, error) {
+	repo.gets++
+	return repo.MemoryTaxonRepo.Get(rank, id)
+}
+
+func (repo *countingRepo) GetChildren(rank string, id string) ([]Taxon, error) {
+	repo.children++
+	return repo.MemoryTaxonRepo.GetChildren(rank, id)
+}
+
+func (repo *countingRepo) GetLineage(rank string, id string, root string) ([]Taxon, error) {
+	repo.lineages++
+	return repo.MemoryTaxonRepo.GetLineage(rank, id, root)
+}
+
+func (repo *countingRepo) GetChildrenBatch(ids []string) (map[string][]Taxon, error) {
+	repo.batched = append(repo.batched, ids)
+	return repo.MemoryTaxonRepo.GetChildrenBatch(ids)
+}
+
+func TestTaxonSvcCache(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	repo := &countingRepo{MemoryTaxonRepo: memory}
+	svc := NewTaxonSvc(repo, Config{TaxonRanks: TaxonRanks, CacheSize: 100, CacheTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if children, err := svc.GetChildren("genus", "7"); err != nil || len(children) != 2 {
+			t.Fatalf("Expected 2 children, got %v %v", children, err)
+		}
+	}
+	if repo.gets != 1 || repo.children != 1 {
+		t.Errorf("Expected one lookup of each, got %d gets and %d children", repo.gets, repo.children)
+	}
+	if stats := svc.cache.Stats(); stats.Hits != 4 || stats.Misses != 2 {
+		t.Errorf("Expected 4 hits and 2 misses, got %+v", stats)
+	}
+	if _, err := svc.Get("genus", "missing"); err == nil {
+		t.Fatalf("Expected an error for a missing taxon")
+	}
+	svc.Get("genus", "missing")
+	if repo.gets != 3 {
+		t.Errorf("Expected errors not to be cached, got %d gets", repo.gets)
+	}
+
+	// A crawl or another instance bumping the graph version purges the cache.
+	repo.BumpVersion()
+	svc.GetChildren("genus", "7")
+	if repo.children != 2 {
+		t.Errorf("Expected the cache to be purged, got %d children lookups", repo.children)
+	}
+
+	// Curation purges the cache.
+	if err := svc.DeleteTaxon("curator", "species", "9", ""); err != nil {
+		t.Fatalf("Failed to delete taxon: %v", err)
+	}
+	if children, err := svc.GetChildren("genus", "7"); err != nil || len(children) != 1 {
+		t.Errorf("Expected 1 child after deletion, got %v %v", children, err)
+	}
+}
+
+func TestTaxonSvcCacheBatch(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	repo := &countingRepo{MemoryTaxonRepo: memory}
+	svc := NewTaxonSvc(repo, Config{TaxonRanks: TaxonRanks, CacheSize: 100, CacheTTL: time.Minute})
+
+	// Batches only load the taxa not cached by single or earlier batch lookups.
+	svc.GetChildren("genus", "7")
+	for i := 0; i < 2; i++ {
+		children, err := svc.GetChildrenBatch([]string{"genus/7", "genus/8", "species/9"})
+		if err != nil || len(children["genus/7"]) != 2 || len(children["genus/8"]) != 1 {
+			t.Fatalf("Unexpected children %v %v", children, err)
+		}
+		if leaf, ok := children["species/9"]; !ok || leaf == nil {
+			t.Errorf("Expected an empty list of children, got %v", leaf)
+		}
+	}
+	want := [][]string{{"genus/8", "species/9"}}
+	if !reflect.DeepEqual(repo.batched, want) {
+		t.Errorf("Expected batch lookups %v, got %v", want, repo.batched)
+	}
+	if children, err := svc.GetChildren("species", "9"); err != nil || children == nil || repo.children != 1 {
+		t.Errorf("Expected the batched leaf to be cached, got %v %v and %d lookups", children, err, repo.children)
+	}
+
+	// Single lookups use the lineages cached by batches.
+	if _, err := svc.GetLineageBatch([]string{"species/9"}); err != nil {
+		t.Fatalf("Failed to get lineages: %v", err)
+	}
+	if lineage, err := svc.GetLineage("species", "9"); err != nil || len(lineage) == 0 || repo.lineages != 0 {
+		t.Errorf("Expected the cached lineage, got %v %v and %d lookups", lineage, err, repo.lineages)
+	}
+}
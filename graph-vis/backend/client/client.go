@@ -0,0 +1,518 @@
+// Package client is a Go client for the backend API. Responses use the models
+// of package api, which the server shares.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/api"
+)
+
+const (
+	// HeaderAPIKey carries an API key, to be rate limited by key rather than
+	// by IP.
+	HeaderAPIKey = "X-API-Key"
+
+	defaultRetries    = 3
+	defaultBackoff    = 200 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// Client calls the backend API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	apiKey     string
+	graph      string
+	asOf       string
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, by default
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken sends a bearer token with each request, needed when the server
+// does not allow anonymous reads.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithAPIKey sends an API key with each request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithRetries sets how many times a failed request is retried, waiting
+// backoff before the first retry and twice as long before each next one. By
+// default requests are retried 3 times, starting after 200ms.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = retries, backoff }
+}
+
+// New creates a client of the server at baseURL, e.g. https://example.org.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL '%s'", baseURL)
+	}
+	c := &Client{
+		baseURL:    strings.TrimSuffix(u.String(), "/") + "/api/v1",
+		httpClient: http.DefaultClient,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Graph returns a copy of the client querying the named graph rather than
+// the default one.
+func (c *Client) Graph(name string) *Client {
+	clone := *c
+	clone.graph = name
+	return &clone
+}
+
+// AsOf returns a copy of the client answering as of a snapshot, given by its
+// ID, a timestamp or a date.
+func (c *Client) AsOf(asOf string) *Client {
+	clone := *c
+	clone.asOf = asOf
+	return &clone
+}
+
+// Get returns a taxon by rank and ID.
+func (c *Client) Get(ctx context.Context, rank string, id string) (taxon api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, taxonPath(rank, id, ""), nil, &taxon)
+	return taxon, err
+}
+
+// GetByWikidataId returns the taxon with a Wikidata QID, e.g. Q146.
+func (c *Client) GetByWikidataId(ctx context.Context, qid string) (taxon api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, "/taxon/by-wikidata/"+url.PathEscape(qid), nil, &taxon)
+	return taxon, err
+}
+
+// GetByUrl returns the taxon scraped from a Wikipedia page, given by URL or
+// title.
+func (c *Client) GetByUrl(ctx context.Context, page string) (taxon api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, "/taxon/by-url", url.Values{"url": {page}}, &taxon)
+	return taxon, err
+}
+
+// Children returns all the children of a taxon. Use IterChildren to page
+// through taxa with many children.
+func (c *Client) Children(ctx context.Context, rank string, id string) (taxa []api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, taxonPath(rank, id, "/children"), nil, &taxa)
+	return taxa, err
+}
+
+// IterChildren pages through the children of a taxon, pageSize at a time, up
+// to api.MaxChildrenOffset plus a page.
+func (c *Client) IterChildren(ctx context.Context, rank string, id string, pageSize int) *TaxonIterator {
+	return newTaxonIterator(ctx, pageSize, func(ctx context.Context, offset int, limit int) (taxa []api.TaxonResponse, err error) {
+		err = c.getJSON(ctx, taxonPath(rank, id, "/children"), pageQuery(url.Values{}, offset, limit), &taxa)
+		return taxa, err
+	}, api.MaxChildrenLimit, api.MaxChildrenOffset)
+}
+
+// Lineage returns the taxa from the root down to a taxon.
+func (c *Client) Lineage(ctx context.Context, rank string, id string) (taxa []api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, taxonPath(rank, id, "/lineage"), nil, &taxa)
+	return taxa, err
+}
+
+// CrossRefs returns the external checklist matches of a taxon.
+func (c *Client) CrossRefs(ctx context.Context, rank string, id string) (crossRefs []api.CrossRef, err error) {
+	err = c.getJSON(ctx, taxonPath(rank, id, "/crossrefs"), nil, &crossRefs)
+	return crossRefs, err
+}
+
+// SearchQuery selects the taxa returned by Search.
+type SearchQuery struct {
+	// Q is a name or part of a name.
+	Q string
+	// Rank restricts the search to a rank if set.
+	Rank string
+	// Offset is the number of results to skip.
+	Offset int
+	// Limit is the maximum number of results, 20 if zero.
+	Limit int
+}
+
+func (q SearchQuery) values() url.Values {
+	query := url.Values{"q": {q.Q}}
+	if q.Rank != "" {
+		query.Set("rank", q.Rank)
+	}
+	return pageQuery(query, q.Offset, q.Limit)
+}
+
+// Search returns a page of taxa whose name contains the query.
+func (c *Client) Search(ctx context.Context, q SearchQuery) (taxa []api.TaxonResponse, err error) {
+	err = c.getJSON(ctx, "/search", q.values(), &taxa)
+	return taxa, err
+}
+
+// IterSearch pages through the taxa whose name contains the query, pageSize
+// at a time. The server serves at most the first results up to
+// api.MaxSearchOffset plus a page, after which the iteration ends.
+func (c *Client) IterSearch(ctx context.Context, q string, rank string, pageSize int) *TaxonIterator {
+	return newTaxonIterator(ctx, pageSize, func(ctx context.Context, offset int, limit int) ([]api.TaxonResponse, error) {
+		return c.Search(ctx, SearchQuery{Q: q, Rank: rank, Offset: offset, Limit: limit})
+	}, api.MaxSearchLimit, api.MaxSearchOffset)
+}
+
+// MRCA returns the most recent common ancestor of taxa referenced as
+// `<rank>/<id>`.
+func (c *Client) MRCA(ctx context.Context, refs ...string) (mrca api.MRCAResponse, err error) {
+	err = c.getJSON(ctx, "/mrca", url.Values{"taxon": refs}, &mrca)
+	return mrca, err
+}
+
+// Ranks returns the taxonomic ranks in order with their taxon counts.
+func (c *Client) Ranks(ctx context.Context) (ranks []api.Rank, err error) {
+	err = c.getJSON(ctx, "/ranks", nil, &ranks)
+	return ranks, err
+}
+
+// Snapshots returns the completed crawl snapshots.
+func (c *Client) Snapshots(ctx context.Context) (snapshots []api.Snapshot, err error) {
+	err = c.getJSON(ctx, "/snapshots", nil, &snapshots)
+	return snapshots, err
+}
+
+// DiffSnapshots returns the taxa added, removed, renamed and moved between
+// two snapshots given by ID. By default to is the latest snapshot and from the
+// one before to.
+func (c *Client) DiffSnapshots(ctx context.Context, from string, to string) (diff api.SnapshotDiff, err error) {
+	query := url.Values{}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+	err = c.getJSON(ctx, "/snapshots/diff", query, &diff)
+	return diff, err
+}
+
+// CrossRefQuery selects the checklist matches returned by ListCrossRefs.
+type CrossRefQuery struct {
+	// Source restricts the matches to a checklist, e.g. gbif, if set.
+	Source string
+	// Status restricts the matches to a status, e.g. conflicting, if set.
+	Status string
+	// Offset is the number of results to skip.
+	Offset int
+	// Limit is the maximum number of results, 100 if zero.
+	Limit int
+}
+
+func (q CrossRefQuery) values() url.Values {
+	query := url.Values{}
+	if q.Source != "" {
+		query.Set("source", q.Source)
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	return pageQuery(query, q.Offset, q.Limit)
+}
+
+// ListCrossRefs returns a page of the checklist matches of all taxa, ordered
+// by taxon.
+func (c *Client) ListCrossRefs(ctx context.Context, q CrossRefQuery) (crossRefs []api.CrossRef, err error) {
+	err = c.getJSON(ctx, "/crossrefs", q.values(), &crossRefs)
+	return crossRefs, err
+}
+
+// IterCrossRefs pages through the checklist matches of all taxa, optionally
+// restricted to a source and status, pageSize at a time, up to
+// api.MaxCrossRefsOffset plus a page.
+func (c *Client) IterCrossRefs(ctx context.Context, source string, status string, pageSize int) *CrossRefIterator {
+	return &CrossRefIterator{newPager(ctx, pageSize, func(ctx context.Context, offset int, limit int) ([]api.CrossRef, error) {
+		return c.ListCrossRefs(ctx, CrossRefQuery{Source: source, Status: status, Offset: offset, Limit: limit})
+	}, api.MaxCrossRefsLimit, api.MaxCrossRefsOffset)}
+}
+
+// Audit returns the curation changes to a taxon, oldest first. It needs a
+// token with the server's admin role.
+func (c *Client) Audit(ctx context.Context, rank string, id string) (entries []api.AuditEntry, err error) {
+	err = c.getJSON(ctx, taxonPath(rank, id, "/audit"), nil, &entries)
+	return entries, err
+}
+
+// CreateTaxon adds a curated taxon. Curation needs a token with the server's
+// write role. Creating is not retried unless the server rejected the request
+// without handling it, as it may have succeeded.
+func (c *Client) CreateTaxon(ctx context.Context, in api.TaxonInput) (taxon api.TaxonResponse, err error) {
+	err = c.write(ctx, http.MethodPost, "/taxon", nil, in, &taxon)
+	return taxon, err
+}
+
+// UpdateTaxon changes the fields of a taxon set in the patch.
+func (c *Client) UpdateTaxon(ctx context.Context, rank string, id string, patch api.TaxonPatch) (taxon api.TaxonResponse, err error) {
+	err = c.write(ctx, http.MethodPatch, taxonPath(rank, id, ""), nil, patch, &taxon)
+	return taxon, err
+}
+
+// DeleteTaxon removes a taxon.
+func (c *Client) DeleteTaxon(ctx context.Context, rank string, id string) error {
+	return c.write(ctx, http.MethodDelete, taxonPath(rank, id, ""), nil, nil, nil)
+}
+
+// MergeTaxon removes a taxon, moving its children to the taxon of the same
+// rank referenced as `<rank>/<id>` by into.
+func (c *Client) MergeTaxon(ctx context.Context, rank string, id string, into string) error {
+	return c.write(ctx, http.MethodDelete, taxonPath(rank, id, ""), url.Values{"mergeInto": {into}}, nil, nil)
+}
+
+// Graphs returns the graphs served, the default one first.
+func (c *Client) Graphs(ctx context.Context) (graphs []api.GraphConfig, err error) {
+	resp, err := c.do(ctx, http.MethodGet, c.baseURL+"/graphs", nil)
+	if err == nil {
+		err = decodeData(resp, &graphs)
+	}
+	return graphs, err
+}
+
+// ErrIncompleteExport is returned reading the end of an export the server
+// failed to finish, e.g. as it lost its database connection.
+var ErrIncompleteExport = errors.New("export incomplete")
+
+// Export streams the subtree below a taxon in a format such as newick,
+// graphml, dot, cytoscape-json or csv. The caller must close the stream.
+// Reading it ends with ErrIncompleteExport rather than io.EOF if the server
+// failed to finish.
+func (c *Client) Export(ctx context.Context, rank string, id string, format string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.url(taxonPath(rank, id, "/export"), url.Values{"format": {format}}), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &exportBody{resp: resp}, nil
+}
+
+// exportBody checks the trailer the server sends once an export is complete,
+// which can only be read at the end of the body.
+type exportBody struct {
+	resp *http.Response
+}
+
+func (b *exportBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	if err == io.EOF && b.resp.Trailer.Get(api.HeaderExportStatus) != api.ExportComplete {
+		err = ErrIncompleteExport
+	}
+	return n, err
+}
+
+func (b *exportBody) Close() error {
+	return b.resp.Body.Close()
+}
+
+// Subtree walks the subtree below a taxon, in depth-first pre-order, as it
+// is streamed by the server. The caller must close the iterator.
+func (c *Client) Subtree(ctx context.Context, rank string, id string) *SubtreeIterator {
+	body, err := c.Export(ctx, rank, id, "csv")
+	return newSubtreeIterator(body, err)
+}
+
+func taxonPath(rank string, id string, suffix string) string {
+	return "/taxon/" + url.PathEscape(rank) + "/" + url.PathEscape(id) + suffix
+}
+
+func pageQuery(query url.Values, offset int, limit int) url.Values {
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
+
+// url returns the URL of a graph route.
+func (c *Client) url(path string, query url.Values) string {
+	u := c.baseURL
+	if c.graph != "" {
+		u += "/graphs/" + url.PathEscape(c.graph)
+	}
+	u += path
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.asOf != "" {
+		query.Set("asOf", c.asOf)
+	}
+	if encoded := query.Encode(); encoded != "" {
+		u += "?" + encoded
+	}
+	return u
+}
+
+// getJSON reads the data of the response to a graph route into v.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, c.url(path, query), nil)
+	if err != nil {
+		return err
+	}
+	return decodeData(resp, v)
+}
+
+// write sends in, unless nil, as the JSON body of a request to a graph route
+// and reads the data of the response into out, unless nil.
+func (c *Client) write(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	resp, err := c.do(ctx, method, c.url(path, query), body)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		resp.Body.Close()
+		return nil
+	}
+	return decodeData(resp, out)
+}
+
+func decodeData(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	body := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// do sends a request, retrying on network errors and on responses telling to
+// try again later as retryable allows, and returns the response if successful.
+// Other failures are returned as *api.APIError.
+func (c *Client) do(ctx context.Context, method string, u string, body []byte) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, u, body)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		wait := backoff
+		if err == nil {
+			err = readError(resp)
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+			}
+		}
+		if attempt >= c.retries || !retryable(ctx, method, err) {
+			return nil, err
+		}
+		if wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method string, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(HeaderAPIKey, c.apiKey)
+	}
+	return c.httpClient.Do(req)
+}
+
+// readError reads the error envelope of a failed response.
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var body struct {
+		Error *api.APIError `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(data, &body) != nil || body.Error == nil {
+		// Not from the backend, e.g. from a proxy in front of it.
+		code := strings.ReplaceAll(strings.ToLower(http.StatusText(resp.StatusCode)), " ", "_")
+		body.Error = &api.APIError{Code: code, Message: fmt.Sprintf("Unexpected response status %d", resp.StatusCode)}
+	}
+	body.Error.Status = resp.StatusCode
+	return body.Error
+}
+
+// retryable tells whether a failed request may succeed if tried again: on
+// transport errors, such as a refused connection, and on responses telling to
+// try again later. Writes may have been applied by the server before such a
+// failure, so they are only retried once rate limited, which rejects requests
+// before they are handled.
+func retryable(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) {
+		return method == http.MethodGet
+	}
+	switch apiErr.Status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header, given in seconds.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"backend/api"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return c
+}
+
+func TestRetries(t *testing.T) {
+	attempts, failures := 0, 2
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if failures > 0 {
+			failures--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"id":"genus/7","rank":"Genus","name":"Felis","url":"https://en.wikipedia.org/wiki/Felis"}}`)
+	})
+	taxon, err := c.Get(context.Background(), "genus", "7")
+	if err != nil || taxon.Id != "genus/7" || taxon.Name != "Felis" {
+		t.Fatalf("Expected Felis, got %+v %v", taxon, err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Retries are given up after the configured number.
+	attempts, failures = 0, 10
+	_, err = c.Get(context.Background(), "genus", "7")
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable || apiErr.Code != "service_unavailable" {
+		t.Errorf("Expected a service_unavailable error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	attempts := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"not_found","message":"No genus with ID '999'"}}`)
+	})
+	_, err := c.Get(context.Background(), "genus", "999")
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.Message != "No genus with ID '999'" {
+		t.Errorf("Expected a not_found error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d attempts", attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "genus", "7"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the request to be canceled, got %v", err)
+	}
+}
+
+func TestTaxonIterator(t *testing.T) {
+	var queries []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/graphs/snapshot/taxon/genus/7/children" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		queries = append(queries, r.URL.RawQuery)
+		switch r.URL.Query().Get("offset") {
+		case "":
+			fmt.Fprint(w, `{"data":[{"id":"species/1"},{"id":"species/2"}]}`)
+		case "2":
+			fmt.Fprint(w, `{"data":[{"id":"species/3"}]}`)
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+	})
+	it := c.Graph("snapshot").AsOf("2026-10-01").IterChildren(context.Background(), "genus", "7", 2)
+	ids := []string{}
+	for it.Next() {
+		ids = append(ids, it.Taxon().Id)
+	}
+	if err := it.Err(); err != nil || fmt.Sprint(ids) != "[species/1 species/2 species/3]" {
+		t.Errorf("Unexpected children %v %v", ids, err)
+	}
+	if fmt.Sprint(queries) != "[asOf=2026-10-01&limit=2 asOf=2026-10-01&limit=2&offset=2]" {
+		t.Errorf("Unexpected queries %v", queries)
+	}
+}
+
+func TestTaxonIteratorLimits(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if offset > api.MaxSearchOffset || limit > api.MaxSearchLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":"bad_request","message":"Offset or limit out of range"}}`)
+			return
+		}
+		// There are more matches than the server serves.
+		taxa := []api.TaxonResponse{}
+		for i := offset; i < offset+limit; i++ {
+			taxa = append(taxa, api.TaxonResponse{Id: fmt.Sprintf("species/%d", i)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": taxa})
+	})
+	it := c.IterSearch(context.Background(), "a", "", 500)
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if err := it.Err(); err != nil || count != api.MaxSearchOffset+api.MaxSearchLimit {
+		t.Errorf("Expected the iteration to end at the server's cap, got %d taxa and %v", count, err)
+	}
+}
+
+func TestSubtreeIncomplete(t *testing.T) {
+	complete := true
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", api.HeaderExportStatus)
+		fmt.Fprint(w, "id,parent_id,rank,name,url,depth\ngenus/7,,Genus,Felis,https://en.wikipedia.org/wiki/Felis,0\n")
+		if complete {
+			w.Header().Set(api.HeaderExportStatus, api.ExportComplete)
+		}
+	})
+	walk := func() (int, error) {
+		it := c.Subtree(context.Background(), "genus", "7")
+		defer it.Close()
+		count := 0
+		for it.Next() {
+			count++
+		}
+		return count, it.Err()
+	}
+	if count, err := walk(); count != 1 || err != nil {
+		t.Errorf("Expected 1 taxon, got %d and %v", count, err)
+	}
+	complete = false
+	if _, err := walk(); !errors.Is(err, ErrIncompleteExport) {
+		t.Errorf("Expected a truncated export to be reported, got %v", err)
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	attempts, status := 0, 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if status == 0 {
+			// Drop the connection, as if the server failed after handling
+			// the request.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(status)
+	})
+	in := api.TaxonInput{Rank: "species", Name: "Felis catus", Parent: "genus/7"}
+	for _, tt := range []struct {
+		status   int
+		attempts int
+	}{
+		{0, 1},
+		{http.StatusServiceUnavailable, 1},
+		// Rate limited requests are rejected before they are handled.
+		{http.StatusTooManyRequests, 3},
+	} {
+		attempts, status = 0, tt.status
+		if _, err := c.CreateTaxon(context.Background(), in); err == nil {
+			t.Errorf("Expected an error for status %d", tt.status)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("Status %d: expected %d attempts, got %d", tt.status, tt.attempts, attempts)
+		}
+	}
+}
+
+func TestCuration(t *testing.T) {
+	var requests []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the token to be sent, got %q", r.Header.Get("Authorization"))
+		}
+		switch r.Method {
+		case http.MethodPost, http.MethodPatch:
+			var body map[string]interface{}
+			if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&body) != nil {
+				t.Errorf("Expected a JSON body, got %s", r.Header.Get("Content-Type"))
+			}
+			fmt.Fprintf(w, `{"data":{"id":"species/felis-catus","name":%q}}`, body["name"])
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fmt.Fprint(w, `{"data":[{"taxonId":"species/felis-catus","action":"create","user":"curator"}]}`)
+		}
+	})
+	WithToken("secret")(c)
+	ctx := context.Background()
+
+	taxon, err := c.CreateTaxon(ctx, api.TaxonInput{Rank: "species", Name: "Felis catus"})
+	if err != nil || taxon.Name != "Felis catus" {
+		t.Errorf("Unexpected created taxon %+v %v", taxon, err)
+	}
+	name := "Felis silvestris catus"
+	if taxon, err = c.UpdateTaxon(ctx, "species", "felis-catus", api.TaxonPatch{Name: &name}); err != nil || taxon.Name != name {
+		t.Errorf("Unexpected updated taxon %+v %v", taxon, err)
+	}
+	if entries, err := c.Audit(ctx, "species", "felis-catus"); err != nil || len(entries) != 1 || entries[0].Action != "create" {
+		t.Errorf("Unexpected audit entries %+v %v", entries, err)
+	}
+	if err := c.MergeTaxon(ctx, "species", "felis-catus", "species/9"); err != nil {
+		t.Errorf("Failed to merge taxon: %v", err)
+	}
+	if err := c.DeleteTaxon(ctx, "species", "9"); err != nil {
+		t.Errorf("Failed to delete taxon: %v", err)
+	}
+	want := "[POST /api/v1/taxon PATCH /api/v1/taxon/species/felis-catus GET /api/v1/taxon/species/felis-catus/audit " +
+		"DELETE /api/v1/taxon/species/felis-catus?mergeInto=species%2F9 DELETE /api/v1/taxon/species/9]"
+	if fmt.Sprint(requests) != want {
+		t.Errorf("Expected requests %s, got %v", want, requests)
+	}
+}
+
+func TestCrossRefIterator(t *testing.T) {
+	var queries []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/crossrefs" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		queries = append(queries, r.URL.RawQuery)
+		switch r.URL.Query().Get("offset") {
+		case "":
+			fmt.Fprint(w, `{"data":[{"taxonId":"species/10"},{"taxonId":"species/9"}]}`)
+		case "2":
+			fmt.Fprint(w, `{"data":[]}`)
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+	})
+	it := c.IterCrossRefs(context.Background(), "gbif", "", 2)
+	ids := []string{}
+	for it.Next() {
+		ids = append(ids, it.CrossRef().TaxonId)
+	}
+	if err := it.Err(); err != nil || fmt.Sprint(ids) != "[species/10 species/9]" {
+		t.Errorf("Unexpected matches %v %v", ids, err)
+	}
+	if fmt.Sprint(queries) != "[limit=2&source=gbif limit=2&offset=2&source=gbif]" {
+		t.Errorf("Unexpected queries %v", queries)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != "/api/v1/snapshots/diff?to=animal_kingdom-20261001T000000Z" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, `{"data":{"from":{"id":"animal_kingdom-20260901T000000Z"},"to":{"id":"animal_kingdom-20261001T000000Z"},`+
+			`"added":[{"taxonId":"species/felis-silvestris","validFrom":2,"validTo":null}],"removed":[],"renamed":[],"moved":[]}}`)
+	})
+	diff, err := c.DiffSnapshots(context.Background(), "", "animal_kingdom-20261001T000000Z")
+	if err != nil || diff.From.Id != "animal_kingdom-20260901T000000Z" || len(diff.Added) != 1 || diff.Added[0].TaxonId != "species/felis-silvestris" {
+		t.Errorf("Unexpected diff %+v %v", diff, err)
+	}
+}
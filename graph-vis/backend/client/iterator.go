@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"backend/api"
+)
+
+const defaultPageSize = 100
+
+// pager pages through a list, requesting the next page as the previous one is
+// used up.
+type pager[T any] struct {
+	ctx      context.Context
+	fetch    func(ctx context.Context, offset int, limit int) ([]T, error)
+	pageSize int
+	// maxOffset is the largest offset the server accepts.
+	maxOffset int
+	offset    int
+	page      []T
+	item      T
+	last      bool
+	err       error
+}
+
+func newPager[T any](ctx context.Context, pageSize int, fetch func(ctx context.Context, offset int, limit int) ([]T, error), maxLimit int, maxOffset int) pager[T] {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxLimit {
+		pageSize = maxLimit
+	}
+	return pager[T]{ctx: ctx, fetch: fetch, pageSize: pageSize, maxOffset: maxOffset}
+}
+
+// Next advances to the next item, fetching a page if needed, and reports
+// whether there is one.
+func (p *pager[T]) Next() bool {
+	if p.err != nil {
+		return false
+	}
+	if len(p.page) == 0 {
+		if p.last {
+			return false
+		}
+		p.page, p.err = p.fetch(p.ctx, p.offset, p.pageSize)
+		if p.err != nil {
+			return false
+		}
+		p.offset += len(p.page)
+		// A short page is the last one, as is one the server would not serve
+		// the next of.
+		p.last = len(p.page) < p.pageSize || p.offset > p.maxOffset
+		if len(p.page) == 0 {
+			return false
+		}
+	}
+	p.item, p.page = p.page[0], p.page[1:]
+	return true
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (p *pager[T]) Err() error {
+	return p.err
+}
+
+// TaxonIterator pages through a list of taxa, requesting the next page as
+// the previous one is used up:
+//
+//	it := c.IterChildren(ctx, "genus", "7", 100)
+//	for it.Next() {
+//		taxon := it.Taxon()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type TaxonIterator struct {
+	pager[api.TaxonResponse]
+}
+
+func newTaxonIterator(ctx context.Context, pageSize int, fetch func(ctx context.Context, offset int, limit int) ([]api.TaxonResponse, error), maxLimit int, maxOffset int) *TaxonIterator {
+	return &TaxonIterator{newPager(ctx, pageSize, fetch, maxLimit, maxOffset)}
+}
+
+// Taxon returns the current taxon.
+func (it *TaxonIterator) Taxon() api.TaxonResponse {
+	return it.item
+}
+
+// CrossRefIterator pages through a list of checklist matches as
+// TaxonIterator pages through taxa.
+type CrossRefIterator struct {
+	pager[api.CrossRef]
+}
+
+// CrossRef returns the current checklist match.
+func (it *CrossRefIterator) CrossRef() api.CrossRef {
+	return it.item
+}
+
+// SubtreeNode is a taxon visited while walking a subtree. Only the ID, rank,
+// name and URL of the taxon are set. A taxon with several parents in the
+// subtree is visited once for each, but only walked below the first time.
+type SubtreeNode struct {
+	Taxon api.TaxonResponse
+	// ParentId is the ID of the parent taxon, empty for the subtree root.
+	ParentId string
+	// Depth is the number of ranks below the subtree root.
+	Depth int
+}
+
+// SubtreeIterator walks a subtree streamed as a CSV export.
+type SubtreeIterator struct {
+	body io.ReadCloser
+	r    *csv.Reader
+	node SubtreeNode
+	err  error
+}
+
+func newSubtreeIterator(body io.ReadCloser, err error) *SubtreeIterator {
+	it := &SubtreeIterator{body: body, err: err}
+	if err != nil {
+		return it
+	}
+	it.r = csv.NewReader(body)
+	it.r.FieldsPerRecord = 6
+	it.r.ReuseRecord = true
+	// Skip the header.
+	if _, err := it.r.Read(); err != nil {
+		it.err = fmt.Errorf("failed to read subtree: %w", err)
+	}
+	return it
+}
+
+// Next advances to the next taxon and reports whether there is one.
+func (it *SubtreeIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	record, err := it.r.Read()
+	if errors.Is(err, io.EOF) {
+		return false
+	}
+	if err != nil {
+		it.err = fmt.Errorf("failed to read subtree: %w", err)
+		return false
+	}
+	depth, err := strconv.Atoi(record[5])
+	if err != nil {
+		it.err = fmt.Errorf("failed to read subtree: invalid depth '%s'", record[5])
+		return false
+	}
+	it.node = SubtreeNode{
+		Taxon:    api.TaxonResponse{TaxonBase: api.TaxonBase{Rank: record[2], Name: record[3], Url: record[4]}, Id: record[0]},
+		ParentId: record[1],
+		Depth:    depth,
+	}
+	return true
+}
+
+// Node returns the current taxon.
+func (it *SubtreeIterator) Node() SubtreeNode {
+	return it.node
+}
+
+// Err returns the error that stopped the walk, if any.
+func (it *SubtreeIterator) Err() error {
+	return it.err
+}
+
+// Close closes the stream.
+func (it *SubtreeIterator) Close() error {
+	if it.body == nil {
+		return nil
+	}
+	return it.body.Close()
+}
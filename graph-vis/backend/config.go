@@ -1,6 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	http "net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
 	"github.com/spf13/viper"
 )
 
@@ -12,25 +24,212 @@ const (
 	FamilyCollName  = "family"
 	GenusCollName   = "genus"
 	SpeciesCollName = "species"
+
+	CrossRefCollName     = "crossrefs"
+	SnapshotCollName     = "snapshots"
+	TaxonVersionCollName = "taxonVersions"
+	DeletedTaxonCollName = "deletedTaxa"
+	AuditCollName        = "audit"
+	APIKeyCollName       = "apiKeys"
+	GraphVersionCollName = "graphVersions"
 )
 
+// TaxonRanks lists the default taxonomic ranks in order from the root of the
+// hierarchy. Each rank has a collection of the same name.
+var TaxonRanks = []string{KingdomCollName, PhylumCollName, ClassCollName, OrderCollName, FamilyCollName, GenusCollName, SpeciesCollName}
+
 // Config stores the app configuration.
 type Config struct {
+	// ListenAddr is the address the server listens on. It defaults to all
+	// interfaces on PORT.
+	ListenAddr string `mapstructure:"LISTEN_ADDR"`
+	Port       string `mapstructure:"PORT"`
+	// CORSAllowOrigins lists the origins of browser clients, or `*` for any.
+	CORSAllowOrigins []string `mapstructure:"CORS_ALLOW_ORIGINS"`
+	CORSAllowMethods []string `mapstructure:"CORS_ALLOW_METHODS"`
+	// Server timeouts. WriteTimeout must allow for slow exports. Zero means
+	// no timeout.
+	ReadTimeout       time.Duration `mapstructure:"READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `mapstructure:"READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `mapstructure:"WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `mapstructure:"IDLE_TIMEOUT"`
+	// TLSCertFile and TLSKeyFile are PEM files. The server uses TLS if both
+	// are set.
+	TLSCertFile string `mapstructure:"TLS_CERT_FILE"`
+	TLSKeyFile  string `mapstructure:"TLS_KEY_FILE"`
+	// TLSReloadInterval is how often the certificate files are checked for
+	// changes. Zero disables reloading.
+	TLSReloadInterval time.Duration `mapstructure:"TLS_RELOAD_INTERVAL"`
+	// HTTPRedirectAddr is the address of a plain HTTP listener redirecting
+	// to HTTPS when TLS is enabled.
+	HTTPRedirectAddr string `mapstructure:"HTTP_REDIRECT_ADDR"`
+	// MetricsAddr is the address of the admin listener serving Prometheus
+	// metrics, apart from the API. Empty disables it.
+	MetricsAddr string `mapstructure:"METRICS_ADDR"`
+	// HSTSMaxAge enables the Strict-Transport-Security header on HTTPS
+	// responses.
+	HSTSMaxAge            time.Duration `mapstructure:"HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains bool          `mapstructure:"HSTS_INCLUDE_SUBDOMAINS"`
+	// BodyLimit is the maximum request body size, e.g. `1M`.
+	BodyLimit string `mapstructure:"BODY_LIMIT"`
+	// GzipLevel is the compression level of responses from 1 to 9, or -1 for
+	// the default level. Zero disables compression.
+	GzipLevel int `mapstructure:"GZIP_LEVEL"`
+
 	DatabaseUrl      string `mapstructure:"DATABASE_URL"`
 	DatabaseUser     string `mapstructure:"DATABASE_USER"`
 	DatabasePassword string `mapstructure:"DATABASE_PASSWORD"`
 	DatabaseName     string `mapstructure:"DATABASE_NAME"`
+	// DatabaseConnectTimeout is how long to retry connecting at startup.
+	DatabaseConnectTimeout time.Duration `mapstructure:"DB_CONNECT_TIMEOUT"`
+	// ReadyTimeout bounds the database check of the readiness probe.
+	ReadyTimeout time.Duration `mapstructure:"READY_TIMEOUT"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after SIGTERM.
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	// ShutdownDrainDelay is how long the server keeps serving while reporting
+	// not ready after SIGTERM, so that load balancers stop routing to it.
+	ShutdownDrainDelay time.Duration `mapstructure:"SHUTDOWN_DRAIN_DELAY"`
+
+	GraphName   string   `mapstructure:"GRAPH_NAME"`
+	KingdomName string   `mapstructure:"KINGDOM_NAME"`
+	TaxonRanks  []string `mapstructure:"TAXON_RANKS"`
+	// ExtraGraphs lists further graphs to serve as `graph@database`. Each must
+	// be in a database of its own, as the scraper does not prefix the rank
+	// collections shared by graphs in a database.
+	ExtraGraphs []string `mapstructure:"EXTRA_GRAPHS"`
+
+	// WikipediaSite is the site of bare page titles given to the by-url lookup.
+	WikipediaSite string `mapstructure:"WIKIPEDIA_SITE"`
+	// WikipediaRedirectTimeout bounds requests resolving page redirects with
+	// the MediaWiki API. Zero disables redirect resolution.
+	WikipediaRedirectTimeout time.Duration `mapstructure:"WIKIPEDIA_REDIRECT_TIMEOUT"`
+
+	// AuthJWKS is the file path or URL of the JSON Web Key Set used to verify
+	// bearer tokens. Without it, no requests are authenticated.
+	AuthJWKS     string `mapstructure:"AUTH_JWKS"`
+	AuthIssuer   string `mapstructure:"AUTH_ISSUER"`
+	AuthAudience string `mapstructure:"AUTH_AUDIENCE"`
+	// AuthRolesClaim names the token claim listing the user's roles.
+	AuthRolesClaim string `mapstructure:"AUTH_ROLES_CLAIM"`
+	// AuthPublicReads allows reads without a token. Writes always need one.
+	AuthPublicReads bool   `mapstructure:"AUTH_PUBLIC_READS"`
+	AuthWriteRole   string `mapstructure:"AUTH_WRITE_ROLE"`
+	AuthAdminRole   string `mapstructure:"AUTH_ADMIN_ROLE"`
 
-	GraphName   string `mapstructure:"GRAPH_NAME"`
-	KingdomName string `mapstructure:"KINGDOM_NAME"`
+	// Requests per second and burst sizes allowed to each client IP and, by
+	// default, to each API key. A rate of zero disables the limit.
+	RateLimitIP       float64 `mapstructure:"RATE_LIMIT_IP"`
+	RateLimitIPBurst  int     `mapstructure:"RATE_LIMIT_IP_BURST"`
+	RateLimitKey      float64 `mapstructure:"RATE_LIMIT_KEY"`
+	RateLimitKeyBurst int     `mapstructure:"RATE_LIMIT_KEY_BURST"`
+	// TrustedProxies lists the CIDRs of reverse proxies whose
+	// `X-Forwarded-For` header gives the client IP. By default the header is
+	// ignored.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+
+	GraphQLMaxDepth      int `mapstructure:"GRAPHQL_MAX_DEPTH"`
+	GraphQLMaxComplexity int `mapstructure:"GRAPHQL_MAX_COMPLEXITY"`
+
+	// CacheSize is the number of lookups each graph keeps in memory. Zero
+	// disables the cache.
+	CacheSize int `mapstructure:"CACHE_SIZE"`
+	// SnapshotCacheSize is the number of snapshots each graph keeps in memory
+	// to answer asOf queries. Zero loads the snapshot for every query.
+	SnapshotCacheSize int `mapstructure:"SNAPSHOT_CACHE_SIZE"`
+	// CacheTTL bounds how long a lookup is cached. Zero means until evicted
+	// or the graph changes.
+	CacheTTL time.Duration `mapstructure:"CACHE_TTL"`
+	// CacheVersionCheckInterval is how often the graph version is read to
+	// notice crawls and curation by other instances.
+	CacheVersionCheckInterval time.Duration `mapstructure:"CACHE_VERSION_CHECK_INTERVAL"`
+
+	// Cache-Control headers of taxon and children responses, which carry an
+	// ETag and can be revalidated once stale. Empty omits the header.
+	CacheControlTaxon    string `mapstructure:"CACHE_CONTROL_TAXON"`
+	CacheControlChildren string `mapstructure:"CACHE_CONTROL_CHILDREN"`
+
+	// TracesExporter is where request spans are sent: `otlp`, `stdout`, or
+	// empty to disable tracing.
+	TracesExporter string `mapstructure:"TRACES_EXPORTER"`
+	// OTLPEndpoint is the base URL of the collector's OTLP/HTTP receiver.
+	OTLPEndpoint string `mapstructure:"OTLP_ENDPOINT"`
+	// TracesSampleRatio is the fraction of new traces recorded. Traces
+	// continued from a client follow the client's decision.
+	TracesSampleRatio float64 `mapstructure:"TRACES_SAMPLE_RATIO"`
 }
 
-// LoadConfig loads the config from the given path.
+// Graphs returns the served graphs. The first is the default graph, given by
+// GRAPH_NAME and DATABASE_NAME.
+func (config Config) Graphs() []GraphConfig {
+	graphs := []GraphConfig{{Name: config.GraphName, Database: config.DatabaseName}}
+	seen := map[string]bool{config.GraphName: true}
+	for _, extra := range config.ExtraGraphs {
+		name, database, _ := strings.Cut(strings.TrimSpace(extra), "@")
+		if name == "" || database == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		graphs = append(graphs, GraphConfig{Name: name, Database: database})
+	}
+	return graphs
+}
+
+// LoadConfig loads the config from the given path, with environment
+// variables taking precedence, and validates it.
 func LoadConfig(path string) (config Config, err error) {
 	viper.SetConfigFile(path)
 
+	viper.SetDefault("DB_CONNECT_TIMEOUT", "1m")
+	viper.SetDefault("READY_TIMEOUT", "2s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+	viper.SetDefault("SHUTDOWN_DRAIN_DELAY", "5s")
+	viper.SetDefault("LISTEN_ADDR", "")
+	viper.SetDefault("PORT", "5000")
+	viper.SetDefault("CORS_ALLOW_ORIGINS", []string{"http://localhost:5173", "http://orion:5173"})
+	viper.SetDefault("CORS_ALLOW_METHODS", []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete})
+	viper.SetDefault("READ_TIMEOUT", "30s")
+	viper.SetDefault("READ_HEADER_TIMEOUT", "10s")
+	viper.SetDefault("WRITE_TIMEOUT", "5m")
+	viper.SetDefault("IDLE_TIMEOUT", "2m")
+	viper.SetDefault("TLS_CERT_FILE", "")
+	viper.SetDefault("TLS_KEY_FILE", "")
+	viper.SetDefault("TLS_RELOAD_INTERVAL", "0s")
+	viper.SetDefault("HTTP_REDIRECT_ADDR", "")
+	viper.SetDefault("METRICS_ADDR", "127.0.0.1:9090")
+	viper.SetDefault("HSTS_MAX_AGE", "0s")
+	viper.SetDefault("HSTS_INCLUDE_SUBDOMAINS", false)
+	viper.SetDefault("BODY_LIMIT", "1M")
+	viper.SetDefault("GZIP_LEVEL", 5)
+
 	viper.SetDefault("GRAPH_NAME", "animal_kingdom")
 	viper.SetDefault("KINGDOM_NAME", "Animalia")
+	viper.SetDefault("TAXON_RANKS", TaxonRanks)
+	viper.SetDefault("WIKIPEDIA_SITE", "https://en.wikipedia.org")
+	viper.SetDefault("WIKIPEDIA_REDIRECT_TIMEOUT", "5s")
+	viper.SetDefault("AUTH_JWKS", "")
+	viper.SetDefault("AUTH_ISSUER", "")
+	viper.SetDefault("AUTH_AUDIENCE", "")
+	viper.SetDefault("AUTH_ROLES_CLAIM", "roles")
+	viper.SetDefault("AUTH_PUBLIC_READS", true)
+	viper.SetDefault("AUTH_WRITE_ROLE", "curator")
+	viper.SetDefault("AUTH_ADMIN_ROLE", "admin")
+	viper.SetDefault("RATE_LIMIT_IP", 10)
+	viper.SetDefault("RATE_LIMIT_IP_BURST", 40)
+	viper.SetDefault("RATE_LIMIT_KEY", 50)
+	viper.SetDefault("RATE_LIMIT_KEY_BURST", 200)
+	viper.SetDefault("TRUSTED_PROXIES", []string{})
+	viper.SetDefault("GRAPHQL_MAX_DEPTH", 10)
+	viper.SetDefault("GRAPHQL_MAX_COMPLEXITY", 5000)
+	viper.SetDefault("CACHE_SIZE", 10000)
+	viper.SetDefault("SNAPSHOT_CACHE_SIZE", 4)
+	viper.SetDefault("CACHE_TTL", "10m")
+	viper.SetDefault("CACHE_VERSION_CHECK_INTERVAL", "10s")
+	viper.SetDefault("CACHE_CONTROL_TAXON", "max-age=60")
+	viper.SetDefault("CACHE_CONTROL_CHILDREN", "max-age=60")
+	viper.SetDefault("TRACES_EXPORTER", "")
+	viper.SetDefault("OTLP_ENDPOINT", "http://localhost:4318")
+	viper.SetDefault("TRACES_SAMPLE_RATIO", 1)
 
 	viper.AutomaticEnv()
 	err = viper.ReadInConfig()
@@ -38,5 +237,165 @@ func LoadConfig(path string) (config Config, err error) {
 		return
 	}
 	err = viper.Unmarshal(&config)
+	if err != nil {
+		return
+	}
+	for i := range config.TaxonRanks {
+		config.TaxonRanks[i] = strings.ToLower(strings.TrimSpace(config.TaxonRanks[i]))
+	}
+	for i := range config.CORSAllowMethods {
+		config.CORSAllowMethods[i] = strings.ToUpper(strings.TrimSpace(config.CORSAllowMethods[i]))
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":" + config.Port
+	}
+	err = config.Validate()
 	return
 }
+
+var cacheControlDirectiveRegexp = regexp.MustCompile(`^[a-zA-Z-]+(=([0-9]+|"[^"]*"))?$`)
+
+// Validate reports all invalid settings, so that a misconfigured deployment
+// fails at startup rather than on the first request.
+func (config Config) Validate() error {
+	errs := []error{}
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(config.TaxonRanks) == 0 {
+		invalid("TAXON_RANKS must list at least one rank")
+	}
+	addrs := map[string]string{"LISTEN_ADDR": config.ListenAddr}
+	if config.HTTPRedirectAddr != "" {
+		addrs["HTTP_REDIRECT_ADDR"] = config.HTTPRedirectAddr
+		if config.TLSCertFile == "" {
+			invalid("HTTP_REDIRECT_ADDR needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+	}
+	if config.MetricsAddr != "" {
+		addrs["METRICS_ADDR"] = config.MetricsAddr
+		if config.MetricsAddr == config.ListenAddr || config.MetricsAddr == config.HTTPRedirectAddr {
+			invalid("METRICS_ADDR must differ from LISTEN_ADDR and HTTP_REDIRECT_ADDR")
+		}
+	}
+	for name, addr := range addrs {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			invalid("%s '%s' must be of the form host:port: %v", name, addr, err)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			invalid("%s '%s' has an invalid port", name, addr)
+		}
+	}
+	for _, origin := range config.CORSAllowOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			invalid("CORS_ALLOW_ORIGINS entry '%s' must be `*` or a scheme and host", origin)
+		}
+	}
+	methods := map[string]bool{
+		http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+		http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	}
+	for _, method := range config.CORSAllowMethods {
+		if !methods[method] {
+			invalid("CORS_ALLOW_METHODS entry '%s' is not an HTTP method", method)
+		}
+	}
+	for name, timeout := range map[string]time.Duration{
+		"READ_TIMEOUT":                 config.ReadTimeout,
+		"READ_HEADER_TIMEOUT":          config.ReadHeaderTimeout,
+		"WRITE_TIMEOUT":                config.WriteTimeout,
+		"IDLE_TIMEOUT":                 config.IdleTimeout,
+		"WIKIPEDIA_REDIRECT_TIMEOUT":   config.WikipediaRedirectTimeout,
+		"TLS_RELOAD_INTERVAL":          config.TLSReloadInterval,
+		"DB_CONNECT_TIMEOUT":           config.DatabaseConnectTimeout,
+		"SHUTDOWN_TIMEOUT":             config.ShutdownTimeout,
+		"SHUTDOWN_DRAIN_DELAY":         config.ShutdownDrainDelay,
+		"HSTS_MAX_AGE":                 config.HSTSMaxAge,
+		"CACHE_TTL":                    config.CacheTTL,
+		"CACHE_VERSION_CHECK_INTERVAL": config.CacheVersionCheckInterval,
+	} {
+		if timeout < 0 {
+			invalid("%s must not be negative", name)
+		}
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		invalid("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for name, path := range map[string]string{"TLS_CERT_FILE": config.TLSCertFile, "TLS_KEY_FILE": config.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			invalid("%s: %v", name, err)
+		}
+	}
+	if config.BodyLimit != "" {
+		if limit, err := bytes.Parse(config.BodyLimit); err != nil || limit <= 0 {
+			invalid("BODY_LIMIT '%s' must be a size such as 512K or 1M", config.BodyLimit)
+		}
+	}
+	if config.ReadyTimeout <= 0 {
+		invalid("READY_TIMEOUT must be positive")
+	}
+	if config.GzipLevel < -1 || config.GzipLevel > 9 {
+		invalid("GZIP_LEVEL must be from -1 to 9")
+	}
+	if config.RateLimitIP < 0 || config.RateLimitKey < 0 || config.RateLimitIPBurst < 0 || config.RateLimitKeyBurst < 0 {
+		invalid("Rate limits must not be negative")
+	}
+	for _, cidr := range config.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			invalid("TRUSTED_PROXIES entry '%s' must be a CIDR, e.g. 10.0.0.0/8", cidr)
+		}
+	}
+	databases := map[string]bool{config.DatabaseName: true}
+	for _, extra := range config.ExtraGraphs {
+		name, database, _ := strings.Cut(strings.TrimSpace(extra), "@")
+		if name == "" || database == "" {
+			invalid("EXTRA_GRAPHS entry '%s' must be graph@database", extra)
+		} else if databases[database] {
+			invalid("EXTRA_GRAPHS entry '%s' must be in a database of its own", extra)
+		}
+		databases[database] = true
+	}
+	if config.CacheSize < 0 {
+		invalid("CACHE_SIZE must not be negative")
+	}
+	if config.SnapshotCacheSize < 0 {
+		invalid("SNAPSHOT_CACHE_SIZE must not be negative")
+	}
+	for name, value := range map[string]string{"CACHE_CONTROL_TAXON": config.CacheControlTaxon, "CACHE_CONTROL_CHILDREN": config.CacheControlChildren} {
+		if value == "" {
+			continue
+		}
+		for _, directive := range strings.Split(value, ",") {
+			if !cacheControlDirectiveRegexp.MatchString(strings.TrimSpace(directive)) {
+				invalid("%s '%s' must be a list of Cache-Control directives", name, value)
+				break
+			}
+		}
+	}
+	if config.GraphQLMaxDepth < 1 || config.GraphQLMaxComplexity < 1 {
+		invalid("GRAPHQL_MAX_DEPTH and GRAPHQL_MAX_COMPLEXITY must be positive")
+	}
+	if !config.AuthPublicReads && config.AuthJWKS == "" {
+		invalid("AUTH_JWKS must be set unless AUTH_PUBLIC_READS is true")
+	}
+	switch config.TracesExporter {
+	case "", "stdout":
+	case "otlp":
+		if u, err := url.Parse(config.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("OTLP_ENDPOINT '%s' must be an http or https URL", config.OTLPEndpoint)
+		}
+	default:
+		invalid("TRACES_EXPORTER '%s' must be otlp, stdout or empty", config.TracesExporter)
+	}
+	if config.TracesSampleRatio < 0 || config.TracesSampleRatio > 1 {
+		invalid("TRACES_SAMPLE_RATIO must be from 0 to 1")
+	}
+	return errors.Join(errs...)
+}
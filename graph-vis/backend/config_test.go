@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staging.env")
+	env := `PORT=8080
+CORS_ALLOW_ORIGINS="https://staging.example.org,https://admin.staging.example.org"
+CORS_ALLOW_METHODS="get,post"
+WRITE_TIMEOUT="90s"
+`
+	if err := os.WriteFile(path, []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.WriteTimeout != 90*time.Second || cfg.GzipLevel != 5 {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.CORSAllowOrigins, []string{"https://staging.example.org", "https://admin.staging.example.org"}) {
+		t.Errorf("Unexpected CORS origins %v", cfg.CORSAllowOrigins)
+	}
+	if !reflect.DeepEqual(cfg.CORSAllowMethods, []string{"GET", "POST"}) {
+		t.Errorf("Unexpected CORS methods %v", cfg.CORSAllowMethods)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			ListenAddr:           "127.0.0.1:5000",
+			TaxonRanks:           TaxonRanks,
+			CORSAllowOrigins:     []string{"*", "http://localhost:5173"},
+			CORSAllowMethods:     []string{"GET", "POST"},
+			BodyLimit:            "512K",
+			GzipLevel:            -1,
+			AuthPublicReads:      true,
+			ReadyTimeout:         time.Second,
+			GraphQLMaxDepth:      10,
+			GraphQLMaxComplexity: 5000,
+			DatabaseName:         "taxa",
+			ExtraGraphs:          []string{"plants@flora"},
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	tests := []struct {
+		change func(*Config)
+		want   string
+	}{
+		{func(c *Config) { c.ListenAddr = "5000" }, "LISTEN_ADDR"},
+		{func(c *Config) { c.ListenAddr = ":http2" }, "LISTEN_ADDR"},
+		{func(c *Config) { c.MetricsAddr = "127.0.0.1:5000" }, "METRICS_ADDR"},
+		{func(c *Config) { c.MetricsAddr = "9090" }, "METRICS_ADDR"},
+		{func(c *Config) { c.CORSAllowOrigins = []string{"localhost:5173"} }, "CORS_ALLOW_ORIGINS"},
+		{func(c *Config) { c.CORSAllowOrigins = []string{"https://example.org/app"} }, "CORS_ALLOW_ORIGINS"},
+		{func(c *Config) { c.CORSAllowMethods = []string{"FETCH"} }, "CORS_ALLOW_METHODS"},
+		{func(c *Config) { c.WriteTimeout = -time.Second }, "WRITE_TIMEOUT"},
+		{func(c *Config) { c.ShutdownDrainDelay = -time.Second }, "SHUTDOWN_DRAIN_DELAY"},
+		{func(c *Config) { c.TLSCertFile = "cert.pem" }, "TLS_CERT_FILE and TLS_KEY_FILE"},
+		{func(c *Config) { c.TLSCertFile, c.TLSKeyFile = "missing.pem", "missing.key" }, "TLS_KEY_FILE: stat missing.key"},
+		{func(c *Config) { c.BodyLimit = "lots" }, "BODY_LIMIT"},
+		{func(c *Config) { c.GzipLevel = 10 }, "GZIP_LEVEL"},
+		{func(c *Config) { c.RateLimitIP = -1 }, "Rate limits"},
+		{func(c *Config) { c.TrustedProxies = []string{"10.0.0.1"} }, "TRUSTED_PROXIES"},
+		{func(c *Config) { c.AuthPublicReads = false }, "AUTH_JWKS"},
+		{func(c *Config) { c.TracesExporter = "jaeger" }, "TRACES_EXPORTER"},
+		{func(c *Config) { c.TracesExporter, c.OTLPEndpoint = "otlp", "localhost:4318" }, "OTLP_ENDPOINT"},
+		{func(c *Config) { c.TracesSampleRatio = 2 }, "TRACES_SAMPLE_RATIO"},
+		{func(c *Config) { c.ExtraGraphs = []string{"plants"} }, "EXTRA_GRAPHS"},
+		{func(c *Config) { c.DatabaseName, c.ExtraGraphs = "taxa", []string{"plants@taxa"} }, "EXTRA_GRAPHS"},
+		{func(c *Config) { c.ExtraGraphs = []string{"plants@flora", "fungi@flora"} }, "EXTRA_GRAPHS"},
+		{func(c *Config) { c.CacheSize = -1 }, "CACHE_SIZE"},
+		{func(c *Config) { c.SnapshotCacheSize = -1 }, "SNAPSHOT_CACHE_SIZE"},
+		{func(c *Config) { c.CacheTTL = -time.Second }, "CACHE_TTL"},
+		{func(c *Config) { c.CacheControlTaxon = "max-age=60\r\nX-Evil: 1" }, "CACHE_CONTROL_TAXON"},
+	}
+	for _, tt := range tests {
+		cfg := valid()
+		tt.change(&cfg)
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected error mentioning %q, got %v", tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Curation audit actions.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditMerge  = "merge"
+)
+
+// taxonKeyRegexp matches the characters ArangoDB allows in document keys.
+var taxonKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9_\-:.@()+,=;$!*'%]{1,254}$`)
+
+// checkWritable returns an error if the service answers against a snapshot.
+func (svc *TaxonSvc) checkWritable() error {
+	if svc.readOnly {
+		return newSvcError(ErrInvalid, "Snapshots are read-only")
+	}
+	return nil
+}
+
+// changed invalidates cached lookups after a write, here and, by bumping the
+// graph version, in other instances. The write has been made, so failing to
+// bump the version is only logged: other instances then serve stale lookups
+// until their cache entries expire.
+func (svc *TaxonSvc) changed() {
+	if err := svc.repo.BumpVersion(); err != nil {
+		log.Printf("Failed to bump graph version after a curation change: %v", err)
+	}
+	if svc.cache != nil {
+		svc.cache.Purge()
+	}
+}
+
+// parentRank returns the rank directly above the given rank, or an empty
+// string for the root rank.
+func (svc *TaxonSvc) parentRank(rank string) string {
+	for i, r := range svc.ranks {
+		if r == rank && i > 0 {
+			return svc.ranks[i-1]
+		}
+	}
+	return ""
+}
+
+// getParent returns the taxon a new parent reference points to, which must
+// be of the rank directly above rank.
+func (svc *TaxonSvc) getParent(rank string, parent string) (Taxon, error) {
+	parentRank := svc.parentRank(rank)
+	if parentRank == "" {
+		return Taxon{}, newSvcError(ErrInvalid, "Taxa of rank '%s' have no parent", rank)
+	}
+	prefix, id, ok := strings.Cut(parent, "/")
+	if !ok || prefix != parentRank {
+		return Taxon{}, newSvcError(ErrInvalid, "Parent of a taxon of rank '%s' must be of rank '%s'", rank, parentRank)
+	}
+	return svc.Get(parentRank, id)
+}
+
+// auditState returns the state of a taxon for the audit log.
+func (svc *TaxonSvc) auditState(taxon Taxon) (*AuditTaxon, error) {
+	parents, err := svc.repo.GetParentsBatch([]string{taxon.Id})
+	if err != nil {
+		return nil, err
+	}
+	state := &AuditTaxon{TaxonResponse: TaxonResponse(taxon)}
+	if len(parents[taxon.Id]) > 0 {
+		state.Parent = parents[taxon.Id][0].Id
+	}
+	return state, nil
+}
+
+// scientificName expands the genus of a species name abbreviated as in
+// Wikipedia infoboxes, e.g. "F. catus" to "Felis catus", as the scraper does
+// when deriving keys.
+func scientificName(name string, parent Taxon) string {
+	abbrev, epithet, ok := strings.Cut(name, " ")
+	if ok && len(abbrev) == 2 && abbrev[1] == '.' && strings.HasPrefix(parent.Name, abbrev[:1]) {
+		return parent.Name + " " + epithet
+	}
+	return name
+}
+
+// CreateTaxon adds a taxon by hand. The taxon is marked curated so that the
+// scraper does not change it.
+func (svc *TaxonSvc) CreateTaxon(user string, in TaxonInput) (Taxon, error) {
+	if err := svc.checkWritable(); err != nil {
+		return Taxon{}, err
+	}
+	if err := svc.checkRank(in.Rank); err != nil {
+		return Taxon{}, newSvcError(ErrInvalid, "Unknown rank '%s'", in.Rank)
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		return Taxon{}, newSvcError(ErrInvalid, "Missing taxon name")
+	}
+	var parent Taxon
+	if in.Parent != "" || svc.parentRank(in.Rank) != "" {
+		var err error
+		if parent, err = svc.getParent(in.Rank, in.Parent); err != nil {
+			return Taxon{}, err
+		}
+	}
+	key := in.Id
+	if key == "" {
+		name := in.Name
+		if in.Rank == svc.ranks[len(svc.ranks)-1] {
+			name = scientificName(name, parent)
+		}
+		key = TaxonKey(name)
+	}
+	if !taxonKeyRegexp.MatchString(key) {
+		return Taxon{}, newSvcError(ErrInvalid, "Invalid taxon ID '%s'", key)
+	}
+	if existing, err := svc.repo.Get(in.Rank, key); err == nil {
+		return Taxon{}, newSvcError(ErrConflict, "Taxon '%s' already exists", existing.Id)
+	}
+
+	taxon := Taxon{
+		TaxonBase: TaxonBase{
+			Rank:       strings.ToUpper(in.Rank[:1]) + in.Rank[1:],
+			Name:       strings.TrimSpace(in.Name),
+			Url:        in.Url,
+			WikidataId: in.WikidataId,
+			Curated:    true,
+		},
+		Id: in.Rank + "/" + key,
+	}
+	if err := svc.repo.CreateTaxon(taxon, parent.Id); err != nil {
+		return Taxon{}, err
+	}
+	defer svc.changed()
+	after := &AuditTaxon{TaxonResponse: TaxonResponse(taxon), Parent: parent.Id}
+	return taxon, svc.repo.AddAuditEntry(AuditEntry{
+		TaxonId: taxon.Id,
+		Action:  AuditCreate,
+		User:    user,
+		At:      time.Now().UTC(),
+		After:   after,
+	})
+}
+
+// UpdateTaxon corrects the name, page, Wikidata QID or parent of a taxon. The
+// taxon keeps its ID and is marked curated.
+func (svc *TaxonSvc) UpdateTaxon(user string, rank string, id string, patch TaxonPatch) (Taxon, error) {
+	if err := svc.checkWritable(); err != nil {
+		return Taxon{}, err
+	}
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return taxon, err
+	}
+	before, err := svc.auditState(taxon)
+	if err != nil {
+		return taxon, err
+	}
+	if patch.Name != nil {
+		if strings.TrimSpace(*patch.Name) == "" {
+			return taxon, newSvcError(ErrInvalid, "Missing taxon name")
+		}
+		taxon.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.Url != nil {
+		taxon.Url = *patch.Url
+	}
+	if patch.WikidataId != nil {
+		if *patch.WikidataId != "" && !wikidataQIDRegexp.MatchString(*patch.WikidataId) {
+			return taxon, newSvcError(ErrInvalid, "Invalid Wikidata QID '%s'", *patch.WikidataId)
+		}
+		taxon.WikidataId = *patch.WikidataId
+	}
+	taxon.Curated = true
+	after := &AuditTaxon{TaxonResponse: TaxonResponse(taxon), Parent: before.Parent}
+	if patch.Parent != nil && *patch.Parent != before.Parent {
+		parent, err := svc.getParent(rank, *patch.Parent)
+		if err != nil {
+			return taxon, err
+		}
+		after.Parent = parent.Id
+	}
+
+	if err := svc.repo.UpdateTaxon(taxon); err != nil {
+		return taxon, err
+	}
+	defer svc.changed()
+	var moveErr error
+	if after.Parent != before.Parent {
+		if moveErr = svc.repo.SetParent(taxon, after.Parent); moveErr != nil {
+			// The taxon was updated but not moved, which is what is audited.
+			after.Parent = before.Parent
+		}
+	}
+	return taxon, errors.Join(svc.repo.AddAuditEntry(AuditEntry{
+		TaxonId: taxon.Id,
+		Action:  AuditUpdate,
+		User:    user,
+		At:      time.Now().UTC(),
+		Before:  before,
+		After:   after,
+	}), moveErr)
+}
+
+// DeleteTaxon removes a taxon. A duplicate is merged by giving mergeInto,
+// the ID of a taxon of the same rank which takes over its children and
+// cross-references. A taxon with children can only be deleted by merging.
+func (svc *TaxonSvc) DeleteTaxon(user string, rank string, id string, mergeInto string) error {
+	if err := svc.checkWritable(); err != nil {
+		return err
+	}
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return err
+	}
+	before, err := svc.auditState(taxon)
+	if err != nil {
+		return err
+	}
+	entry := AuditEntry{
+		TaxonId: taxon.Id,
+		Action:  AuditDelete,
+		User:    user,
+		At:      time.Now().UTC(),
+		Before:  before,
+	}
+	if mergeInto != "" {
+		prefix, intoId, ok := strings.Cut(mergeInto, "/")
+		if !ok || prefix != rank {
+			return newSvcError(ErrInvalid, "A taxon can only be merged into a taxon of the same rank")
+		}
+		into, err := svc.Get(rank, intoId)
+		if err != nil {
+			return err
+		}
+		if into.Id == taxon.Id {
+			return newSvcError(ErrInvalid, "A taxon cannot be merged into itself")
+		}
+		entry.Action = AuditMerge
+		entry.MergedInto = into.Id
+	} else {
+		children, err := svc.repo.GetChildren(rank, taxon.Key())
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return newSvcError(ErrConflict, "Taxon '%s' has %d children, merge it into another taxon instead", taxon.Id, len(children))
+		}
+	}
+	if err := svc.repo.DeleteTaxon(taxon, entry.MergedInto); err != nil {
+		return err
+	}
+	defer svc.changed()
+	return svc.repo.AddAuditEntry(entry)
+}
+
+// GetAuditEntries returns the curation changes to a taxon, including merges
+// into it. Deleted taxa are given by ID.
+func (svc *TaxonSvc) GetAuditEntries(rank string, id string) ([]AuditEntry, error) {
+	if err := svc.checkRank(rank); err != nil {
+		return []AuditEntry{}, err
+	}
+	taxonId := rank + "/" + id
+	if taxon, err := svc.Get(rank, id); err == nil {
+		taxonId = taxon.Id
+	}
+	return svc.repo.ListAuditEntries(taxonId)
+}
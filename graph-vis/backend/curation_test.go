@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// faultyRepo fails to bump the graph version and to move taxa.
+type faultyRepo struct {
+	*MemoryTaxonRepo
+}
+
+func (repo faultyRepo) BumpVersion() error {
+	return newSvcError(ErrUnavailable, "Database unavailable")
+}
+
+func (repo faultyRepo) SetParent(taxon Taxon, parent string) error {
+	return newSvcError(ErrUnavailable, "Database unavailable")
+}
+
+func TestCurationAudit(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	svc := NewTaxonSvc(faultyRepo{memory}, Config{TaxonRanks: TaxonRanks, CacheSize: 100})
+
+	// Writes succeed and are audited even if the graph version is not bumped.
+	if _, err := svc.CreateTaxon("alice", TaxonInput{Rank: "species", Name: "F. margarita", Parent: "genus/7"}); err != nil {
+		t.Fatalf("Expected the taxon to be created, got %v", err)
+	}
+	if children, err := svc.GetChildren("genus", "7"); err != nil || len(children) != 3 {
+		t.Errorf("Expected the new child, got children %v %v", children, err)
+	}
+
+	// A failed move is reported, but the update made is audited.
+	name, parent := "Felis margarita", "genus/8"
+	_, err = svc.UpdateTaxon("alice", "species", "felis-margarita", TaxonPatch{Name: &name, Parent: &parent})
+	var svcErr *SvcError
+	if !errors.As(err, &svcErr) || svcErr.Kind != ErrUnavailable {
+		t.Errorf("Expected the failed move to be reported, got %v", err)
+	}
+	if err := svc.DeleteTaxon("alice", "species", "9", ""); err != nil {
+		t.Fatalf("Expected the taxon to be deleted, got %v", err)
+	}
+
+	entries, _ := svc.GetAuditEntries("species", "felis-margarita")
+	if len(entries) != 2 || entries[0].Action != AuditCreate || entries[1].Action != AuditUpdate {
+		t.Fatalf("Unexpected audit entries %+v", entries)
+	}
+	if after := entries[1].After; after.Name != name || after.Parent != "genus/7" {
+		t.Errorf("Expected the audited update to keep the parent, got %+v", after)
+	}
+	if entries, _ := svc.GetAuditEntries("species", "9"); len(entries) != 1 || entries[0].Action != AuditDelete {
+		t.Errorf("Unexpected audit entries %+v", entries)
+	}
+}
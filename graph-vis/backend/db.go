@@ -3,60 +3,71 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
 	arango "github.com/arangodb/go-driver"
 	"github.com/arangodb/go-driver/http"
 )
 
 func createArangoDBClient(config Config) (arango.Client, error) {
-	var err error
-	var client arango.Client
-	var conn arango.Connection
-	conn, err = http.NewConnection(http.ConnectionConfig{
+	conn, err := http.NewConnection(http.ConnectionConfig{
 		Endpoints: []string{config.DatabaseUrl},
 	})
 	if err != nil {
-		log.Fatalf("Failed to create HTTP connection: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP connection: %w", err)
 	}
-	client, err = arango.NewClient(arango.ClientConfig{
+	return arango.NewClient(arango.ClientConfig{
 		Connection:     conn,
 		Authentication: arango.BasicAuthentication(config.DatabaseUser, config.DatabasePassword),
 	})
-	return client, nil
 }
 
 func createArangoDB(config Config, client arango.Client) (arango.Database, error) {
-	var err error
-	var db arango.Database
-	var exists bool
-	exists, err = client.DatabaseExists(nil, config.DatabaseName)
+	exists, err := client.DatabaseExists(nil, config.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
 	if exists {
-		fmt.Println("That db exists already")
-		db, err = client.Database(nil, config.DatabaseName)
-		if err != nil {
-			log.Fatalf("Failed to open existing database: %v", err)
-		}
-	} else {
-		db, err = client.CreateDatabase(nil, config.DatabaseName, nil)
+		db, err := client.Database(nil, config.DatabaseName)
 		if err != nil {
-			log.Fatalf("Failed to create database: %v", err)
+			return nil, fmt.Errorf("failed to open existing database: %w", err)
 		}
+		return db, nil
+	}
+	db, err := client.CreateDatabase(nil, config.DatabaseName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
 	return db, nil
 }
 
 // GetArangoDB returns an ArangoDB database.
 func GetArangoDB(config Config) (arango.Database, error) {
-	var err error
-	var client arango.Client
-	var db arango.Database
-	client, err = createArangoDBClient(config)
+	client, err := createArangoDBClient(config)
 	if err != nil {
-		log.Fatalf("Failed to create ArangoDB client: %v", err)
+		return nil, fmt.Errorf("failed to create ArangoDB client: %w", err)
 	}
-	db, err = createArangoDB(config, client)
-	if err != nil {
-		log.Fatalf("Failed to create ArangoDB: %v", err)
+	return createArangoDB(config, client)
+}
+
+// ConnectArangoDB returns an ArangoDB database, retrying with exponential
+// backoff while the server is not up yet, e.g. when both are started
+// together. It gives up after DB_CONNECT_TIMEOUT.
+func ConnectArangoDB(config Config) (arango.Database, error) {
+	deadline := time.Now().Add(config.DatabaseConnectTimeout)
+	wait := 500 * time.Millisecond
+	for {
+		db, err := GetArangoDB(config)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("database '%s' at %s unavailable: %w", config.DatabaseName, config.DatabaseUrl, err)
+		}
+		log.Printf("Database '%s' unavailable, retrying in %s: %v", config.DatabaseName, wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > 10*time.Second {
+			wait = 10 * time.Second
+		}
 	}
-	return db, nil
 }
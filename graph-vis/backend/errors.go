@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	http "net/http"
+	"strings"
+
+	arango "github.com/arangodb/go-driver"
+	echo "github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Service error kinds. Errors returned by TaxonSvc wrap one of these so
+// handlers can map them to a status code.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrInvalid     = errors.New("invalid")
+	ErrUnavailable = errors.New("unavailable")
+	ErrConflict    = errors.New("conflict")
+	ErrInternal    = errors.New("internal")
+)
+
+// SvcError is an error returned by a service. Message and Details are safe
+// to show to clients; Err holds the underlying cause for logging.
+type SvcError struct {
+	Kind    error
+	Message string
+	Details interface{}
+	Err     error
+}
+
+func (e *SvcError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *SvcError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func newSvcError(kind error, format string, args ...interface{}) error {
+	return &SvcError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapDBError classifies an ArangoDB error as one of the service error kinds.
+func wrapDBError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	kind := ErrInternal
+	switch cause := arango.Cause(err); {
+	case arango.IsNotFound(cause):
+		kind = ErrNotFound
+	case arango.IsConflict(cause):
+		kind = ErrConflict
+	case errors.As(cause, &netErr),
+		errors.Is(cause, context.DeadlineExceeded),
+		arango.IsNoLeaderOrOngoing(cause),
+		arango.IsArangoErrorWithCode(cause, http.StatusServiceUnavailable):
+		kind = ErrUnavailable
+	}
+	return &SvcError{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// NewAPIError creates an APIError with a code derived from the status.
+func NewAPIError(status int, message string, details interface{}) *APIError {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	return &APIError{Status: status, Code: code, Message: message, Details: details}
+}
+
+func badRequest(msg string) error {
+	return NewAPIError(http.StatusBadRequest, msg, nil)
+}
+
+// toAPIError maps any error returned by a handler to an APIError.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return NewAPIError(httpErr.Code, fmt.Sprint(httpErr.Message), nil)
+	}
+	var svcErr *SvcError
+	if errors.As(err, &svcErr) {
+		switch svcErr.Kind {
+		case ErrNotFound:
+			return NewAPIError(http.StatusNotFound, svcErr.Message, svcErr.Details)
+		case ErrInvalid:
+			return NewAPIError(http.StatusBadRequest, svcErr.Message, svcErr.Details)
+		case ErrConflict:
+			return NewAPIError(http.StatusConflict, svcErr.Message, svcErr.Details)
+		case ErrUnavailable:
+			return NewAPIError(http.StatusServiceUnavailable, "Database unavailable", nil)
+		}
+	}
+	return NewAPIError(http.StatusInternalServerError, "Internal server error", nil)
+}
+
+// HTTPErrorHandler writes errors returned by handlers as a JSON error
+// envelope. Server errors are logged and their cause is not exposed.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		logError(c, err)
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, JSONResp{"error": apiErr})
+	}
+	if err != nil {
+		logError(c, err)
+	}
+}
+
+// logError logs an error with the ID of the request, under the same key as
+// in the access log.
+func logError(c echo.Context, err error) {
+	c.Logger().Errorj(log.JSON{"id": requestID(c), "error": err.Error()})
+}
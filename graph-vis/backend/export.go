@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	http "net/http"
+	"strconv"
+	"strings"
+
+	"backend/api"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// TreeWriter writes a subtree streamed in depth-first pre-order.
+type TreeWriter interface {
+	Begin(root Taxon) error
+	Node(node SubtreeNode) error
+	End() error
+}
+
+// ExportFormat describes a supported subtree export format.
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+	NewWriter   func(w io.Writer) TreeWriter
+}
+
+// ExportFormats lists the supported export formats by name.
+var ExportFormats = map[string]ExportFormat{
+	"newick":         {"text/x-nh; charset=UTF-8", "nwk", func(w io.Writer) TreeWriter { return &newickWriter{w: w} }},
+	"graphml":        {"application/graphml+xml; charset=UTF-8", "graphml", func(w io.Writer) TreeWriter { return &graphMLWriter{w: w} }},
+	"dot":            {"text/vnd.graphviz; charset=UTF-8", "dot", func(w io.Writer) TreeWriter { return &dotWriter{w: w} }},
+	"cytoscape-json": {echo.MIMEApplicationJSONCharsetUTF8, "json", func(w io.Writer) TreeWriter { return &cytoscapeWriter{w: w} }},
+	"csv":            {"text/csv; charset=UTF-8", "csv", func(w io.Writer) TreeWriter { return &csvWriter{w: csv.NewWriter(w)} }},
+}
+
+// newickWriter writes a tree in Newick format. Nodes arrive before their
+// children, so open nodes are kept on a stack and labelled when closed.
+type newickWriter struct {
+	w     io.Writer
+	stack []newickNode
+}
+
+type newickNode struct {
+	label       string
+	hasChildren bool
+}
+
+// newickLabel quotes a taxon name if it contains Newick punctuation or spaces.
+func newickLabel(name string) string {
+	if strings.ContainsAny(name, " ()[]':;,\t\n") {
+		return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+	}
+	return name
+}
+
+func (nw *newickWriter) Begin(root Taxon) error {
+	return nil
+}
+
+// closeTo closes open nodes until the stack holds depth nodes.
+func (nw *newickWriter) closeTo(depth int) error {
+	for len(nw.stack) > depth {
+		node := nw.stack[len(nw.stack)-1]
+		nw.stack = nw.stack[:len(nw.stack)-1]
+		if node.hasChildren {
+			if _, err := io.WriteString(nw.w, ")"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(nw.w, node.label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nw *newickWriter) Node(node SubtreeNode) error {
+	// Newick trees cannot give a taxon a second parent.
+	if node.Seen {
+		return nil
+	}
+	if err := nw.closeTo(node.Depth); err != nil {
+		return err
+	}
+	if len(nw.stack) > 0 {
+		sep := ","
+		if parent := &nw.stack[len(nw.stack)-1]; !parent.hasChildren {
+			sep = "("
+			parent.hasChildren = true
+		}
+		if _, err := io.WriteString(nw.w, sep); err != nil {
+			return err
+		}
+	}
+	nw.stack = append(nw.stack, newickNode{label: newickLabel(node.Taxon.Name)})
+	return nil
+}
+
+func (nw *newickWriter) End() error {
+	if err := nw.closeTo(0); err != nil {
+		return err
+	}
+	_, err := io.WriteString(nw.w, ";\n")
+	return err
+}
+
+// graphMLWriter writes a tree as a GraphML directed graph.
+type graphMLWriter struct {
+	w io.Writer
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (gw *graphMLWriter) Begin(root Taxon) error {
+	_, err := fmt.Fprintf(gw.w, `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="rank" for="node" attr.name="rank" attr.type="string"/>
+  <key id="name" for="node" attr.name="name" attr.type="string"/>
+  <key id="url" for="node" attr.name="url" attr.type="string"/>
+  <graph id="%s" edgedefault="directed">
+`, xmlEscape(root.Id))
+	return err
+}
+
+func (gw *graphMLWriter) Node(node SubtreeNode) error {
+	t := node.Taxon
+	if !node.Seen {
+		_, err := fmt.Fprintf(gw.w, `    <node id="%s"><data key="rank">%s</data><data key="name">%s</data><data key="url">%s</data></node>
+`, xmlEscape(t.Id), xmlEscape(t.Rank), xmlEscape(t.Name), xmlEscape(t.Url))
+		if err != nil {
+			return err
+		}
+	}
+	if node.Parent == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(gw.w, `    <edge source="%s" target="%s"/>
+`, xmlEscape(node.Parent), xmlEscape(t.Id))
+	return err
+}
+
+func (gw *graphMLWriter) End() error {
+	_, err := io.WriteString(gw.w, "  </graph>\n</graphml>\n")
+	return err
+}
+
+// dotWriter writes a tree as a Graphviz digraph.
+type dotWriter struct {
+	w io.Writer
+}
+
+func (dw *dotWriter) Begin(root Taxon) error {
+	_, err := fmt.Fprintf(dw.w, "digraph %s {\n", strconv.Quote(root.Name))
+	return err
+}
+
+func (dw *dotWriter) Node(node SubtreeNode) error {
+	t := node.Taxon
+	if !node.Seen {
+		_, err := fmt.Fprintf(dw.w, "  %s [label=%s, rank=%s, URL=%s];\n",
+			strconv.Quote(t.Id), strconv.Quote(t.Name), strconv.Quote(t.Rank), strconv.Quote(t.Url))
+		if err != nil {
+			return err
+		}
+	}
+	if node.Parent == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(dw.w, "  %s -> %s;\n", strconv.Quote(node.Parent), strconv.Quote(t.Id))
+	return err
+}
+
+func (dw *dotWriter) End() error {
+	_, err := io.WriteString(dw.w, "}\n")
+	return err
+}
+
+// cytoscapeWriter writes a tree as Cytoscape.js elements JSON, with node and
+// edge data shaped as in the visualiser frontend.
+type cytoscapeWriter struct {
+	w     io.Writer
+	first bool
+}
+
+type cytoscapeElement struct {
+	Group string      `json:"group"`
+	Data  interface{} `json:"data"`
+}
+
+type cytoscapeEdge struct {
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+func (cw *cytoscapeWriter) Begin(root Taxon) error {
+	cw.first = true
+	_, err := io.WriteString(cw.w, `{"elements":[`)
+	return err
+}
+
+func (cw *cytoscapeWriter) element(elem cytoscapeElement) error {
+	if !cw.first {
+		if _, err := io.WriteString(cw.w, ","); err != nil {
+			return err
+		}
+	}
+	cw.first = false
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+	_, err = cw.w.Write(data)
+	return err
+}
+
+func (cw *cytoscapeWriter) Node(node SubtreeNode) error {
+	if !node.Seen {
+		if err := cw.element(cytoscapeElement{Group: "nodes", Data: TaxonResponse(node.Taxon)}); err != nil {
+			return err
+		}
+	}
+	if node.Parent == "" {
+		return nil
+	}
+	return cw.element(cytoscapeElement{Group: "edges", Data: cytoscapeEdge{
+		Id:     fmt.Sprintf("%s-%s", node.Parent, node.Taxon.Id),
+		Source: node.Parent,
+		Target: node.Taxon.Id,
+	}})
+}
+
+func (cw *cytoscapeWriter) End() error {
+	_, err := io.WriteString(cw.w, "]}\n")
+	return err
+}
+
+// csvWriter writes a tree as CSV rows, one per taxon and parent.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) Begin(root Taxon) error {
+	return cw.w.Write([]string{"id", "parent_id", "rank", "name", "url", "depth"})
+}
+
+func (cw *csvWriter) Node(node SubtreeNode) error {
+	t := node.Taxon
+	return cw.w.Write([]string{t.Id, node.Parent, t.Rank, t.Name, t.Url, strconv.Itoa(node.Depth)})
+}
+
+func (cw *csvWriter) End() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// TaxonExport streams the subtree below a taxon in the format given by the
+// `format` query parameter.
+func TaxonExport(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	rank := c.Param("rank")
+	id := c.Param("id")
+	if id == "" {
+		return badRequest("Missing taxon ID")
+	}
+	formatName := c.QueryParam("format")
+	format, ok := ExportFormats[formatName]
+	if !ok {
+		return badRequest(fmt.Sprintf("Unknown export format '%s'", formatName))
+	}
+	root, err := taxSvc.Get(rank, id)
+	if err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	resp.Header().Set("Trailer", api.HeaderExportStatus)
+	resp.Header().Set(echo.HeaderContentType, format.ContentType)
+	filename := fmt.Sprintf("%s-%s.%s", rank, root.Key(), format.Extension)
+	resp.Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	resp.WriteHeader(http.StatusOK)
+
+	buf := bufio.NewWriter(resp)
+	tw := format.NewWriter(buf)
+	count := 0
+	err = tw.Begin(root)
+	if err == nil {
+		err = taxSvc.WalkSubtree(rank, id, func(node SubtreeNode) error {
+			if err := tw.Node(node); err != nil {
+				return err
+			}
+			// Flush periodically so large subtrees reach the client as they are read.
+			if count++; count%1000 == 0 {
+				if err := buf.Flush(); err != nil {
+					return err
+				}
+				resp.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = tw.End()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		// The status has already been sent, so the error can only be logged
+		// and told to clients by the missing trailer.
+		logError(c, fmt.Errorf("failed to export '%s/%s': %w", rank, id, err))
+		return nil
+	}
+	resp.Header().Set(api.HeaderExportStatus, api.ExportComplete)
+	return nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	http "net/http"
+	"strconv"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	graphQLDefaultChildrenLimit = 50
+	graphQLMaxChildrenLimit     = 500
+	graphQLDefaultSearchLimit   = 20
+	graphQLMaxSearchLimit       = 100
+	// graphQLDescendantsCountCost is the complexity of descendantsCount. The
+	// count traverses the whole subtree, so it is charged like the largest
+	// page of children rather than like a stored field.
+	graphQLDescendantsCountCost = graphQLMaxChildrenLimit
+)
+
+type graphQLCtxKey struct{}
+
+// graphQLRequest holds the state of a single GraphQL request.
+type graphQLRequest struct {
+	svc         *TaxonSvc
+	children    *batchLoader[[]Taxon]
+	parents     *batchLoader[[]Taxon]
+	lineages    *batchLoader[[]Taxon]
+	descendants *batchLoader[int64]
+}
+
+func requestFromContext(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLCtxKey{}).(*graphQLRequest)
+}
+
+// batchLoader collects the taxon IDs requested while resolving one level of
+// a query, then fetches them all with a single call when the first result is
+// needed. Results are cached for the rest of the request.
+type batchLoader[T any] struct {
+	mu      sync.Mutex
+	fetch   func(ids []string) (map[string]T, error)
+	pending map[string]bool
+	results map[string]T
+}
+
+func newBatchLoader[T any](fetch func(ids []string) (map[string]T, error)) *batchLoader[T] {
+	return &batchLoader[T]{fetch: fetch, pending: map[string]bool{}, results: map[string]T{}}
+}
+
+// load queues the ID and returns a thunk yielding its result.
+func (l *batchLoader[T]) load(id string) func() (T, error) {
+	l.mu.Lock()
+	if _, ok := l.results[id]; !ok {
+		l.pending[id] = true
+	}
+	l.mu.Unlock()
+	return func() (T, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if result, ok := l.results[id]; ok {
+			return result, nil
+		}
+		ids := []string{}
+		for pendingId := range l.pending {
+			ids = append(ids, pendingId)
+		}
+		l.pending = map[string]bool{}
+		found, err := l.fetch(ids)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		for _, fetchedId := range ids {
+			l.results[fetchedId] = found[fetchedId]
+		}
+		return l.results[id], nil
+	}
+}
+
+// graphQLError hides the cause of service errors from clients, as the
+// HTTPErrorHandler does for REST routes.
+func graphQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return toAPIError(err)
+}
+
+func limitArg(p graphql.ResolveParams, max int) (int, error) {
+	limit := p.Args["limit"].(int)
+	if limit < 1 || limit > max {
+		return 0, fmt.Errorf("Limit must be between 1 and %d", max)
+	}
+	return limit, nil
+}
+
+// taxonField creates a field resolving a string from a Taxon. The default
+// resolver cannot see fields of the embedded TaxonBase.
+func taxonField(t graphql.Output, get func(Taxon) string) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.NewNonNull(t),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(Taxon)), nil
+		},
+	}
+}
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	taxonType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Taxon",
+		Description: "A taxon in the taxonomic hierarchy.",
+		Fields: graphql.Fields{
+			"id":   taxonField(graphql.ID, func(t Taxon) string { return t.Id }),
+			"rank": taxonField(graphql.String, func(t Taxon) string { return t.Rank }),
+			"name": taxonField(graphql.String, func(t Taxon) string { return t.Name }),
+			"url":  taxonField(graphql.String, func(t Taxon) string { return t.Url }),
+			"wikidataId": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Wikidata QID of the taxon page.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if qid := p.Source.(Taxon).WikidataId; qid != "" {
+						return qid, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+	taxonType.AddFieldConfig("parent", &graphql.Field{
+		Type:        taxonType,
+		Description: "The taxon one rank above.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			thunk := requestFromContext(p.Context).parents.load(p.Source.(Taxon).Id)
+			return func() (interface{}, error) {
+				parents, err := thunk()
+				if err != nil || len(parents) == 0 {
+					return nil, graphQLError(err)
+				}
+				return parents[0], nil
+			}, nil
+		},
+	})
+	taxonType.AddFieldConfig("children", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taxonType))),
+		Description: "The taxa one rank below, paginated by offset.",
+		Args: graphql.FieldConfigArgument{
+			"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphQLDefaultChildrenLimit},
+			"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			limit, err := limitArg(p, graphQLMaxChildrenLimit)
+			if err != nil {
+				return nil, err
+			}
+			offset := p.Args["offset"].(int)
+			if offset < 0 {
+				return nil, fmt.Errorf("Offset must not be negative")
+			}
+			thunk := requestFromContext(p.Context).children.load(p.Source.(Taxon).Id)
+			return func() (interface{}, error) {
+				children, err := thunk()
+				if err != nil {
+					return nil, graphQLError(err)
+				}
+				return page(children, offset, limit), nil
+			}, nil
+		},
+	})
+	taxonType.AddFieldConfig("lineage", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taxonType))),
+		Description: "The taxa from the kingdom down to this taxon.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id := p.Source.(Taxon).Id
+			thunk := requestFromContext(p.Context).lineages.load(id)
+			return func() (interface{}, error) {
+				taxa, err := thunk()
+				if err != nil {
+					return nil, graphQLError(err)
+				}
+				if len(taxa) == 0 {
+					return nil, graphQLError(newSvcError(ErrNotFound, "No lineage found for taxon '%s'", id))
+				}
+				return taxa, nil
+			}, nil
+		},
+	})
+	taxonType.AddFieldConfig("descendantsCount", &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.Int),
+		Description: "The number of taxa below this taxon.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			thunk := requestFromContext(p.Context).descendants.load(p.Source.(Taxon).Id)
+			return func() (interface{}, error) {
+				count, err := thunk()
+				if err != nil {
+					return nil, graphQLError(err)
+				}
+				return int(count), nil
+			}, nil
+		},
+	})
+
+	rankType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Rank",
+		Fields: graphql.Fields{
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"order": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"count": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return int(p.Source.(Rank).Count), nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"taxon": &graphql.Field{
+				Type: taxonType,
+				Args: graphql.FieldConfigArgument{
+					"rank": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"id":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					taxon, err := requestFromContext(p.Context).svc.Get(p.Args["rank"].(string), p.Args["id"].(string))
+					if err != nil {
+						return nil, graphQLError(err)
+					}
+					return taxon, nil
+				},
+			},
+			"taxonByWikidataId": &graphql.Field{
+				Type: taxonType,
+				Args: graphql.FieldConfigArgument{
+					"qid": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					taxon, err := requestFromContext(p.Context).svc.GetByWikidataId(p.Args["qid"].(string))
+					if err != nil {
+						return nil, graphQLError(err)
+					}
+					return taxon, nil
+				},
+			},
+			"search": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taxonType))),
+				Args: graphql.FieldConfigArgument{
+					"q":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"rank":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphQLDefaultSearchLimit},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					limit, err := limitArg(p, graphQLMaxSearchLimit)
+					if err != nil {
+						return nil, err
+					}
+					taxa, err := requestFromContext(p.Context).svc.Search(p.Args["q"].(string), p.Args["rank"].(string), 0, limit)
+					return taxa, graphQLError(err)
+				},
+			},
+			"ranks": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rankType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					ranks, err := requestFromContext(p.Context).svc.Ranks()
+					return ranks, graphQLError(err)
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+// queryCost walks a selection set and returns its complexity and depth. Each
+// field costs as given by fieldCost, and the cost of the fields selected below
+// a list field is multiplied by the number of items the list may return.
+func queryCost(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, vars map[string]interface{}, maxRanks int) (cost int, depth int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var selCost, selDepth int
+		switch sel := selection.(type) {
+		case *ast.Field:
+			childCost, childDepth := queryCost(sel.SelectionSet, fragments, vars, maxRanks)
+			selCost = fieldCost(sel) + fieldMultiplier(sel, vars, maxRanks)*childCost
+			selDepth = 1 + childDepth
+		case *ast.InlineFragment:
+			selCost, selDepth = queryCost(sel.SelectionSet, fragments, vars, maxRanks)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[sel.Name.Value]; ok {
+				selCost, selDepth = queryCost(fragment.SelectionSet, fragments, vars, maxRanks)
+			}
+		}
+		cost += selCost
+		if selDepth > depth {
+			depth = selDepth
+		}
+	}
+	return cost, depth
+}
+
+// fieldCost returns the cost of resolving a field itself.
+func fieldCost(field *ast.Field) int {
+	if field.Name.Value == "descendantsCount" {
+		return graphQLDescendantsCountCost
+	}
+	return 1
+}
+
+// fieldMultiplier returns the maximum number of items a field may return.
+// Limits are clamped to the range the resolvers accept so that a negative or
+// excessive limit cannot lower the cost of the query.
+func fieldMultiplier(field *ast.Field, vars map[string]interface{}, maxRanks int) int {
+	switch field.Name.Value {
+	case "children":
+		return clampInt(intArg(field, "limit", vars, graphQLDefaultChildrenLimit), 1, graphQLMaxChildrenLimit)
+	case "search":
+		return clampInt(intArg(field, "limit", vars, graphQLDefaultSearchLimit), 1, graphQLMaxSearchLimit)
+	case "lineage", "ranks":
+		return maxRanks
+	}
+	return 1
+}
+
+func clampInt(n int, lo int, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+func intArg(field *ast.Field, name string, vars map[string]interface{}, def int) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != name {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				return n
+			}
+		case *ast.Variable:
+			switch n := vars[value.Name.Value].(type) {
+			case float64:
+				return int(n)
+			case int:
+				return n
+			}
+		}
+	}
+	return def
+}
+
+// operationVars returns the variables of a request with the defaults declared
+// by the operation filled in for those not given.
+func operationVars(op *ast.OperationDefinition, vars map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, def := range op.VariableDefinitions {
+		if value, ok := def.DefaultValue.(*ast.IntValue); ok {
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				merged[def.Variable.Name.Value] = n
+			}
+		}
+	}
+	for name, value := range vars {
+		merged[name] = value
+	}
+	return merged
+}
+
+// checkQueryLimits returns an error if the selected operation exceeds the
+// configured depth or complexity.
+func checkQueryLimits(doc *ast.Document, operationName string, vars map[string]interface{}, cfg Config) error {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+		cost, depth := queryCost(op.SelectionSet, fragments, operationVars(op, vars), len(cfg.TaxonRanks))
+		if depth > cfg.GraphQLMaxDepth {
+			return fmt.Errorf("Query depth %d exceeds maximum of %d", depth, cfg.GraphQLMaxDepth)
+		}
+		if cost > cfg.GraphQLMaxComplexity {
+			return fmt.Errorf("Query complexity %d exceeds maximum of %d", cost, cfg.GraphQLMaxComplexity)
+		}
+	}
+	return nil
+}
+
+// GraphQLRequest is a GraphQL query, sent as the body of a POST or, with the
+// variables encoded as JSON, as the query parameters of a GET.
+type GraphQLRequest struct {
+	Query         string                 `json:"query" query:"query"`
+	OperationName string                 `json:"operationName,omitempty" query:"operationName"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQL serves GraphQL queries over the taxonomy graph. Queries are accepted
+// as JSON in a POST body or as `query`, `operationName` and `variables`
+// parameters of a GET request.
+func GraphQL(cfg Config) echo.HandlerFunc {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		panic(err)
+	}
+	return func(c echo.Context) error {
+		taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+		req := GraphQLRequest{}
+		if err := c.Bind(&req); err != nil {
+			return badRequest("Invalid GraphQL request")
+		}
+		if vars := c.QueryParam("variables"); c.Request().Method == http.MethodGet && vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				return badRequest("Invalid GraphQL variables")
+			}
+		}
+
+		doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		}
+		if validation := graphql.ValidateDocument(&schema, doc, nil); !validation.IsValid {
+			return c.JSON(http.StatusBadRequest, graphql.Result{Errors: validation.Errors})
+		}
+		if err := checkQueryLimits(doc, req.OperationName, req.Variables, cfg); err != nil {
+			return c.JSON(http.StatusBadRequest, graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		}
+
+		ctx := context.WithValue(c.Request().Context(), graphQLCtxKey{}, &graphQLRequest{
+			svc:         taxSvc,
+			children:    newBatchLoader(taxSvc.GetChildrenBatch),
+			parents:     newBatchLoader(taxSvc.GetParentsBatch),
+			lineages:    newBatchLoader(taxSvc.GetLineageBatch),
+			descendants: newBatchLoader(taxSvc.CountDescendantsBatch),
+		})
+		result := graphql.Execute(graphql.ExecuteParams{
+			Schema:        schema,
+			AST:           doc,
+			OperationName: req.OperationName,
+			Args:          req.Variables,
+			Context:       ctx,
+		})
+		return c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	http "net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Health serves the liveness and readiness probes of the server.
+type Health struct {
+	taxonSvcs map[string]*TaxonSvc
+	timeout   time.Duration
+	draining  atomic.Bool
+}
+
+func NewHealth(taxonSvcs map[string]*TaxonSvc, timeout time.Duration) *Health {
+	return &Health{taxonSvcs: taxonSvcs, timeout: timeout}
+}
+
+// Drain makes the server report not ready while it shuts down, so that no
+// new requests are routed to it.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// isProbe reports whether a request is for a probe. Probes bypass
+// authentication, rate limits, request logging and tracing.
+func isProbe(c echo.Context) bool {
+	return c.Path() == "/healthz" || c.Path() == "/readyz"
+}
+
+// Healthz reports that the process is alive.
+func (h *Health) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, JSONResp{"status": "ok"})
+}
+
+// Readyz reports whether every graph can be queried, checking the database
+// with a timeout.
+func (h *Health) Readyz(c echo.Context) error {
+	if h.draining.Load() {
+		return c.JSON(http.StatusServiceUnavailable, JSONResp{"status": "draining"})
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), h.timeout)
+	defer cancel()
+	status, code := "ready", http.StatusOK
+	graphs := map[string]string{}
+	for name, svc := range h.taxonSvcs {
+		graphs[name] = "ok"
+		if err := svc.Ready(ctx); err != nil {
+			c.Logger().Warnf("Graph '%s' not ready: %v", name, err)
+			graphs[name] = "unavailable"
+			var svcErr *SvcError
+			if errors.As(err, &svcErr) {
+				graphs[name] = svcErr.Message
+			}
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	return c.JSON(code, JSONResp{"status": status, "graphs": graphs})
+}
@@ -1,62 +1,264 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	http "net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel"
 )
 
 func main() {
 	// Load config
-	cfg, err := LoadConfig("./app.env")
+	// CONFIG_FILE selects the env file of a deployment, e.g. staging.env.
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = "./app.env"
+	}
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config in %s:\n%v\n", configFile, err)
+		os.Exit(1)
+	}
+
+	// API keys are kept in the default graph's database.
+	db, err := ConnectArangoDB(cfg)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	apiKeys := NewArangoAPIKeyRepo(db)
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKeyCommand(apiKeys, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Init database
-	db, err := GetArangoDB(cfg)
+	tracerProvider, err := NewTracerProvider(cfg)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if tracerProvider != nil {
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	// Init databases
+	metrics := NewMetrics()
+	graphs := cfg.Graphs()
+	taxonSvcs := map[string]*TaxonSvc{}
+	for _, graph := range graphs {
+		graphCfg := cfg
+		graphCfg.GraphName = graph.Name
+		graphCfg.DatabaseName = graph.Database
+		db, err := ConnectArangoDB(graphCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		repo := metrics.ObserveRepo(NewArangoTaxonRepo(db, graphCfg), graph.Name)
+		taxonSvcs[graph.Name] = NewTaxonSvc(repo, graphCfg)
+		metrics.ObserveCache(taxonSvcs[graph.Name], graph.Name)
+	}
+
+	limiter := NewRateLimiter(cfg, apiKeys)
+	health := NewHealth(taxonSvcs, cfg.ReadyTimeout)
+	router := NewRouter(cfg, taxonSvcs, limiter, health, metrics)
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := limiter.FlushUsage(); err != nil {
+				router.Logger.Errorf("Failed to record API key usage: %v", err)
+			}
+		}
+	}()
+
+	// Start server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	servers, errs := startServers(cfg, router, metrics)
+	select {
+	case err := <-errs:
+		router.Logger.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	// Report not ready until load balancers have stopped routing requests
+	// here, then stop accepting requests and wait for those in flight.
+	router.Logger.Info("Shutting down")
+	health.Drain()
+	time.Sleep(cfg.ShutdownDrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			router.Logger.Errorf("Failed to shut down gracefully: %v", err)
+		}
+	}
+	if err := limiter.FlushUsage(); err != nil {
+		router.Logger.Errorf("Failed to record API key usage: %v", err)
+	}
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			router.Logger.Errorf("Failed to export spans: %v", err)
+		}
+	}
+}
+
+// startServers starts the API server, with TLS if configured, the HTTP to
+// HTTPS redirect server and the admin server. It returns the started servers
+// and a channel receiving the error of any server that stops other than by
+// shutdown.
+func startServers(cfg Config, router *echo.Echo, metrics *Metrics) ([]*http.Server, <-chan error) {
+	errs := make(chan error, 3)
+	serve := func(start func() error) {
+		if err := start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}
+	for _, server := range []*http.Server{router.Server, router.TLSServer} {
+		server.ReadTimeout = cfg.ReadTimeout
+		server.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+		server.WriteTimeout = cfg.WriteTimeout
+		server.IdleTimeout = cfg.IdleTimeout
+	}
+	servers := []*http.Server{}
+	if cfg.MetricsAddr != "" {
+		// Metrics are served apart from the API, without authentication.
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		admin := &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
+		go serve(admin.ListenAndServe)
+		servers = append(servers, admin)
+	}
+	if cfg.TLSCertFile == "" {
+		go serve(func() error { return router.Start(cfg.ListenAddr) })
+		return append(servers, router.Server), errs
 	}
 
-	// _, err := http.Get("https://" + os.Getenv("AUTH0_DOMAIN") + "/.well-known/jwks.json")
-	// if err != nil {
-	// 	fmt.Println(err.Error())
-	// }
+	certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		router.Logger.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+	if cfg.TLSReloadInterval > 0 {
+		go certs.watch(cfg.TLSReloadInterval, router.Logger)
+	}
+	router.TLSServer.Addr = cfg.ListenAddr
+	router.TLSServer.TLSConfig = newTLSConfig(certs)
+	go serve(func() error { return router.StartServer(router.TLSServer) })
+	servers = append(servers, router.TLSServer)
+	if cfg.HTTPRedirectAddr != "" {
+		redirect := &http.Server{
+			Addr:              cfg.HTTPRedirectAddr,
+			Handler:           redirectToHTTPS(cfg.ListenAddr),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
+		go serve(redirect.ListenAndServe)
+		servers = append(servers, redirect)
+	}
+	return servers, errs
+}
 
+// NewRouter creates the Echo router serving the API for the given graphs.
+func NewRouter(cfg Config, taxonSvcs map[string]*TaxonSvc, limiter *RateLimiter, health *Health, metrics *Metrics) *echo.Echo {
 	// Echo instance
 	router := echo.New()
+	router.HTTPErrorHandler = HTTPErrorHandler
+	router.IPExtractor = ipExtractor(cfg.TrustedProxies)
 
 	// Middleware
 	router.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"http://localhost:5173", "http://orion:5173"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
+		AllowOrigins:  cfg.CORSAllowOrigins,
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, HeaderAPIKey, echo.HeaderXRequestID, "traceparent", "tracestate", HeaderIfNoneMatch, echo.HeaderIfModifiedSince},
+		AllowMethods:  cfg.CORSAllowMethods,
+		ExposeHeaders: []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", echo.HeaderRetryAfter, echo.HeaderXRequestID, HeaderETag},
 	}))
 	router.Pre(middleware.RemoveTrailingSlash())
-	router.Use(middleware.Logger())
+	router.Use(middleware.RequestID())
+	router.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Skipper: isProbe}))
+	router.Use(Tracing())
+	router.Use(metrics.Middleware())
 	router.Use(middleware.Recover())
-	router.Use(middleware.GzipWithConfig(middleware.GzipConfig{
-		Level: 5,
-	}))
+	if cfg.HSTSMaxAge > 0 {
+		// Only sent on TLS requests, including those from a TLS terminating proxy.
+		router.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+			HSTSMaxAge:            int(cfg.HSTSMaxAge.Seconds()),
+			HSTSExcludeSubdomains: !cfg.HSTSIncludeSubdomains,
+		}))
+	}
+	router.Use(limiter.Middleware())
+	if cfg.BodyLimit != "" {
+		router.Use(middleware.BodyLimit(cfg.BodyLimit))
+	}
+	if cfg.GzipLevel != 0 {
+		router.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+			Level: cfg.GzipLevel,
+		}))
+	}
 
-	router.Use(TaxonSvcContext(db))
-	// router.Use(auth.ParseJWT)
+	router.Use(ParseJWT(NewAuthenticator(cfg), cfg.AuthPublicReads))
+	openAPI := NewOpenAPI(cfg)
+	router.Use(ValidateRequest(openAPI))
+	router.Use(TaxonSvcContext(taxonSvcs, cfg.GraphName))
 
 	// Routes
+	router.GET("/healthz", health.Healthz)
+	router.GET("/readyz", health.Readyz)
+	// GraphQL queries the default graph here and each graph by name under
+	// /api/v1/graphs/:graph/graphql.
+	graphQL := GraphQL(cfg)
+	router.GET("/graphql", graphQL)
+	router.POST("/graphql", graphQL)
 	api := router.Group("/api/v1")
 	{
-		taxon := api.Group("/taxon")
-		{
-			taxon.GET("/:rank/:id/children", TaxonGetChildren)
-			taxon.GET("/:rank/:id", TaxonGet)
-		}
+		api.GET("/graphs", GraphsGet(cfg.Graphs()))
+		api.GET("/openapi.json", OpenAPIGet(openAPI))
+		api.GET("/docs", SwaggerUIGet)
+		// Graph routes are served for the default graph and for each graph by name.
+		write, admin := RequireRole(cfg.AuthWriteRole), RequireRole(cfg.AuthAdminRole)
+		addGraphRoutes(api, cfg, write, admin)
+		addGraphRoutes(api.Group("/graphs/:graph"), cfg, write, admin)
 	}
+	return router
+}
 
-	// Start server
-	port := os.Getenv("PORT")
-	router.Logger.Fatal(router.Start(fmt.Sprintf(":%s", port)))
+// addGraphRoutes adds the routes of a graph. Reads are open to anyone allowed
+// by ParseJWT, writes need the write middleware and the audit log the admin
+// middleware. Taxa and their children can be cached by clients.
+func addGraphRoutes(api *echo.Group, cfg Config, write echo.MiddlewareFunc, admin echo.MiddlewareFunc) {
+	taxon := api.Group("/taxon")
+	{
+		taxon.GET("/by-wikidata/:qid", TaxonGetByWikidataId)
+		taxon.GET("/by-url", TaxonGetByUrl)
+		taxon.GET("/:rank/:id/children", TaxonGetChildren, HTTPCaching(cfg.CacheControlChildren))
+		taxon.GET("/:rank/:id/lineage", TaxonGetLineage)
+		taxon.GET("/:rank/:id/export", TaxonExport)
+		taxon.GET("/:rank/:id/crossrefs", TaxonGetCrossRefs)
+		taxon.GET("/:rank/:id/audit", TaxonGetAudit, admin)
+		taxon.GET("/:rank/:id", TaxonGet, HTTPCaching(cfg.CacheControlTaxon))
+		taxon.POST("", TaxonCreate, write)
+		taxon.PATCH("/:rank/:id", TaxonUpdate, write)
+		taxon.DELETE("/:rank/:id", TaxonDelete, write)
+	}
+	api.GET("/mrca", MRCAGet)
+	api.GET("/ranks", RanksGet)
+	api.GET("/search", TaxonSearch)
+	api.GET("/crossrefs", CrossRefsList)
+	api.GET("/snapshots", SnapshotsList)
+	api.GET("/snapshots/diff", SnapshotsDiff)
+	graphQL := GraphQL(cfg)
+	api.GET("/graphql", graphQL)
+	api.POST("/graphql", graphQL)
 }
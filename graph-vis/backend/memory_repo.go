@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Edge links a child taxon to its parent, as stored in the `*Members` edge
+// collections.
+type Edge struct {
+	From string `json:"_from"`
+	To   string `json:"_to"`
+}
+
+// MemoryFixture is the JSON document loaded by LoadMemoryTaxonRepo.
+type MemoryFixture struct {
+	Taxa      []Taxon    `json:"taxa"`
+	Edges     []Edge     `json:"edges"`
+	CrossRefs []CrossRef `json:"crossrefs"`
+	Snapshots []Snapshot `json:"snapshots"`
+	// TaxonVersions holds the snapshot history of taxa.
+	TaxonVersions []TaxonVersion `json:"taxonVersions"`
+}
+
+// MemoryTaxonRepo is a TaxonRepo holding a graph in memory. It is intended
+// for tests and small fixtures. Writes are not safe for concurrent use.
+type MemoryTaxonRepo struct {
+	taxa      map[string]Taxon
+	children  map[string][]string
+	parents   map[string][]string
+	crossRefs []CrossRef
+	snapshots []Snapshot
+	versions  []TaxonVersion
+	audit     []AuditEntry
+	version   GraphVersion
+}
+
+func NewMemoryTaxonRepo(fixture MemoryFixture) *MemoryTaxonRepo {
+	repo := &MemoryTaxonRepo{
+		taxa:      map[string]Taxon{},
+		children:  map[string][]string{},
+		parents:   map[string][]string{},
+		crossRefs: fixture.CrossRefs,
+		snapshots: fixture.Snapshots,
+		versions:  fixture.TaxonVersions,
+	}
+	for _, taxon := range fixture.Taxa {
+		repo.taxa[taxon.Id] = taxon
+	}
+	for _, edge := range fixture.Edges {
+		repo.children[edge.To] = append(repo.children[edge.To], edge.From)
+		repo.parents[edge.From] = append(repo.parents[edge.From], edge.To)
+	}
+	return repo
+}
+
+// LoadMemoryTaxonRepo creates a MemoryTaxonRepo from a JSON fixture file.
+func LoadMemoryTaxonRepo(path string) (*MemoryTaxonRepo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := MemoryFixture{}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, err
+	}
+	return NewMemoryTaxonRepo(fixture), nil
+}
+
+func (repo *MemoryTaxonRepo) Ready(ctx context.Context) error {
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) Get(rank string, id string) (Taxon, error) {
+	taxon, ok := repo.taxa[rank+"/"+id]
+	if !ok {
+		return taxon, newSvcError(ErrNotFound, "Taxon '%s/%s' not found", rank, id)
+	}
+	return taxon, nil
+}
+
+func (repo *MemoryTaxonRepo) GetChildren(rank string, id string) ([]Taxon, error) {
+	taxa := []Taxon{}
+	for _, child := range repo.children[rank+"/"+id] {
+		taxa = append(taxa, repo.taxa[child])
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) GetLineage(rank string, id string, root string) ([]Taxon, error) {
+	taxa := repo.lineage(rank+"/"+id, root)
+	if taxa == nil {
+		return []Taxon{}, newSvcError(ErrNotFound, "No lineage found for taxon '%s/%s'", rank, id)
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) GetLineageBatch(ids []string, root string) (map[string][]Taxon, error) {
+	lineages := map[string][]Taxon{}
+	for _, id := range ids {
+		if taxa := repo.lineage(id, root); taxa != nil {
+			lineages[id] = taxa
+		}
+	}
+	return lineages, nil
+}
+
+// lineage returns the path from a taxon of the root rank down to the taxon,
+// or nil if there is none.
+func (repo *MemoryTaxonRepo) lineage(id string, root string) []Taxon {
+	taxa := repo.pathToRoot(id, root)
+	// Paths start at the given taxon. Reverse to start at the root.
+	for i, j := 0, len(taxa)-1; i < j; i, j = i+1, j-1 {
+		taxa[i], taxa[j] = taxa[j], taxa[i]
+	}
+	return taxa
+}
+
+// pathToRoot returns the first path from the taxon up to a taxon of the root
+// rank, or nil if there is none.
+func (repo *MemoryTaxonRepo) pathToRoot(id string, root string) []Taxon {
+	taxon, ok := repo.taxa[id]
+	if !ok {
+		return nil
+	}
+	if strings.ToLower(taxon.Rank) == root {
+		return []Taxon{taxon}
+	}
+	for _, parent := range repo.parents[id] {
+		if path := repo.pathToRoot(parent, root); path != nil {
+			return append([]Taxon{taxon}, path...)
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) FindByWikidataId(rank string, qid string) ([]Taxon, error) {
+	taxa := []Taxon{}
+	for id, taxon := range repo.taxa {
+		if strings.HasPrefix(id, rank+"/") && taxon.WikidataId == qid {
+			taxa = append(taxa, taxon)
+		}
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) FindByLegacyKey(rank string, key string) ([]Taxon, error) {
+	taxa := []Taxon{}
+	for id, taxon := range repo.taxa {
+		if strings.HasPrefix(id, rank+"/") && taxon.LegacyKey == key {
+			taxa = append(taxa, taxon)
+		}
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) FindByUrls(rank string, urls []string) ([]Taxon, error) {
+	taxa := []Taxon{}
+	for id, taxon := range repo.taxa {
+		if !strings.HasPrefix(id, rank+"/") {
+			continue
+		}
+		for _, url := range urls {
+			if taxon.Url == url {
+				taxa = append(taxa, taxon)
+				break
+			}
+		}
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) Search(rank string, query string, limit int) ([]Taxon, error) {
+	taxa := []Taxon{}
+	query = strings.ToLower(query)
+	for id, taxon := range repo.taxa {
+		if strings.HasPrefix(id, rank+"/") && strings.Contains(strings.ToLower(taxon.Name), query) {
+			taxa = append(taxa, taxon)
+		}
+	}
+	sort.Slice(taxa, func(i, j int) bool { return taxa[i].Name < taxa[j].Name })
+	if len(taxa) > limit {
+		taxa = taxa[:limit]
+	}
+	return taxa, nil
+}
+
+func (repo *MemoryTaxonRepo) Count(rank string) (int64, error) {
+	var count int64
+	for id := range repo.taxa {
+		if strings.HasPrefix(id, rank+"/") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (repo *MemoryTaxonRepo) CountDescendants(rank string, id string) (int64, error) {
+	return repo.countDescendants(rank + "/" + id), nil
+}
+
+func (repo *MemoryTaxonRepo) CountDescendantsBatch(ids []string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	for _, id := range ids {
+		counts[id] = repo.countDescendants(id)
+	}
+	return counts, nil
+}
+
+func (repo *MemoryTaxonRepo) countDescendants(id string) int64 {
+	seen := map[string]bool{}
+	queue := repo.children[id]
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = queue[1:]
+		if !seen[child] {
+			seen[child] = true
+			queue = append(queue, repo.children[child]...)
+		}
+	}
+	return int64(len(seen))
+}
+
+func (repo *MemoryTaxonRepo) GetChildrenBatch(ids []string) (map[string][]Taxon, error) {
+	return repo.getNeighboursBatch(ids, repo.children), nil
+}
+
+func (repo *MemoryTaxonRepo) GetParentsBatch(ids []string) (map[string][]Taxon, error) {
+	return repo.getNeighboursBatch(ids, repo.parents), nil
+}
+
+func (repo *MemoryTaxonRepo) getNeighboursBatch(ids []string, adjacency map[string][]string) map[string][]Taxon {
+	neighbours := map[string][]Taxon{}
+	for _, id := range ids {
+		for _, neighbour := range adjacency[id] {
+			neighbours[id] = append(neighbours[id], repo.taxa[neighbour])
+		}
+	}
+	return neighbours
+}
+
+func (repo *MemoryTaxonRepo) WalkSubtree(rank string, id string, fn func(SubtreeNode) error) error {
+	return repo.walk(rank+"/"+id, "", 0, fn)
+}
+
+func (repo *MemoryTaxonRepo) walk(id string, parent string, depth int, fn func(SubtreeNode) error) error {
+	if err := fn(SubtreeNode{Taxon: repo.taxa[id], Parent: parent, Depth: depth}); err != nil {
+		return err
+	}
+	for _, child := range repo.children[id] {
+		if err := repo.walk(child, id, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) GetCrossRefs(rank string, id string) ([]CrossRef, error) {
+	crossRefs := []CrossRef{}
+	for _, crossRef := range repo.crossRefs {
+		if crossRef.TaxonId == rank+"/"+id {
+			crossRefs = append(crossRefs, crossRef)
+		}
+	}
+	return crossRefs, nil
+}
+
+func (repo *MemoryTaxonRepo) ListCrossRefs(source string, status string, offset int, limit int) ([]CrossRef, error) {
+	crossRefs := []CrossRef{}
+	for _, crossRef := range repo.crossRefs {
+		if (source == "" || crossRef.Source == source) && (status == "" || crossRef.Status == status) {
+			crossRefs = append(crossRefs, crossRef)
+		}
+	}
+	sort.Slice(crossRefs, func(i, j int) bool {
+		if crossRefs[i].TaxonId != crossRefs[j].TaxonId {
+			return crossRefs[i].TaxonId < crossRefs[j].TaxonId
+		}
+		return crossRefs[i].Source < crossRefs[j].Source
+	})
+	if offset > len(crossRefs) {
+		offset = len(crossRefs)
+	}
+	crossRefs = crossRefs[offset:]
+	if len(crossRefs) > limit {
+		crossRefs = crossRefs[:limit]
+	}
+	return crossRefs, nil
+}
+
+func (repo *MemoryTaxonRepo) ListSnapshots() ([]Snapshot, error) {
+	snapshots := append([]Snapshot{}, repo.snapshots...)
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Seq < snapshots[j].Seq })
+	return snapshots, nil
+}
+
+func (repo *MemoryTaxonRepo) GetSnapshotTaxa(seq int) ([]TaxonVersion, error) {
+	versions := []TaxonVersion{}
+	for _, v := range repo.versions {
+		if v.ValidFrom <= seq && (v.ValidTo == nil || *v.ValidTo > seq) {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].TaxonId < versions[j].TaxonId })
+	return versions, nil
+}
+
+// removeId returns ids without id.
+func removeId(ids []string, id string) []string {
+	kept := []string{}
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+func (repo *MemoryTaxonRepo) CreateTaxon(taxon Taxon, parent string) error {
+	if _, ok := repo.taxa[taxon.Id]; ok {
+		return newSvcError(ErrConflict, "Taxon '%s' already exists", taxon.Id)
+	}
+	repo.taxa[taxon.Id] = taxon
+	if parent == "" {
+		return nil
+	}
+	return repo.SetParent(taxon, parent)
+}
+
+func (repo *MemoryTaxonRepo) UpdateTaxon(taxon Taxon) error {
+	taxon.Curated = true
+	repo.taxa[taxon.Id] = taxon
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) SetParent(taxon Taxon, parent string) error {
+	for _, old := range repo.parents[taxon.Id] {
+		repo.children[old] = removeId(repo.children[old], taxon.Id)
+	}
+	repo.parents[taxon.Id] = []string{parent}
+	repo.children[parent] = append(repo.children[parent], taxon.Id)
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) DeleteTaxon(taxon Taxon, mergeInto string) error {
+	if mergeInto != "" {
+		for _, child := range repo.children[taxon.Id] {
+			repo.parents[child] = append(removeId(repo.parents[child], taxon.Id), mergeInto)
+			repo.children[mergeInto] = append(repo.children[mergeInto], child)
+		}
+		for i := range repo.crossRefs {
+			if repo.crossRefs[i].TaxonId == taxon.Id {
+				repo.crossRefs[i].TaxonId = mergeInto
+			}
+		}
+	} else {
+		for _, child := range repo.children[taxon.Id] {
+			repo.parents[child] = removeId(repo.parents[child], taxon.Id)
+		}
+	}
+	for _, parent := range repo.parents[taxon.Id] {
+		repo.children[parent] = removeId(repo.children[parent], taxon.Id)
+	}
+	delete(repo.children, taxon.Id)
+	delete(repo.parents, taxon.Id)
+	delete(repo.taxa, taxon.Id)
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) AddAuditEntry(entry AuditEntry) error {
+	repo.audit = append(repo.audit, entry)
+	return nil
+}
+
+func (repo *MemoryTaxonRepo) ListAuditEntries(taxonId string) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	for _, entry := range repo.audit {
+		if entry.TaxonId == taxonId || entry.MergedInto == taxonId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (repo *MemoryTaxonRepo) GetVersion() (GraphVersion, error) {
+	return repo.version, nil
+}
+
+func (repo *MemoryTaxonRepo) BumpVersion() error {
+	repo.version = GraphVersion{Version: repo.version.Version + 1, UpdatedAt: time.Now().UTC()}
+	return nil
+}
+
+// MemoryAPIKeyRepo is an APIKeyRepo holding keys in memory, for tests.
+type MemoryAPIKeyRepo struct {
+	mu   sync.Mutex
+	keys map[string]APIKey
+}
+
+func NewMemoryAPIKeyRepo() *MemoryAPIKeyRepo {
+	return &MemoryAPIKeyRepo{keys: map[string]APIKey{}}
+}
+
+func (repo *MemoryAPIKeyRepo) GetAPIKey(id string) (APIKey, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	key, ok := repo.keys[id]
+	if !ok {
+		return key, newSvcError(ErrNotFound, "API key '%s' not found", id)
+	}
+	return key, nil
+}
+
+func (repo *MemoryAPIKeyRepo) ListAPIKeys() ([]APIKey, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	keys := []APIKey{}
+	for _, key := range repo.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (repo *MemoryAPIKeyRepo) CreateAPIKey(key APIKey) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.keys[key.Id]; ok {
+		return newSvcError(ErrConflict, "API key '%s' already exists", key.Id)
+	}
+	repo.keys[key.Id] = key
+	return nil
+}
+
+func (repo *MemoryAPIKeyRepo) RevokeAPIKey(id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	key, ok := repo.keys[id]
+	if !ok {
+		return newSvcError(ErrNotFound, "API key '%s' not found", id)
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	repo.keys[id] = key
+	return nil
+}
+
+func (repo *MemoryAPIKeyRepo) AddAPIKeyUsage(usage map[string]APIKeyUsage) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for id, u := range usage {
+		key, ok := repo.keys[id]
+		if !ok {
+			continue
+		}
+		key.Requests += u.Requests
+		key.Limited += u.Limited
+		key.LastUsedAt = u.LastUsedAt
+		repo.keys[id] = key
+	}
+	return nil
+}
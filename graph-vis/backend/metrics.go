@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	http "net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus metrics of the server, served at /metrics on
+// the admin listener.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+	requests        *prometheus.CounterVec
+	inFlight        prometheus.Gauge
+	queryDuration   *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "graphvis",
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "graphvis",
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "graphvis",
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests being served.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "graphvis",
+			Name:      "db_query_duration_seconds",
+			Help:      "Latency of database queries by graph and repository operation.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"graph", "operation", "outcome"}),
+	}
+	m.registry.MustRegister(
+		m.requestDuration, m.requests, m.inFlight, m.queryDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the latency and status code of each request by route.
+// Requests not matching a route are counted under the route "unmatched", so
+// that scanners cannot create a series per path.
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Write the error response now to record its status.
+				c.Error(err)
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+			m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			m.requests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			return err
+		}
+	}
+}
+
+// ObserveCache exports the counters of the lookup cache of svc under the
+// graph name. It does nothing if caching is disabled.
+func (m *Metrics) ObserveCache(svc *TaxonSvc, graph string) {
+	if svc.cache == nil {
+		return
+	}
+	labels := prometheus.Labels{"graph": graph}
+	stat := func(get func(CacheStats) float64) func() float64 {
+		return func() float64 { return get(svc.cache.Stats()) }
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "graphvis", Name: "cache_hits_total", Help: "Taxon lookups answered from the cache.", ConstLabels: labels,
+		}, stat(func(s CacheStats) float64 { return float64(s.Hits) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "graphvis", Name: "cache_misses_total", Help: "Taxon lookups not in the cache.", ConstLabels: labels,
+		}, stat(func(s CacheStats) float64 { return float64(s.Misses) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "graphvis", Name: "cache_evictions_total", Help: "Cached lookups evicted to make room.", ConstLabels: labels,
+		}, stat(func(s CacheStats) float64 { return float64(s.Evictions) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "graphvis", Name: "cache_entries", Help: "Lookups in the cache.", ConstLabels: labels,
+		}, stat(func(s CacheStats) float64 { return float64(s.Entries) })),
+	)
+}
+
+// ObserveRepo returns repo with the duration of each operation recorded
+// under the graph name.
+func (m *Metrics) ObserveRepo(repo TaxonRepo, graph string) TaxonRepo {
+	return &observedTaxonRepo{repo: repo, graph: graph, duration: m.queryDuration}
+}
+
+// observedTaxonRepo records the duration of the operations of a TaxonRepo.
+type observedTaxonRepo struct {
+	repo     TaxonRepo
+	graph    string
+	duration *prometheus.HistogramVec
+}
+
+// observe records an operation started at start. It is deferred with a
+// pointer to the operation's error. Lookups of missing taxa succeed.
+func (r *observedTaxonRepo) observe(operation string, start time.Time, err *error) {
+	outcome := "ok"
+	if *err != nil && !errors.Is(*err, ErrNotFound) {
+		outcome = "error"
+	}
+	r.duration.WithLabelValues(r.graph, operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (r *observedTaxonRepo) Ready(ctx context.Context) (err error) {
+	defer r.observe("Ready", time.Now(), &err)
+	return r.repo.Ready(ctx)
+}
+
+func (r *observedTaxonRepo) Get(rank string, id string) (_ Taxon, err error) {
+	defer r.observe("Get", time.Now(), &err)
+	return r.repo.Get(rank, id)
+}
+
+func (r *observedTaxonRepo) GetChildren(rank string, id string) (_ []Taxon, err error) {
+	defer r.observe("GetChildren", time.Now(), &err)
+	return r.repo.GetChildren(rank, id)
+}
+
+func (r *observedTaxonRepo) GetLineage(rank string, id string, root string) (_ []Taxon, err error) {
+	defer r.observe("GetLineage", time.Now(), &err)
+	return r.repo.GetLineage(rank, id, root)
+}
+
+func (r *observedTaxonRepo) FindByWikidataId(rank string, qid string) (_ []Taxon, err error) {
+	defer r.observe("FindByWikidataId", time.Now(), &err)
+	return r.repo.FindByWikidataId(rank, qid)
+}
+
+func (r *observedTaxonRepo) FindByLegacyKey(rank string, key string) (_ []Taxon, err error) {
+	defer r.observe("FindByLegacyKey", time.Now(), &err)
+	return r.repo.FindByLegacyKey(rank, key)
+}
+
+func (r *observedTaxonRepo) FindByUrls(rank string, urls []string) (_ []Taxon, err error) {
+	defer r.observe("FindByUrls", time.Now(), &err)
+	return r.repo.FindByUrls(rank, urls)
+}
+
+func (r *observedTaxonRepo) Search(rank string, query string, limit int) (_ []Taxon, err error) {
+	defer r.observe("Search", time.Now(), &err)
+	return r.repo.Search(rank, query, limit)
+}
+
+func (r *observedTaxonRepo) Count(rank string) (_ int64, err error) {
+	defer r.observe("Count", time.Now(), &err)
+	return r.repo.Count(rank)
+}
+
+func (r *observedTaxonRepo) CountDescendants(rank string, id string) (_ int64, err error) {
+	defer r.observe("CountDescendants", time.Now(), &err)
+	return r.repo.CountDescendants(rank, id)
+}
+
+func (r *observedTaxonRepo) GetChildrenBatch(ids []string) (_ map[string][]Taxon, err error) {
+	defer r.observe("GetChildrenBatch", time.Now(), &err)
+	return r.repo.GetChildrenBatch(ids)
+}
+
+func (r *observedTaxonRepo) GetParentsBatch(ids []string) (_ map[string][]Taxon, err error) {
+	defer r.observe("GetParentsBatch", time.Now(), &err)
+	return r.repo.GetParentsBatch(ids)
+}
+
+func (r *observedTaxonRepo) GetLineageBatch(ids []string, root string) (_ map[string][]Taxon, err error) {
+	defer r.observe("GetLineageBatch", time.Now(), &err)
+	return r.repo.GetLineageBatch(ids, root)
+}
+
+func (r *observedTaxonRepo) CountDescendantsBatch(ids []string) (_ map[string]int64, err error) {
+	defer r.observe("CountDescendantsBatch", time.Now(), &err)
+	return r.repo.CountDescendantsBatch(ids)
+}
+
+// WalkSubtree is timed including the time spent in fn, which for exports is
+// mostly writing the response.
+func (r *observedTaxonRepo) WalkSubtree(rank string, id string, fn func(SubtreeNode) error) (err error) {
+	defer r.observe("WalkSubtree", time.Now(), &err)
+	return r.repo.WalkSubtree(rank, id, fn)
+}
+
+func (r *observedTaxonRepo) GetCrossRefs(rank string, id string) (_ []CrossRef, err error) {
+	defer r.observe("GetCrossRefs", time.Now(), &err)
+	return r.repo.GetCrossRefs(rank, id)
+}
+
+func (r *observedTaxonRepo) ListSnapshots() (_ []Snapshot, err error) {
+	defer r.observe("ListSnapshots", time.Now(), &err)
+	return r.repo.ListSnapshots()
+}
+
+func (r *observedTaxonRepo) GetSnapshotTaxa(seq int) (_ []TaxonVersion, err error) {
+	defer r.observe("GetSnapshotTaxa", time.Now(), &err)
+	return r.repo.GetSnapshotTaxa(seq)
+}
+
+func (r *observedTaxonRepo) CreateTaxon(taxon Taxon, parent string) (err error) {
+	defer r.observe("CreateTaxon", time.Now(), &err)
+	return r.repo.CreateTaxon(taxon, parent)
+}
+
+func (r *observedTaxonRepo) UpdateTaxon(taxon Taxon) (err error) {
+	defer r.observe("UpdateTaxon", time.Now(), &err)
+	return r.repo.UpdateTaxon(taxon)
+}
+
+func (r *observedTaxonRepo) SetParent(taxon Taxon, parent string) (err error) {
+	defer r.observe("SetParent", time.Now(), &err)
+	return r.repo.SetParent(taxon, parent)
+}
+
+func (r *observedTaxonRepo) DeleteTaxon(taxon Taxon, mergeInto string) (err error) {
+	defer r.observe("DeleteTaxon", time.Now(), &err)
+	return r.repo.DeleteTaxon(taxon, mergeInto)
+}
+
+func (r *observedTaxonRepo) AddAuditEntry(entry AuditEntry) (err error) {
+	defer r.observe("AddAuditEntry", time.Now(), &err)
+	return r.repo.AddAuditEntry(entry)
+}
+
+func (r *observedTaxonRepo) ListAuditEntries(taxonId string) (_ []AuditEntry, err error) {
+	defer r.observe("ListAuditEntries", time.Now(), &err)
+	return r.repo.ListAuditEntries(taxonId)
+}
+
+func (r *observedTaxonRepo) GetVersion() (_ GraphVersion, err error) {
+	defer r.observe("GetVersion", time.Now(), &err)
+	return r.repo.GetVersion()
+}
+
+func (r *observedTaxonRepo) BumpVersion() (err error) {
+	defer r.observe("BumpVersion", time.Now(), &err)
+	return r.repo.BumpVersion()
+}
+
+func (r *observedTaxonRepo) ListCrossRefs(source string, status string, offset int, limit int) (_ []CrossRef, err error) {
+	defer r.observe("ListCrossRefs", time.Now(), &err)
+	return r.repo.ListCrossRefs(source, status, offset, limit)
+}
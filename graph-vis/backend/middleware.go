@@ -1,17 +1,192 @@
 package main
 
 import (
-	arango "github.com/arangodb/go-driver"
+	"errors"
+	"fmt"
+	http "net/http"
+	"strings"
+	"time"
+
 	"github.com/labstack/echo/v4"
 )
 
-// TaxonSvcContext middleware makes TaxonSvc available in request context.
-func TaxonSvcContext(db arango.Database) echo.MiddlewareFunc {
-	taxonSvc := NewTaxonSvc(db)
+// HTTP caching headers not defined by Echo.
+const (
+	HeaderETag        = "ETag"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+// TaxonSvcContext middleware makes the TaxonSvc for the requested graph
+// available in request context. Routes without a `:graph` parameter use the
+// default graph.
+func TaxonSvcContext(taxonSvcs map[string]*TaxonSvc, defaultGraph string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			graph := c.Param("graph")
+			if graph == "" {
+				graph = defaultGraph
+			}
+			taxonSvc, ok := taxonSvcs[graph]
+			if !ok {
+				return NewAPIError(http.StatusNotFound, fmt.Sprintf("Unknown graph '%s'", graph), nil)
+			}
+			// Answer against the taxonomy as it was at a snapshot if requested.
+			if asOf := c.QueryParam("asOf"); asOf != "" {
+				var err error
+				if taxonSvc, err = taxonSvc.AsOf(asOf); err != nil {
+					return err
+				}
+			}
 			c.Set("taxonSvc", taxonSvc)
 			return next(c)
 		}
 	}
 }
+
+// requestID returns the ID of a request, as set by the RequestID middleware
+// from the X-Request-ID header or generated.
+func requestID(c echo.Context) string {
+	return c.Response().Header().Get(echo.HeaderXRequestID)
+}
+
+// unauthorized returns a 401 error asking for a bearer token.
+func unauthorized(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	return NewAPIError(http.StatusUnauthorized, message, nil)
+}
+
+// ParseJWT middleware authenticates requests carrying a bearer token and
+// makes the Principal available in request context as "principal" and its
+// subject as "user". Requests without a token are rejected unless reads are
+// public.
+func ParseJWT(auth *Authenticator, publicReads bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isProbe(c) {
+				return next(c)
+			}
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if header == "" {
+				if !publicReads {
+					return unauthorized(c, "Missing bearer token")
+				}
+				return next(c)
+			}
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || token == "" {
+				return unauthorized(c, "Invalid authorization header, expected a bearer token")
+			}
+			if auth == nil {
+				return unauthorized(c, "Authentication is not configured")
+			}
+			principal, err := auth.Authenticate(token)
+			if errors.Is(err, ErrUnavailable) {
+				logError(c, err)
+				return NewAPIError(http.StatusServiceUnavailable, "Signing keys unavailable", nil)
+			} else if err != nil {
+				return unauthorized(c, fmt.Sprintf("Invalid bearer token: %v", err))
+			}
+			c.Set("principal", principal)
+			c.Set("user", principal.Subject)
+			return next(c)
+		}
+	}
+}
+
+// RequireRole middleware rejects requests not authenticated by ParseJWT as a
+// principal with the role.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := c.Get("principal").(Principal)
+			if !ok {
+				return unauthorized(c, "Missing bearer token")
+			}
+			if !principal.HasRole(role) {
+				return NewAPIError(http.StatusForbidden, fmt.Sprintf("Requires role '%s'", role), nil)
+			}
+			return next(c)
+		}
+	}
+}
+
+// HTTPCaching middleware adds an ETag and Last-Modified header from the graph
+// version and the given Cache-Control header to successful responses, and
+// answers requests for an unchanged graph with 304 Not Modified. As the
+// version changes with every write, the validators need no lookup of their
+// own, but the taxon of the route is looked up, usually from the cache, so
+// that requests for a missing taxon get 404 whatever their validators.
+func HTTPCaching(cacheControl string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			taxonSvc := c.Get("taxonSvc").(*TaxonSvc)
+			version, err := taxonSvc.Version()
+			if err != nil {
+				return err
+			}
+			if id := c.Param("id"); id != "" {
+				if _, err := taxonSvc.Get(c.Param("rank"), id); err != nil {
+					return err
+				}
+			}
+			res := c.Response()
+			etag := graphETag(taxonSvc, version, res.Header().Get(echo.HeaderContentEncoding))
+			setHeaders := func() {
+				res.Header().Set(HeaderETag, etag)
+				if !version.UpdatedAt.IsZero() {
+					res.Header().Set(echo.HeaderLastModified, version.UpdatedAt.UTC().Format(http.TimeFormat))
+				}
+				if cacheControl != "" {
+					res.Header().Set(echo.HeaderCacheControl, cacheControl)
+				}
+			}
+			if notModified(c.Request(), etag, version.UpdatedAt) {
+				setHeaders()
+				return c.NoContent(http.StatusNotModified)
+			}
+			res.Before(func() {
+				if res.Status == http.StatusOK {
+					setHeaders()
+				}
+			})
+			return next(c)
+		}
+	}
+}
+
+// graphETag returns a strong entity tag for the graph version, distinguishing
+// snapshots from the live graph and compressed responses, which differ in
+// bytes, from uncompressed ones.
+func graphETag(taxonSvc *TaxonSvc, version GraphVersion, encoding string) string {
+	prefix := "v"
+	if taxonSvc.readOnly {
+		prefix = "s"
+	}
+	if encoding != "" {
+		return fmt.Sprintf(`"%s%d-%s"`, prefix, version.Version, encoding)
+	}
+	return fmt.Sprintf(`"%s%d"`, prefix, version.Version)
+}
+
+// notModified reports whether the conditional headers of req match the
+// validators of the current response. If-None-Match takes precedence over
+// If-Modified-Since.
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if header := req.Header.Get(HeaderIfNoneMatch); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if header := req.Header.Get(echo.HeaderIfModifiedSince); header != "" && !modified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
@@ -1,17 +1,78 @@
 package main
 
-type TaxonBase struct {
-	Rank string `json:"rank"`
-	Name string `json:"name"`
-	Url  string `json:"url"`
-}
+import (
+	"strings"
+	"time"
+
+	"backend/api"
+)
+
+// The API models are defined in package api so the Go client can share them.
+type (
+	TaxonBase        = api.TaxonBase
+	TaxonResponse    = api.TaxonResponse
+	MRCAPathResponse = api.MRCAPathResponse
+	MRCAResponse     = api.MRCAResponse
+	Rank             = api.Rank
+	CrossRef         = api.CrossRef
+	SnapshotConfig   = api.SnapshotConfig
+	Snapshot         = api.Snapshot
+	GraphConfig      = api.GraphConfig
+	APIError         = api.APIError
+	TaxonVersion     = api.TaxonVersion
+	TaxonChange      = api.TaxonChange
+	SnapshotDiff     = api.SnapshotDiff
+	TaxonInput       = api.TaxonInput
+	TaxonPatch       = api.TaxonPatch
+	AuditTaxon       = api.AuditTaxon
+	AuditEntry       = api.AuditEntry
+)
 
 type Taxon struct {
 	TaxonBase
 	Id string `json:"_id"`
 }
 
-type TaxonResponse struct {
-	TaxonBase
-	Id string `json:"id"`
+// Key returns the document key of the taxon, the part of its ID after the rank.
+func (t Taxon) Key() string {
+	_, key, _ := strings.Cut(t.Id, "/")
+	return key
+}
+
+// GraphVersion identifies the state of a graph. The scraper bumps it after
+// each crawl and curation after each change, so that cached lookups can be
+// dropped.
+type GraphVersion struct {
+	Version   int64     `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// versionTaxon returns the taxon as recorded by a version.
+func versionTaxon(v TaxonVersion) Taxon {
+	return Taxon{
+		TaxonBase: TaxonBase{Rank: v.Rank, Name: v.Name, Url: v.Url, WikidataId: v.WikidataId},
+		Id:        v.TaxonId,
+	}
+}
+
+// APIKey identifies a client scripting against the API. Only a hash of the
+// key's secret is stored.
+type APIKey struct {
+	Id   string `json:"_key"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	// Rate and Burst override the default per-key rate limit if set.
+	Rate      float64    `json:"rate,omitempty"`
+	Burst     int        `json:"burst,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	APIKeyUsage
+}
+
+// APIKeyUsage counts the requests made with an API key.
+type APIKeyUsage struct {
+	Requests int64 `json:"requests"`
+	// Limited counts the requests rejected by the rate limit.
+	Limited    int64      `json:"limited"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
 }
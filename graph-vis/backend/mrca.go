@@ -0,0 +1,60 @@
+package main
+
+// MRCA describes the most recent common ancestor of a set of taxa.
+type MRCA struct {
+	Ancestor Taxon
+	// Paths holds, for each taxon, the taxa from the taxon up to the ancestor.
+	Paths [][]Taxon
+	// Distances holds the rank-based distance between each pair of taxa.
+	Distances [][]int
+}
+
+// commonDepth returns the depth of the deepest taxon shared by all lineages,
+// or -1 if the lineages share no taxa. Lineages must start at the root.
+func commonDepth(lineages ...[]Taxon) int {
+	depth := -1
+	if len(lineages) == 0 {
+		return depth
+	}
+	for i := 0; ; i++ {
+		for _, lineage := range lineages {
+			if i >= len(lineage) || lineage[i].Id != lineages[0][i].Id {
+				return depth
+			}
+		}
+		depth = i
+	}
+}
+
+// FindMRCA computes the most recent common ancestor of the taxa whose
+// lineages are given. The distance between two taxa is the number of rank
+// steps from each taxon up to their common ancestor, summed.
+func FindMRCA(lineages [][]Taxon) (MRCA, error) {
+	mrca := MRCA{}
+	if len(lineages) < 2 {
+		return mrca, newSvcError(ErrInvalid, "At least two taxa are required")
+	}
+	depth := commonDepth(lineages...)
+	if depth < 0 {
+		return mrca, newSvcError(ErrNotFound, "Taxa share no common ancestor")
+	}
+	mrca.Ancestor = lineages[0][depth]
+
+	for _, lineage := range lineages {
+		path := []Taxon{}
+		for i := len(lineage) - 1; i >= depth; i-- {
+			path = append(path, lineage[i])
+		}
+		mrca.Paths = append(mrca.Paths, path)
+	}
+
+	mrca.Distances = make([][]int, len(lineages))
+	for i := range lineages {
+		mrca.Distances[i] = make([]int, len(lineages))
+		for j := range lineages {
+			d := commonDepth(lineages[i], lineages[j])
+			mrca.Distances[i][j] = (len(lineages[i]) - 1 - d) + (len(lineages[j]) - 1 - d)
+		}
+	}
+	return mrca, nil
+}
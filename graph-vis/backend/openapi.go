@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	http "net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/api"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	echo "github.com/labstack/echo/v4"
+)
+
+// apiRoute describes a route for the OpenAPI document. Paths use Echo's
+// `:param` syntax and are relative to the API prefix or, for graph routes, to
+// the graph.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Id      string
+	Summary string
+	Tag     string
+	Params  []*openapi3.Parameter
+	Body    interface{}
+	// BodyRequired lists the required fields of the body, which unlike
+	// those of responses cannot be told by omitempty.
+	BodyRequired []string
+	Status       int
+	Data         interface{}
+	Content      openapi3.Content
+	Write        bool
+	Conditional  bool
+}
+
+// graphRoutes describes the routes added by addGraphRoutes. Models are given
+// by a zero value, with a slice for arrays.
+var graphRoutes = []apiRoute{
+	{Method: http.MethodGet, Path: "/taxon/by-wikidata/:qid", Id: "getTaxonByWikidataId", Summary: "Get the taxon with a Wikidata QID", Tag: "taxa",
+		Params: []*openapi3.Parameter{pathParam("qid", "Wikidata QID, e.g. Q146.").WithSchema(openapi3.NewStringSchema().WithPattern(wikidataQIDRegexp.String()))},
+		Data:   TaxonResponse{}},
+	{Method: http.MethodGet, Path: "/taxon/by-url", Id: "getTaxonByUrl", Summary: "Get the taxon described by a Wikipedia page", Tag: "taxa",
+		Params: []*openapi3.Parameter{queryParam("url", "Wikipedia page URL or title.", openapi3.NewStringSchema()).WithRequired(true)},
+		Data:   TaxonResponse{}},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id/children", Id: "getTaxonChildren", Summary: "List the children of a taxon", Tag: "taxa",
+		Params: append(taxonParams(), offsetQueryParam(api.MaxChildrenOffset), queryParam("limit", "Maximum number of results, all by default.", openapi3.NewIntegerSchema().WithMin(1).WithMax(api.MaxChildrenLimit))),
+		Data:   []TaxonResponse{}, Conditional: true},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id/lineage", Id: "getTaxonLineage", Summary: "List the taxa from the root down to a taxon", Tag: "taxa",
+		Params: taxonParams(), Data: []TaxonResponse{}},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id/export", Id: "exportTaxonSubtree", Summary: "Export the subtree below a taxon", Tag: "taxa",
+		Params:  append(taxonParams(), queryParam("format", "Export format.", openapi3.NewStringSchema().WithEnum(exportFormatNames()...)).WithRequired(true)),
+		Content: exportContent()},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id/crossrefs", Id: "getTaxonCrossRefs", Summary: "List the checklist matches of a taxon", Tag: "crossrefs",
+		Params: taxonParams(), Data: []CrossRef{}},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id/audit", Id: "getTaxonAudit", Summary: "List the curation changes to a taxon", Tag: "curation",
+		Params: taxonParams(), Data: []AuditEntry{}, Write: true},
+	{Method: http.MethodGet, Path: "/taxon/:rank/:id", Id: "getTaxon", Summary: "Get a taxon", Tag: "taxa",
+		Params: taxonParams(), Data: TaxonResponse{}, Conditional: true},
+	{Method: http.MethodPost, Path: "/taxon", Id: "createTaxon", Summary: "Create a curated taxon", Tag: "curation",
+		Body: TaxonInput{}, BodyRequired: []string{"rank", "name"}, Status: http.StatusCreated, Data: TaxonResponse{}, Write: true},
+	{Method: http.MethodPatch, Path: "/taxon/:rank/:id", Id: "updateTaxon", Summary: "Correct a taxon", Tag: "curation",
+		Params: taxonParams(), Body: TaxonPatch{}, Data: TaxonResponse{}, Write: true},
+	{Method: http.MethodDelete, Path: "/taxon/:rank/:id", Id: "deleteTaxon", Summary: "Delete a taxon or merge it into another", Tag: "curation",
+		Params: append(taxonParams(), queryParam("mergeInto", "Taxon of the same rank, as `<rank>/<id>`, taking over the children.", openapi3.NewStringSchema())),
+		Status: http.StatusNoContent, Write: true},
+	{Method: http.MethodGet, Path: "/mrca", Id: "getMRCA", Summary: "Get the most recent common ancestor of taxa", Tag: "taxa",
+		Params: []*openapi3.Parameter{
+			queryParam("a", "First taxon of a pair, as `<rank>/<id>`.", openapi3.NewStringSchema()),
+			queryParam("b", "Second taxon of a pair, as `<rank>/<id>`.", openapi3.NewStringSchema()),
+			queryParam("taxon", "Taxa as `<rank>/<id>`, instead of a pair.", openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema())),
+		},
+		Data: MRCAResponse{}},
+	{Method: http.MethodGet, Path: "/ranks", Id: "listRanks", Summary: "List the ranks with their taxon counts", Tag: "taxa",
+		Data: []Rank{}},
+	{Method: http.MethodGet, Path: "/search", Id: "searchTaxa", Summary: "Search taxa by name", Tag: "taxa",
+		Params: []*openapi3.Parameter{
+			queryParam("q", "Name or part of a name.", openapi3.NewStringSchema().WithMinLength(1)).WithRequired(true),
+			queryParam("rank", "Rank to search.", openapi3.NewStringSchema()),
+			offsetQueryParam(api.MaxSearchOffset),
+			limitQueryParam(api.MaxSearchLimit),
+		},
+		Data: []TaxonResponse{}},
+	{Method: http.MethodGet, Path: "/crossrefs", Id: "listCrossRefs", Summary: "List checklist matches", Tag: "crossrefs",
+		Params: []*openapi3.Parameter{
+			queryParam("source", "Checklist, e.g. gbif.", openapi3.NewStringSchema()),
+			queryParam("status", "Match status, e.g. conflicting.", openapi3.NewStringSchema()),
+			offsetQueryParam(api.MaxCrossRefsOffset),
+			limitQueryParam(api.MaxCrossRefsLimit),
+		},
+		Data: []CrossRef{}},
+	{Method: http.MethodGet, Path: "/snapshots", Id: "listSnapshots", Summary: "List the crawl snapshots", Tag: "snapshots",
+		Data: []Snapshot{}},
+	{Method: http.MethodGet, Path: "/snapshots/diff", Id: "diffSnapshots", Summary: "List the changes between two snapshots", Tag: "snapshots",
+		Params: []*openapi3.Parameter{
+			queryParam("from", "Snapshot ID, by default the one before `to`.", openapi3.NewStringSchema()),
+			queryParam("to", "Snapshot ID, by default the latest.", openapi3.NewStringSchema()),
+		},
+		Data: SnapshotDiff{}},
+	{Method: http.MethodGet, Path: "/graphql", Id: "queryGraphQL", Summary: "Query the graph with GraphQL", Tag: "graphql",
+		Params: []*openapi3.Parameter{
+			queryParam("query", "GraphQL query.", openapi3.NewStringSchema()).WithRequired(true),
+			queryParam("operationName", "Operation to run if the query has several.", openapi3.NewStringSchema()),
+			queryParam("variables", "Variables encoded as a JSON object.", openapi3.NewStringSchema()),
+		},
+		Content: graphQLContent()},
+	{Method: http.MethodPost, Path: "/graphql", Id: "postGraphQL", Summary: "Query the graph with GraphQL in the request body", Tag: "graphql",
+		Body: GraphQLRequest{}, BodyRequired: []string{"query"}, Content: graphQLContent()},
+}
+
+// apiRoutes describes the routes not specific to a graph.
+var apiRoutes = []apiRoute{
+	{Method: http.MethodGet, Path: "/graphs", Id: "listGraphs", Summary: "List the served graphs", Tag: "graphs",
+		Data: []GraphConfig{}},
+	{Method: http.MethodGet, Path: "/openapi.json", Id: "getOpenAPI", Summary: "Get this document", Tag: "docs",
+		Content: openapi3.NewContentWithJSONSchema(openapi3.NewObjectSchema())},
+	{Method: http.MethodGet, Path: "/docs", Id: "getDocs", Summary: "Browse this document with Swagger UI", Tag: "docs",
+		Content: openapi3.Content{echo.MIMETextHTMLCharsetUTF8: openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())}},
+}
+
+// openAPIModels names the component schemas of the models. Other models are
+// described inline.
+var openAPIModels = map[reflect.Type]string{
+	reflect.TypeOf(TaxonResponse{}):    "Taxon",
+	reflect.TypeOf(Rank{}):             "Rank",
+	reflect.TypeOf(GraphConfig{}):      "Graph",
+	reflect.TypeOf(CrossRef{}):         "CrossRef",
+	reflect.TypeOf(Snapshot{}):         "Snapshot",
+	reflect.TypeOf(SnapshotConfig{}):   "SnapshotConfig",
+	reflect.TypeOf(SnapshotDiff{}):     "SnapshotDiff",
+	reflect.TypeOf(TaxonVersion{}):     "TaxonVersion",
+	reflect.TypeOf(TaxonChange{}):      "TaxonChange",
+	reflect.TypeOf(MRCAResponse{}):     "MRCA",
+	reflect.TypeOf(MRCAPathResponse{}): "MRCAPath",
+	reflect.TypeOf(AuditEntry{}):       "AuditEntry",
+	reflect.TypeOf(AuditTaxon{}):       "AuditTaxon",
+	reflect.TypeOf(TaxonInput{}):       "TaxonInput",
+	reflect.TypeOf(TaxonPatch{}):       "TaxonPatch",
+	reflect.TypeOf(APIError{}):         "Error",
+}
+
+func pathParam(name string, description string) *openapi3.Parameter {
+	return openapi3.NewPathParameter(name).WithDescription(description).WithSchema(openapi3.NewStringSchema())
+}
+
+func queryParam(name string, description string, schema *openapi3.Schema) *openapi3.Parameter {
+	return openapi3.NewQueryParameter(name).WithDescription(description).WithSchema(schema)
+}
+
+func taxonParams() []*openapi3.Parameter {
+	return []*openapi3.Parameter{
+		pathParam("rank", "Rank of the taxon, e.g. genus."),
+		pathParam("id", "Key, scientific name or legacy key of the taxon."),
+	}
+}
+
+func limitQueryParam(max float64) *openapi3.Parameter {
+	return queryParam("limit", "Maximum number of results.", openapi3.NewIntegerSchema().WithMin(1).WithMax(max))
+}
+
+func offsetQueryParam(max float64) *openapi3.Parameter {
+	return queryParam("offset", "Number of results to skip.", openapi3.NewIntegerSchema().WithMin(0).WithMax(max))
+}
+
+func exportFormatNames() []interface{} {
+	names := []string{}
+	for name := range ExportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := []interface{}{}
+	for _, name := range names {
+		values = append(values, name)
+	}
+	return values
+}
+
+// graphQLContent describes a GraphQL result, which holds data or errors as
+// described by the GraphQL specification rather than the API envelope.
+func graphQLContent() openapi3.Content {
+	return openapi3.NewContentWithJSONSchema(openapi3.NewObjectSchema())
+}
+
+func exportContent() openapi3.Content {
+	content := openapi3.Content{}
+	for _, format := range ExportFormats {
+		content[format.ContentType] = openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())
+	}
+	return content
+}
+
+// schemaGenerator describes Go types as JSON schemas following the rules of
+// encoding/json. Fields without omitempty are required, and nullable if their
+// zero value encodes as null.
+type schemaGenerator struct {
+	schemas openapi3.Schemas
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ref returns the schema of t, referring to the component schema of models.
+func (g schemaGenerator) ref(t reflect.Type) *openapi3.SchemaRef {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name, ok := openAPIModels[t]
+	if !ok {
+		return openapi3.NewSchemaRef("", g.schema(t))
+	}
+	if g.schemas[name] == nil {
+		g.schemas[name] = openapi3.NewSchemaRef("", g.schema(t))
+	}
+	return openapi3.NewSchemaRef("#/components/schemas/"+name, g.schemas[name].Value)
+}
+
+func (g schemaGenerator) schema(t reflect.Type) *openapi3.Schema {
+	if t == timeType {
+		return openapi3.NewDateTimeSchema()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return openapi3.NewBoolSchema()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openapi3.NewIntegerSchema()
+	case reflect.Float32, reflect.Float64:
+		return openapi3.NewFloat64Schema()
+	case reflect.String:
+		return openapi3.NewStringSchema()
+	case reflect.Slice, reflect.Array:
+		schema := openapi3.NewArraySchema()
+		schema.Items = g.ref(t.Elem())
+		return schema
+	case reflect.Map:
+		schema := openapi3.NewObjectSchema()
+		schema.AdditionalProperties = g.ref(t.Elem())
+		return schema
+	case reflect.Struct:
+		schema := openapi3.NewObjectSchema()
+		g.addFields(schema, t)
+		return schema
+	}
+	// Any value, e.g. of an interface.
+	return &openapi3.Schema{}
+}
+
+func (g schemaGenerator) addFields(schema *openapi3.Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			g.addFields(schema, field.Type)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		prop := g.ref(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+			switch field.Type.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map:
+				if prop.Ref != "" {
+					prop = openapi3.NewSchemaRef("", &openapi3.Schema{Nullable: true, AllOf: openapi3.SchemaRefs{prop}})
+				} else {
+					prop.Value.Nullable = true
+				}
+			}
+		}
+		schema.WithPropertyRef(name, prop)
+	}
+}
+
+// dataContent returns a JSON response envelope holding data.
+func (g schemaGenerator) dataContent(data interface{}) openapi3.Content {
+	schema := openapi3.NewObjectSchema().WithPropertyRef("data", g.ref(reflect.TypeOf(data)))
+	schema.Required = []string{"data"}
+	return openapi3.NewContentWithJSONSchema(schema)
+}
+
+// echoPathParamRegexp matches the parameters of Echo route paths.
+var echoPathParamRegexp = regexp.MustCompile(`:([a-zA-Z0-9_]+)`)
+
+// openAPIPath converts an Echo route path to an OpenAPI path.
+func openAPIPath(path string) string {
+	return echoPathParamRegexp.ReplaceAllString(path, "{$1}")
+}
+
+// NewOpenAPI returns the OpenAPI document of the API served for cfg. Graph
+// routes are described for the default graph and, as operations with the
+// suffix `InGraph`, for graphs by name.
+func NewOpenAPI(cfg Config) *openapi3.T {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "Animal Kingdom Graph API",
+			Version:     "1",
+			Description: fmt.Sprintf("Taxa scraped from Wikipedia, ranked as %s. Clients may send an `X-API-Key` header to be rate limited by key rather than by IP.", strings.Join(cfg.TaxonRanks, ", ")),
+		},
+		Paths:      openapi3.Paths{},
+		Components: openapi3.NewComponents(),
+	}
+	doc.Components.Schemas = openapi3.Schemas{}
+	doc.Components.SecuritySchemes = openapi3.SecuritySchemes{
+		"bearerAuth": &openapi3.SecuritySchemeRef{Value: openapi3.NewJWTSecurityScheme()},
+	}
+	bearer := *openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement().Authenticate("bearerAuth"))
+	doc.Security = bearer
+	if cfg.AuthPublicReads {
+		doc.Security = *openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement()).With(openapi3.NewSecurityRequirement().Authenticate("bearerAuth"))
+	}
+
+	g := schemaGenerator{schemas: doc.Components.Schemas}
+	errorSchema := openapi3.NewObjectSchema().WithPropertyRef("error", g.ref(reflect.TypeOf(APIError{})))
+	errorSchema.Required = []string{"error"}
+	doc.Components.Responses = openapi3.Responses{
+		"Error": &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("Error").WithJSONSchema(errorSchema)},
+	}
+	errorResponse := &openapi3.ResponseRef{Ref: "#/components/responses/Error", Value: doc.Components.Responses["Error"].Value}
+
+	asOf := queryParam("asOf", "Snapshot ID, timestamp or date to answer as of.", openapi3.NewStringSchema())
+	graph := pathParam("graph", "Name of the graph.")
+	add := func(prefix string, suffix string, route apiRoute, params ...*openapi3.Parameter) {
+		op := openapi3.NewOperation()
+		op.OperationID = route.Id + suffix
+		op.Summary = route.Summary
+		op.Tags = []string{route.Tag}
+		for _, param := range append(params, route.Params...) {
+			op.Parameters = append(op.Parameters, &openapi3.ParameterRef{Value: param})
+		}
+		if route.Body != nil {
+			schema := g.ref(reflect.TypeOf(route.Body))
+			schema.Value.Required = route.BodyRequired
+			body := openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(schema)
+			op.RequestBody = &openapi3.RequestBodyRef{Value: body}
+		}
+		if route.Write {
+			op.Security = &bearer
+		}
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := openapi3.NewResponse().WithDescription(http.StatusText(status))
+		switch {
+		case route.Data != nil:
+			resp.WithContent(g.dataContent(route.Data))
+		case route.Content != nil:
+			resp.WithContent(route.Content)
+		}
+		op.Responses = openapi3.Responses{
+			strconv.Itoa(status): &openapi3.ResponseRef{Value: resp},
+			"default":            errorResponse,
+		}
+		if route.Conditional {
+			op.Responses[strconv.Itoa(http.StatusNotModified)] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("Unchanged since the given ETag or date")}
+		}
+		doc.AddOperation(openAPIPath(prefix+route.Path), route.Method, op)
+	}
+	for _, route := range apiRoutes {
+		add("/api/v1", "", route)
+	}
+	for _, route := range graphRoutes {
+		add("/api/v1", "", route, asOf)
+		add("/api/v1/graphs/:graph", "InGraph", route, graph, asOf)
+	}
+	return doc
+}
+
+// OpenAPIGet serves the OpenAPI document.
+func OpenAPIGet(doc *openapi3.T) echo.HandlerFunc {
+	data, err := json.Marshal(doc)
+	return func(c echo.Context) error {
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, data)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN to browse the OpenAPI document.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Animal Kingdom Graph API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// SwaggerUIGet serves a page browsing the OpenAPI document.
+func SwaggerUIGet(c echo.Context) error {
+	return c.HTML(http.StatusOK, swaggerUIPage)
+}
+
+// ValidateRequest middleware rejects requests whose parameters or body do
+// not match the operation of the route in the OpenAPI document. Routes not in
+// the document are not checked. Authentication is left to ParseJWT.
+func ValidateRequest(doc *openapi3.T) echo.MiddlewareFunc {
+	options := &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := openAPIPath(c.Path())
+			pathItem := doc.Paths[path]
+			if pathItem == nil {
+				return next(c)
+			}
+			req := c.Request()
+			op := pathItem.GetOperation(req.Method)
+			if op == nil {
+				return next(c)
+			}
+			params := map[string]string{}
+			for i, name := range c.ParamNames() {
+				params[name] = c.ParamValues()[i]
+			}
+			err := openapi3filter.ValidateRequest(req.Context(), &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: params,
+				Route:      &routers.Route{Spec: doc, Path: path, PathItem: pathItem, Method: req.Method, Operation: op},
+				Options:    options,
+			})
+			if err != nil {
+				return requestValidationError(err)
+			}
+			return next(c)
+		}
+	}
+}
+
+// requestValidationError returns a 400 error naming the invalid parameter or
+// body field.
+func requestValidationError(err error) error {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return badRequest(fmt.Sprintf("Invalid request: %v", err))
+	}
+	reason := reqErr.Reason
+	var field string
+	var schemaErr *openapi3.SchemaError
+	if errors.As(reqErr.Err, &schemaErr) {
+		reason = schemaErr.Reason
+		field = strings.Join(schemaErr.JSONPointer(), ".")
+	} else if reason == "" && reqErr.Err != nil {
+		reason = reqErr.Err.Error()
+	}
+	switch {
+	case reqErr.Parameter != nil:
+		return badRequest(fmt.Sprintf("Invalid %s parameter '%s': %s", reqErr.Parameter.In, reqErr.Parameter.Name, reason))
+	case field != "":
+		return badRequest(fmt.Sprintf("Invalid request body field '%s': %s", field, reason))
+	default:
+		return badRequest(fmt.Sprintf("Invalid request body: %s", reason))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	http "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	echo "github.com/labstack/echo/v4"
+)
+
+func TestOpenAPI(t *testing.T) {
+	router := newTestRouter(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the document, got %d", rec.Code)
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to load document: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("Invalid document: %v", err)
+	}
+
+	// Every API route is described.
+	for _, route := range router.(*echo.Echo).Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1") {
+			continue
+		}
+		if item := doc.Paths[openAPIPath(route.Path)]; item == nil || item.GetOperation(route.Method) == nil {
+			t.Errorf("Route %s %s is not described", route.Method, route.Path)
+		}
+	}
+
+	// Responses match the document.
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"/api/v1/graphs", "/api/v1/graphs"},
+		{"/api/v1/taxon/genus/7", "/api/v1/taxon/{rank}/{id}"},
+		{"/api/v1/graphs/snapshot/taxon/genus/7/children", "/api/v1/graphs/{graph}/taxon/{rank}/{id}/children"},
+		{"/api/v1/taxon/species/9/lineage", "/api/v1/taxon/{rank}/{id}/lineage"},
+		{"/api/v1/taxon/species/9/crossrefs", "/api/v1/taxon/{rank}/{id}/crossrefs"},
+		{"/api/v1/taxon/genus/missing", "/api/v1/taxon/{rank}/{id}"},
+		{"/api/v1/mrca?a=species/9&b=species/10", "/api/v1/mrca"},
+		{"/api/v1/ranks", "/api/v1/ranks"},
+		{"/api/v1/search?q=felis", "/api/v1/search"},
+		{"/api/v1/snapshots", "/api/v1/snapshots"},
+		{"/api/v1/snapshots/diff", "/api/v1/snapshots/diff"},
+	}
+	options := &openapi3filter.Options{IncludeResponseStatus: true, AuthenticationFunc: openapi3filter.NoopAuthenticationFunc}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		item := doc.Paths[tt.path]
+		input := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request: req,
+				Route:   &routers.Route{Spec: doc, Path: tt.path, PathItem: item, Method: http.MethodGet, Operation: item.Get},
+				Options: options,
+			},
+			Status:  rec.Code,
+			Header:  rec.Header(),
+			Body:    io.NopCloser(rec.Body),
+			Options: options,
+		}
+		if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+			t.Errorf("GET %s: response does not match the document: %v", tt.target, err)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "openapi.json") {
+		t.Errorf("Expected the Swagger UI page, got %d", rec.Code)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	router := newTestRouter(t)
+	var errResp struct {
+		Error APIError `json:"error"`
+	}
+	for _, tt := range []struct {
+		target  string
+		message string
+	}{
+		{"/api/v1/search?q=felis&limit=500", "Invalid query parameter 'limit'"},
+		{"/api/v1/search?limit=5", "Invalid query parameter 'q'"},
+		{"/api/v1/taxon/by-wikidata/146", "Invalid path parameter 'qid'"},
+		{"/api/v1/graphs/snapshot/taxon/genus/7/export?format=svg", "Invalid query parameter 'format'"},
+	} {
+		if code := get(t, router, tt.target, &errResp); code != http.StatusBadRequest || !strings.HasPrefix(errResp.Error.Message, tt.message) {
+			t.Errorf("GET %s: expected %q, got %d %+v", tt.target, tt.message, code, errResp)
+		}
+	}
+	for _, tt := range []struct {
+		body    string
+		message string
+	}{
+		{`{"rank": "species", "name": 5, "parent": "genus/7"}`, "Invalid request body field 'name'"},
+		{`{"rank": "species"}`, "Invalid request body"},
+		{`not json`, "Invalid request body"},
+	} {
+		if code := send(t, router, http.MethodPost, "/api/v1/taxon", tt.body, &errResp); code != http.StatusBadRequest || !strings.HasPrefix(errResp.Error.Message, tt.message) {
+			t.Errorf("POST %s: expected %q, got %d %+v", tt.body, tt.message, code, errResp)
+		}
+	}
+}
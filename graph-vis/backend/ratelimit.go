@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	http "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// HeaderAPIKey carries the API key of a request.
+	HeaderAPIKey = "X-API-Key"
+	// apiKeyCacheTTL bounds how long a revoked key stays usable.
+	apiKeyCacheTTL = time.Minute
+	// bucketIdleTimeout is how long a token bucket is kept without requests.
+	bucketIdleTimeout = 10 * time.Minute
+)
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+type cachedAPIKey struct {
+	key       APIKey
+	fetchedAt time.Time
+}
+
+// RateLimiter limits the request rate of each API key and, for requests
+// without a key, of each client IP with token buckets. It counts the
+// requests made with each key until the counts are flushed to the repo.
+type RateLimiter struct {
+	apiKeys  APIKeyRepo
+	ipRate   float64
+	ipBurst  int
+	keyRate  float64
+	keyBurst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	keys    map[[sha256.Size]byte]cachedAPIKey
+	usage   map[string]APIKeyUsage
+}
+
+func NewRateLimiter(cfg Config, apiKeys APIKeyRepo) *RateLimiter {
+	return &RateLimiter{
+		apiKeys:  apiKeys,
+		ipRate:   cfg.RateLimitIP,
+		ipBurst:  cfg.RateLimitIPBurst,
+		keyRate:  cfg.RateLimitKey,
+		keyBurst: cfg.RateLimitKeyBurst,
+		buckets:  map[string]*bucket{},
+		keys:     map[[sha256.Size]byte]cachedAPIKey{},
+		usage:    map[string]APIKeyUsage{},
+	}
+}
+
+// apiKey verifies an API key token. Valid keys are cached so that requests
+// do not each query the database. Invalid ones are not, so that random keys
+// cannot fill the cache; failed attempts are rate limited by IP instead.
+func (rl *RateLimiter) apiKey(token string, now time.Time) (APIKey, error) {
+	hash := sha256.Sum256([]byte(token))
+	rl.mu.Lock()
+	cached, ok := rl.keys[hash]
+	rl.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < apiKeyCacheTTL {
+		return cached.key, nil
+	}
+	key, err := verifyAPIKey(rl.apiKeys, token)
+	if err != nil {
+		return key, err
+	}
+	rl.mu.Lock()
+	rl.keys[hash] = cachedAPIKey{key: key, fetchedAt: now}
+	rl.mu.Unlock()
+	return key, nil
+}
+
+// take takes a token from the bucket with the given ID, returning whether
+// one was available, the whole tokens left and the time until the bucket is
+// full again, or until the next token if none was available.
+func (rl *RateLimiter) take(id string, r float64, burst int, now time.Time) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[id]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(r), burst)}
+		rl.buckets[id] = b
+	} else if b.limiter.Limit() != rate.Limit(r) || b.limiter.Burst() != burst {
+		// The key's limit was changed.
+		b.limiter.SetLimitAt(now, rate.Limit(r))
+		b.limiter.SetBurstAt(now, burst)
+	}
+	b.seen = now
+	allowed := b.limiter.AllowN(now, 1)
+	tokens := b.limiter.TokensAt(now)
+	wait := (float64(burst) - tokens) / r
+	if !allowed {
+		wait = (1 - tokens) / r
+	}
+	return allowed, int(math.Max(0, math.Floor(tokens))), time.Duration(wait * float64(time.Second))
+}
+
+func (rl *RateLimiter) count(keyId string, limited bool, now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	usage := rl.usage[keyId]
+	usage.Requests++
+	if limited {
+		usage.Limited++
+	}
+	usage.LastUsedAt = &now
+	rl.usage[keyId] = usage
+}
+
+// FlushUsage adds the requests counted since the last flush to the API key
+// usage counters in the repo. Idle buckets and expired cached keys are
+// dropped.
+func (rl *RateLimiter) FlushUsage() error {
+	now := time.Now()
+	rl.mu.Lock()
+	usage := rl.usage
+	rl.usage = map[string]APIKeyUsage{}
+	for id, b := range rl.buckets {
+		if now.Sub(b.seen) > bucketIdleTimeout {
+			delete(rl.buckets, id)
+		}
+	}
+	for hash, cached := range rl.keys {
+		if now.Sub(cached.fetchedAt) > apiKeyCacheTTL {
+			delete(rl.keys, hash)
+		}
+	}
+	rl.mu.Unlock()
+	if len(usage) == 0 {
+		return nil
+	}
+	if err := rl.apiKeys.AddAPIKeyUsage(usage); err != nil {
+		// Keep the counts for the next flush.
+		rl.mu.Lock()
+		for id, u := range usage {
+			merged := rl.usage[id]
+			merged.Requests += u.Requests
+			merged.Limited += u.Limited
+			if merged.LastUsedAt == nil {
+				merged.LastUsedAt = u.LastUsedAt
+			}
+			rl.usage[id] = merged
+		}
+		rl.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Middleware rejects requests over their client's rate limit with status
+// 429. Requests with an `X-API-Key` header are limited by key, others by IP,
+// as are requests with an invalid key before they are rejected with 401.
+// The state of the client's bucket is reported in `RateLimit-*` headers.
+// The verified key is made available in request context as "apiKey".
+func (rl *RateLimiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isProbe(c) {
+				return next(c)
+			}
+			now := time.Now()
+			id := "ip:" + c.RealIP()
+			r, burst := rl.ipRate, rl.ipBurst
+			keyId := ""
+			if token := c.Request().Header.Get(HeaderAPIKey); token != "" {
+				key, err := rl.apiKey(token, now)
+				var svcErr *SvcError
+				if errors.Is(err, ErrInvalid) && errors.As(err, &svcErr) {
+					if err := rl.limit(c, id, r, burst, now); err != nil {
+						return err
+					}
+					return NewAPIError(http.StatusUnauthorized, svcErr.Message, nil)
+				} else if err != nil {
+					return err
+				}
+				c.Set("apiKey", key)
+				keyId = key.Id
+				id = "key:" + key.Id
+				r, burst = rl.keyRate, rl.keyBurst
+				if key.Rate > 0 {
+					r = key.Rate
+				}
+				if key.Burst > 0 {
+					burst = key.Burst
+				}
+			}
+			err := rl.limit(c, id, r, burst, now)
+			if keyId != "" {
+				rl.count(keyId, err != nil, now)
+			}
+			if err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// limit takes a token from the bucket with the given ID, reporting its state
+// in headers, and returns a 429 error if none was available.
+func (rl *RateLimiter) limit(c echo.Context, id string, r float64, burst int, now time.Time) error {
+	if r <= 0 {
+		// Limit disabled.
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	allowed, remaining, wait := rl.take(id, r, burst, now)
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	header := c.Response().Header()
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", burst, int(math.Ceil(float64(burst)/r))))
+	header.Set("RateLimit-Limit", strconv.Itoa(burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("RateLimit-Reset", seconds)
+	if !allowed {
+		header.Set(echo.HeaderRetryAfter, seconds)
+		return NewAPIError(http.StatusTooManyRequests, "Rate limit exceeded", nil)
+	}
+	return nil
+}
+
+// ipExtractor returns the client IP of a request used for rate limiting and
+// logs. By default it is the peer address. With trusted proxies, given as
+// CIDRs, it is the last address in `X-Forwarded-For` not of a trusted proxy,
+// provided the peer is one.
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	opts := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range trustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			opts = append(opts, echo.TrustIPRange(ipNet))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(opts...)
+}
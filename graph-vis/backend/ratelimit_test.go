@@ -0,0 +1,138 @@
+package main
+
+import (
+	http "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// newRateLimitedServer serves an empty response behind a rate limiter.
+func newRateLimitedServer(limiter *RateLimiter, trustedProxies ...string) http.Handler {
+	router := echo.New()
+	router.HTTPErrorHandler = HTTPErrorHandler
+	router.IPExtractor = ipExtractor(trustedProxies)
+	router.Use(limiter.Middleware())
+	router.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	return router
+}
+
+func TestRateLimitIP(t *testing.T) {
+	cfg := Config{RateLimitIP: 1, RateLimitIPBurst: 2}
+	router := newRateLimitedServer(NewRateLimiter(cfg, NewMemoryAPIKeyRepo()))
+	request := func(ip string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	for i, remaining := range []string{"1", "0"} {
+		rec := request("192.0.2.1")
+		if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Remaining") != remaining || rec.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("Request %d: unexpected status %d and headers %v", i, rec.Code, rec.Header())
+		}
+	}
+	rec := request("192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(echo.HeaderRetryAfter) != "1" {
+		t.Errorf("Expected status 429 with Retry-After, got %d and headers %v", rec.Code, rec.Header())
+	}
+	if rec := request("192.0.2.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected other client to be allowed, got status %d", rec.Code)
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	cfg := Config{RateLimitIP: 1, RateLimitIPBurst: 1}
+	request := func(router http.Handler, peer string, forwardedFor string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = peer + ":1234"
+		req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without trusted proxies the header is ignored, so clients cannot pick
+	// a fresh bucket for each request.
+	router := newRateLimitedServer(NewRateLimiter(cfg, NewMemoryAPIKeyRepo()))
+	codes := []int{request(router, "192.0.2.1", "198.51.100.1"), request(router, "192.0.2.1", "198.51.100.2")}
+	if !reflect.DeepEqual(codes, []int{204, 429}) {
+		t.Errorf("Expected X-Forwarded-For to be ignored, got statuses %v", codes)
+	}
+
+	// Behind a trusted proxy each forwarded client has its own bucket, but
+	// clients cannot spoof the addresses before the proxy's.
+	router = newRateLimitedServer(NewRateLimiter(cfg, NewMemoryAPIKeyRepo()), "10.0.0.0/8")
+	codes = []int{
+		request(router, "10.0.0.1", "198.51.100.1"),
+		request(router, "10.0.0.1", "198.51.100.2"),
+		request(router, "10.0.0.1", "203.0.113.9, 198.51.100.1"),
+		request(router, "192.0.2.1", "198.51.100.3"),
+		request(router, "192.0.2.1", "198.51.100.4"),
+	}
+	if !reflect.DeepEqual(codes, []int{204, 204, 429, 204, 429}) {
+		t.Errorf("Unexpected statuses %v", codes)
+	}
+}
+
+func TestRateLimitAPIKey(t *testing.T) {
+	repo := NewMemoryAPIKeyRepo()
+	key, token, err := NewAPIKey("partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Burst = 3
+	repo.CreateAPIKey(key)
+	revoked, revokedToken, _ := NewAPIKey("former partner")
+	repo.CreateAPIKey(revoked)
+	repo.RevokeAPIKey(revoked.Id)
+
+	cfg := Config{RateLimitIP: 1, RateLimitIPBurst: 3, RateLimitKey: 1, RateLimitKeyBurst: 1}
+	limiter := NewRateLimiter(cfg, repo)
+	router := newRateLimitedServer(limiter)
+	request := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderAPIKey, token)
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, bad := range []string{"ak_nope", "ak_" + key.Id + "_wrong", revokedToken} {
+		if rec := request(bad); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected API key %q to be rejected, got status %d", bad, rec.Code)
+		}
+	}
+	// Failed attempts are limited by IP, and unknown keys are not cached.
+	if rec := request("ak_" + key.Id + "_guess"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected guessing keys to be rate limited, got status %d", rec.Code)
+	}
+	if len(limiter.keys) != 0 {
+		t.Errorf("Expected invalid keys not to be cached, got %d", len(limiter.keys))
+	}
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		rec := request(token)
+		codes = append(codes, rec.Code)
+		if rec.Header().Get("RateLimit-Limit") != "3" {
+			t.Errorf("Expected the key's burst as limit, got headers %v", rec.Header())
+		}
+	}
+	if !reflect.DeepEqual(codes, []int{204, 204, 204, 429}) {
+		t.Errorf("Unexpected statuses %v", codes)
+	}
+
+	if err := limiter.FlushUsage(); err != nil {
+		t.Fatalf("Failed to flush usage: %v", err)
+	}
+	stored, _ := repo.GetAPIKey(key.Id)
+	if stored.Requests != 4 || stored.Limited != 1 || stored.LastUsedAt == nil {
+		t.Errorf("Unexpected usage %+v", stored.APIKeyUsage)
+	}
+	if stored.Hash == "" || strings.Contains(token, stored.Hash) {
+		t.Errorf("Expected only a hash of the secret to be stored")
+	}
+}
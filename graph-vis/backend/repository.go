@@ -0,0 +1,105 @@
+package main
+
+import "context"
+
+// TaxonRepo provides access to the taxa of a single graph. Ranks passed to a
+// TaxonRepo have already been validated by TaxonSvc.
+type TaxonRepo interface {
+	// Ready returns an error unless the database is reachable and holds the
+	// graph.
+	Ready(ctx context.Context) error
+	// Get returns a single taxon by ID.
+	Get(rank string, id string) (Taxon, error)
+	// GetChildren returns the taxa one rank below the given taxon.
+	GetChildren(rank string, id string) ([]Taxon, error)
+	// GetLineage returns the taxa on the path from a taxon of the root rank
+	// down to the given taxon.
+	GetLineage(rank string, id string, root string) ([]Taxon, error)
+	// FindByWikidataId returns the taxa of the given rank with the Wikidata QID.
+	FindByWikidataId(rank string, qid string) ([]Taxon, error)
+	// FindByLegacyKey returns the taxa of the given rank which had the key
+	// before they were moved to stable keys.
+	FindByLegacyKey(rank string, key string) ([]Taxon, error)
+	// FindByUrls returns the taxa of the given rank whose page URL is one of urls.
+	FindByUrls(rank string, urls []string) ([]Taxon, error)
+	// Search returns up to limit taxa of the given rank whose name contains
+	// the query, ignoring case.
+	Search(rank string, query string, limit int) ([]Taxon, error)
+	// Count returns the number of taxa of the given rank.
+	Count(rank string) (int64, error)
+	// CountDescendants returns the number of taxa below the given taxon.
+	CountDescendants(rank string, id string) (int64, error)
+	// GetChildrenBatch returns the children of each of the taxa with the
+	// given document IDs, keyed by ID.
+	GetChildrenBatch(ids []string) (map[string][]Taxon, error)
+	// GetParentsBatch returns the parents of each of the taxa with the
+	// given document IDs, keyed by ID.
+	GetParentsBatch(ids []string) (map[string][]Taxon, error)
+	// GetLineageBatch returns the lineage from a taxon of the root rank down
+	// to each of the taxa with the given document IDs, keyed by ID. Taxa
+	// without a lineage are left out.
+	GetLineageBatch(ids []string, root string) (map[string][]Taxon, error)
+	// CountDescendantsBatch returns the number of taxa below each of the taxa
+	// with the given document IDs, keyed by ID.
+	CountDescendantsBatch(ids []string) (map[string]int64, error)
+	// WalkSubtree calls fn for the given taxon and each taxon below it, in
+	// depth-first pre-order, without loading the whole subtree at once.
+	WalkSubtree(rank string, id string, fn func(SubtreeNode) error) error
+	// GetCrossRefs returns the external checklist matches of a taxon.
+	GetCrossRefs(rank string, id string) ([]CrossRef, error)
+	// ListSnapshots returns the completed snapshots in order.
+	ListSnapshots() ([]Snapshot, error)
+	// GetSnapshotTaxa returns the taxon versions valid in the snapshot with
+	// the given sequence number.
+	GetSnapshotTaxa(seq int) ([]TaxonVersion, error)
+	// CreateTaxon stores a new curated taxon with an edge to its parent, if any.
+	CreateTaxon(taxon Taxon, parent string) error
+	// UpdateTaxon replaces the name, page and Wikidata QID of a taxon and
+	// marks it curated.
+	UpdateTaxon(taxon Taxon) error
+	// SetParent replaces the parents of a taxon with the given taxon.
+	SetParent(taxon Taxon, parent string) error
+	// DeleteTaxon removes a taxon and its edges. If mergeInto is set, the
+	// children and cross-references of the taxon are moved to it first. A
+	// tombstone keeps the scraper from storing the taxon again.
+	DeleteTaxon(taxon Taxon, mergeInto string) error
+	// AddAuditEntry records a curation change.
+	AddAuditEntry(entry AuditEntry) error
+	// ListAuditEntries returns the curation changes to a taxon, oldest first.
+	ListAuditEntries(taxonId string) ([]AuditEntry, error)
+	// ListCrossRefs returns up to limit checklist matches after skipping
+	// offset, optionally filtered by source and match status.
+	ListCrossRefs(source string, status string, offset int, limit int) ([]CrossRef, error)
+	// GetVersion returns the version of the graph, zero if it was never
+	// bumped.
+	GetVersion() (GraphVersion, error)
+	// BumpVersion increments the version of the graph.
+	BumpVersion() error
+}
+
+// SubtreeNode is a taxon visited while walking a subtree.
+type SubtreeNode struct {
+	Taxon Taxon
+	// Parent is the document ID of the parent taxon, empty for the subtree root.
+	Parent string
+	// Depth is the number of ranks below the subtree root.
+	Depth int
+	// Seen is set when the taxon was already visited through another parent,
+	// so only the edge from Parent is new.
+	Seen bool
+}
+
+// APIKeyRepo stores the API keys issued to clients. Keys are shared by all
+// graphs.
+type APIKeyRepo interface {
+	// GetAPIKey returns a single API key by ID.
+	GetAPIKey(id string) (APIKey, error)
+	// ListAPIKeys returns all API keys, including revoked keys.
+	ListAPIKeys() ([]APIKey, error)
+	// CreateAPIKey stores a new API key.
+	CreateAPIKey(key APIKey) error
+	// RevokeAPIKey marks an API key as no longer valid.
+	RevokeAPIKey(id string) error
+	// AddAPIKeyUsage adds to the usage counters of API keys by ID.
+	AddAPIKeyUsage(usage map[string]APIKeyUsage) error
+}
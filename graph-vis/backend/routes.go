@@ -1,47 +1,320 @@
 package main
 
 import (
+	"fmt"
 	http "net/http"
+	"strconv"
+	"strings"
+
+	"backend/api"
 
 	echo "github.com/labstack/echo/v4"
 )
 
 type JSONResp map[string]interface{}
 
-func badRequest(c echo.Context, msg string) error {
-	return c.JSON(http.StatusBadRequest, JSONResp{"error": msg})
-}
-
 // TaxonGet serves JSON response containing a single taxon by ID.
 func TaxonGet(c echo.Context) (err error) {
 	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
 	rank := c.Param("rank")
 	id := c.Param("id")
 	if id == "" {
-		return badRequest(c, "Missing taxon ID")
+		return badRequest("Missing taxon ID")
 	}
 	taxon, err := taxSvc.Get(rank, id)
 	if err != nil {
-		return badRequest(c, err.Error())
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": TaxonResponse(taxon)})
+}
+
+// TaxonGetByWikidataId serves JSON response containing the taxon with a Wikidata QID.
+func TaxonGetByWikidataId(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	taxon, err := taxSvc.GetByWikidataId(c.Param("qid"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": TaxonResponse(taxon)})
+}
+
+// TaxonGetByUrl serves JSON response containing the taxon scraped from the
+// Wikipedia page given by the `url` query parameter, a page URL or title.
+func TaxonGetByUrl(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	taxon, err := taxSvc.GetByUrl(c.QueryParam("url"))
+	if err != nil {
+		return err
 	}
 	return c.JSON(http.StatusOK, JSONResp{"data": TaxonResponse(taxon)})
 }
 
-// TaxonGetChildren serves JSON response containing a list of taxon children.
+// TaxonGetChildren serves JSON response containing a list of taxon children,
+// all of them unless paginated by the `offset` and `limit` query parameters.
 func TaxonGetChildren(c echo.Context) (err error) {
 	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
 	rank := c.Param("rank")
 	id := c.Param("id")
 	if id == "" {
-		return badRequest(c, "Missing taxon ID")
+		return badRequest("Missing taxon ID")
+	}
+	offset, err := offsetParam(c, api.MaxChildrenOffset)
+	if err != nil {
+		return err
+	}
+	limit, err := limitParam(c, 0, api.MaxChildrenLimit)
+	if err != nil {
+		return err
 	}
 	taxa, err := taxSvc.GetChildren(rank, id)
 	if err != nil {
-		return badRequest(c, err.Error())
+		return err
+	}
+	if limit == 0 {
+		limit = len(taxa)
 	}
+	taxa = page(taxa, offset, limit)
 	taxaResp := []TaxonResponse{}
 	for _, taxon := range taxa {
 		taxaResp = append(taxaResp, TaxonResponse(taxon))
 	}
 	return c.JSON(http.StatusOK, JSONResp{"data": taxaResp})
 }
+
+// TaxonGetLineage serves JSON response containing the lineage of a taxon from the kingdom down.
+func TaxonGetLineage(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	rank := c.Param("rank")
+	id := c.Param("id")
+	if id == "" {
+		return badRequest("Missing taxon ID")
+	}
+	taxa, err := taxSvc.GetLineage(rank, id)
+	if err != nil {
+		return err
+	}
+	taxaResp := []TaxonResponse{}
+	for _, taxon := range taxa {
+		taxaResp = append(taxaResp, TaxonResponse(taxon))
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": taxaResp})
+}
+
+// MRCAGet serves JSON response containing the most recent common ancestor of
+// two taxa given as `a` and `b`, or of any number of taxa given as repeated
+// `taxon` query parameters. Taxa are referenced as `<rank>/<id>`.
+func MRCAGet(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	refs := c.QueryParams()["taxon"]
+	pair := len(refs) == 0
+	if pair {
+		refs = []string{c.QueryParam("a"), c.QueryParam("b")}
+	}
+	lineages := [][]Taxon{}
+	for _, ref := range refs {
+		rank, id, ok := strings.Cut(ref, "/")
+		if !ok || rank == "" || id == "" {
+			return badRequest(fmt.Sprintf("Invalid taxon reference '%s'", ref))
+		}
+		lineage, err := taxSvc.GetLineage(rank, id)
+		if err != nil {
+			return err
+		}
+		lineages = append(lineages, lineage)
+	}
+	mrca, err := FindMRCA(lineages)
+	if err != nil {
+		return err
+	}
+
+	resp := MRCAResponse{MRCA: TaxonResponse(mrca.Ancestor), Paths: []MRCAPathResponse{}}
+	for _, path := range mrca.Paths {
+		pathResp := MRCAPathResponse{Taxon: TaxonResponse(path[0]), Path: []TaxonResponse{}, Distance: len(path) - 1}
+		for _, taxon := range path {
+			pathResp.Path = append(pathResp.Path, TaxonResponse(taxon))
+		}
+		resp.Paths = append(resp.Paths, pathResp)
+	}
+	if pair {
+		resp.Distance = &mrca.Distances[0][1]
+	} else {
+		resp.Distances = mrca.Distances
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": resp})
+}
+
+// RanksGet serves JSON response containing the taxonomic ranks in order.
+func RanksGet(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	ranks, err := taxSvc.Ranks()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": ranks})
+}
+
+// GraphsGet serves JSON response containing the graphs available to query.
+func GraphsGet(graphs []GraphConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, JSONResp{"data": graphs})
+	}
+}
+
+// TaxonGetCrossRefs serves JSON response containing the external checklist
+// matches of a taxon.
+func TaxonGetCrossRefs(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	rank := c.Param("rank")
+	id := c.Param("id")
+	if id == "" {
+		return badRequest("Missing taxon ID")
+	}
+	crossRefs, err := taxSvc.GetCrossRefs(rank, id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": crossRefs})
+}
+
+// CrossRefsList serves JSON response containing checklist matches filtered by
+// the `source` and `status` query parameters, e.g. `status=conflicting` to
+// list dubious placements, and paginated by `offset` and `limit`.
+func CrossRefsList(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	offset, err := offsetParam(c, api.MaxCrossRefsOffset)
+	if err != nil {
+		return err
+	}
+	limit, err := limitParam(c, 100, api.MaxCrossRefsLimit)
+	if err != nil {
+		return err
+	}
+	crossRefs, err := taxSvc.ListCrossRefs(c.QueryParam("source"), c.QueryParam("status"), offset, limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": crossRefs})
+}
+
+// SnapshotsList serves JSON response containing the completed crawl snapshots.
+func SnapshotsList(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	snapshots, err := taxSvc.Snapshots()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": snapshots})
+}
+
+// SnapshotsDiff serves JSON response containing the changes between the
+// snapshots given by the `from` and `to` query parameters. By default the
+// latest snapshot is compared with the one before it.
+func SnapshotsDiff(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	diff, err := taxSvc.DiffSnapshots(c.QueryParam("from"), c.QueryParam("to"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": diff})
+}
+
+// limitParam parses the `limit` query parameter.
+func limitParam(c echo.Context, def int, max int) (int, error) {
+	l := c.QueryParam("limit")
+	if l == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 || limit > max {
+		return 0, badRequest(fmt.Sprintf("Limit must be between 1 and %d", max))
+	}
+	return limit, nil
+}
+
+// offsetParam parses the `offset` query parameter.
+func offsetParam(c echo.Context, max int) (int, error) {
+	o := c.QueryParam("offset")
+	if o == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(o)
+	if err != nil || offset < 0 || offset > max {
+		return 0, badRequest(fmt.Sprintf("Offset must be between 0 and %d", max))
+	}
+	return offset, nil
+}
+
+// TaxonSearch serves JSON response containing taxa whose name matches the `q`
+// query parameter, optionally restricted to a `rank` and paginated by
+// `offset` and `limit`.
+func TaxonSearch(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	offset, err := offsetParam(c, api.MaxSearchOffset)
+	if err != nil {
+		return err
+	}
+	limit, err := limitParam(c, 20, api.MaxSearchLimit)
+	if err != nil {
+		return err
+	}
+	taxa, err := taxSvc.Search(c.QueryParam("q"), c.QueryParam("rank"), offset, limit)
+	if err != nil {
+		return err
+	}
+	taxaResp := []TaxonResponse{}
+	for _, taxon := range taxa {
+		taxaResp = append(taxaResp, TaxonResponse(taxon))
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": taxaResp})
+}
+
+// TaxonCreate adds a curated taxon from the JSON request body and serves it
+// as JSON response.
+func TaxonCreate(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	in := TaxonInput{}
+	if err := c.Bind(&in); err != nil {
+		return badRequest("Invalid taxon")
+	}
+	taxon, err := taxSvc.CreateTaxon(c.Get("user").(string), in)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, JSONResp{"data": TaxonResponse(taxon)})
+}
+
+// TaxonUpdate applies the fields given in the JSON request body to a taxon
+// and serves the result as JSON response.
+func TaxonUpdate(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	patch := TaxonPatch{}
+	if err := c.Bind(&patch); err != nil {
+		return badRequest("Invalid taxon patch")
+	}
+	taxon, err := taxSvc.UpdateTaxon(c.Get("user").(string), c.Param("rank"), c.Param("id"), patch)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": TaxonResponse(taxon)})
+}
+
+// TaxonDelete removes a taxon, or merges it into the taxon given by the
+// `mergeInto` query parameter as `<rank>/<id>`.
+func TaxonDelete(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	err = taxSvc.DeleteTaxon(c.Get("user").(string), c.Param("rank"), c.Param("id"), c.QueryParam("mergeInto"))
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TaxonGetAudit serves JSON response containing the curation history of a taxon.
+func TaxonGetAudit(c echo.Context) (err error) {
+	taxSvc := c.Get("taxonSvc").(*TaxonSvc)
+	entries, err := taxSvc.GetAuditEntries(c.Param("rank"), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, JSONResp{"data": entries})
+}
@@ -0,0 +1,1047 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	http "net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/api"
+	"backend/client"
+
+	"github.com/golang-jwt/jwt"
+	echo "github.com/labstack/echo/v4"
+)
+
+func newTestRouter(t *testing.T) http.Handler {
+	t.Helper()
+	return newTestRouterWith(t, func(*Config) {})
+}
+
+// newTestRouterWith creates a router over the test fixture with the config
+// changed by configure.
+func newTestRouterWith(t *testing.T, configure func(*Config)) http.Handler {
+	t.Helper()
+	repo, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	return newTestRouterWithRepo(t, repo, configure)
+}
+
+// newTestRouterWithRepo creates a router over the given repo with the config
+// changed by configure.
+func newTestRouterWithRepo(t *testing.T, repo TaxonRepo, configure func(*Config)) http.Handler {
+	t.Helper()
+	return newTestRouterWithMetrics(t, repo, NewMetrics(), configure)
+}
+
+// newTestRouterWithMetrics creates a router over the given repo recording
+// the given metrics.
+func newTestRouterWithMetrics(t *testing.T, repo TaxonRepo, metrics *Metrics, configure func(*Config)) http.Handler {
+	t.Helper()
+	cfg := Config{
+		DatabaseName:         "animal_kingdom",
+		GraphName:            "animal_kingdom",
+		TaxonRanks:           TaxonRanks,
+		WikipediaSite:        "https://en.wikipedia.org",
+		AuthJWKS:             writeTestJWKS(t),
+		AuthIssuer:           "https://auth.example.org/",
+		AuthAudience:         "https://api.example.org",
+		AuthRolesClaim:       "roles",
+		AuthPublicReads:      true,
+		AuthWriteRole:        "curator",
+		AuthAdminRole:        "admin",
+		ExtraGraphs:          []string{"snapshot@snapshots"},
+		GraphQLMaxDepth:      10,
+		GraphQLMaxComplexity: 5000,
+		CacheSize:            1000,
+		SnapshotCacheSize:    4,
+	}
+	configure(&cfg)
+	taxonSvcs := map[string]*TaxonSvc{}
+	for _, graph := range cfg.Graphs() {
+		taxonSvcs[graph.Name] = NewTaxonSvc(metrics.ObserveRepo(repo, graph.Name), cfg)
+		metrics.ObserveCache(taxonSvcs[graph.Name], graph.Name)
+	}
+	return NewRouter(cfg, taxonSvcs, NewRateLimiter(cfg, NewMemoryAPIKeyRepo()), NewHealth(taxonSvcs, time.Second), metrics)
+}
+
+// get performs a GET request and decodes the JSON response body into resp.
+func get(t *testing.T, router http.Handler, target string, resp interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("GET %s: invalid JSON response %q: %v", target, rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+// send performs a request as "alice", a curator and admin, with a JSON body
+// and decodes any JSON response body into resp.
+func send(t *testing.T, router http.Handler, method string, target string, body string, resp interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	token := testToken(t, jwt.MapClaims{"sub": "alice", "roles": []string{"curator", "admin"}})
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	router.ServeHTTP(rec, req)
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func taxonIds(taxa []TaxonResponse) []string {
+	ids := []string{}
+	for _, taxon := range taxa {
+		ids = append(ids, taxon.Id)
+	}
+	return ids
+}
+
+func TestTaxonGet(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/genus/7", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	want := TaxonResponse{TaxonBase: TaxonBase{Rank: "Genus", Name: "Felis", Url: "https://en.wikipedia.org/wiki/Felis"}, Id: "genus/7"}
+	if resp.Data != want {
+		t.Errorf("Expected %+v, got %+v", want, resp.Data)
+	}
+}
+
+func TestTaxonGetChildren(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/genus/7/children", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, []string{"species/9", "species/felis-silvestris"}) {
+		t.Errorf("Unexpected children %v", ids)
+	}
+	if code := get(t, router, "/api/v1/taxon/genus/7/children?offset=1&limit=1", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, []string{"species/felis-silvestris"}) {
+		t.Errorf("Unexpected page of children %v", ids)
+	}
+}
+
+func TestTaxonGetLineage(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/species/9/lineage", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	want := []string{"kingdom/1", "phylum/2", "class/3", "order/4", "family/5", "genus/7", "species/9"}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected lineage %v, got %v", want, ids)
+	}
+}
+
+func TestMRCAGet(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data MRCAResponse }
+	if code := get(t, router, "/api/v1/mrca?a=species/9&b=species/10", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp.Data.MRCA.Id != "order/4" {
+		t.Errorf("Expected MRCA order/4, got %s", resp.Data.MRCA.Id)
+	}
+	if resp.Data.Distance == nil || *resp.Data.Distance != 6 {
+		t.Errorf("Expected distance 6, got %v", resp.Data.Distance)
+	}
+	if ids := taxonIds(resp.Data.Paths[0].Path); !reflect.DeepEqual(ids, []string{"species/9", "genus/7", "family/5", "order/4"}) {
+		t.Errorf("Unexpected path %v", ids)
+	}
+}
+
+func TestMRCAGetMultiple(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data MRCAResponse }
+	code := get(t, router, "/api/v1/mrca?taxon=species/9&taxon=species/felis-silvestris&taxon=genus/8", &resp)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp.Data.MRCA.Id != "order/4" {
+		t.Errorf("Expected MRCA order/4, got %s", resp.Data.MRCA.Id)
+	}
+	want := [][]int{{0, 2, 5}, {2, 0, 5}, {5, 5, 0}}
+	if !reflect.DeepEqual(resp.Data.Distances, want) {
+		t.Errorf("Expected distances %v, got %v", want, resp.Data.Distances)
+	}
+}
+
+func TestRanksGet(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []Rank }
+	if code := get(t, router, "/api/v1/ranks", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(resp.Data) != len(TaxonRanks) {
+		t.Fatalf("Expected %d ranks, got %d", len(TaxonRanks), len(resp.Data))
+	}
+	if want := (Rank{Name: "species", Order: 6, Count: 3}); resp.Data[6] != want {
+		t.Errorf("Expected %+v, got %+v", want, resp.Data[6])
+	}
+}
+
+func TestGraphsGet(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []GraphConfig }
+	if code := get(t, router, "/api/v1/graphs", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	want := []GraphConfig{{Name: "animal_kingdom", Database: "animal_kingdom"}, {Name: "snapshot", Database: "snapshots"}}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Errorf("Expected %v, got %v", want, resp.Data)
+	}
+}
+
+func TestGraphRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data TaxonResponse }
+	if code := get(t, router, "/api/v1/graphs/snapshot/taxon/genus/7", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp.Data.Id != "genus/7" {
+		t.Errorf("Expected genus/7, got %s", resp.Data.Id)
+	}
+}
+
+func TestTaxonSearch(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/search?q=fel", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, []string{"family/5", "genus/7"}) {
+		t.Errorf("Unexpected results %v", ids)
+	}
+	if code := get(t, router, "/api/v1/search?q=f.&rank=species&limit=1", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, []string{"species/9"}) {
+		t.Errorf("Unexpected results %v", ids)
+	}
+	if code := get(t, router, "/api/v1/search?q=fel&offset=1&limit=1", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); !reflect.DeepEqual(ids, []string{"genus/7"}) {
+		t.Errorf("Unexpected page of results %v", ids)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	router := newTestRouter(t)
+	tests := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/api/v1/taxon/genus/999", http.StatusNotFound, "not_found"},
+		{"/api/v1/taxon/genusMembers/1", http.StatusNotFound, "not_found"},
+		{"/api/v1/taxon/_users/1/children", http.StatusNotFound, "not_found"},
+		{"/api/v1/taxon/genus/999/lineage", http.StatusNotFound, "not_found"},
+		{"/api/v1/graphs/unknown/taxon/genus/7", http.StatusNotFound, "not_found"},
+		{"/api/v1/mrca?a=species/9", http.StatusBadRequest, "bad_request"},
+		{"/api/v1/mrca?taxon=species/9", http.StatusBadRequest, "bad_request"},
+		{"/api/v1/search", http.StatusBadRequest, "bad_request"},
+		{"/api/v1/search?q=fel&limit=0", http.StatusBadRequest, "bad_request"},
+		{"/api/v1/search?q=fel&offset=-1", http.StatusBadRequest, "bad_request"},
+		{"/api/v1/unknown", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		var resp struct{ Error APIError }
+		if code := get(t, router, tt.target, &resp); code != tt.status {
+			t.Errorf("GET %s: expected status %d, got %d", tt.target, tt.status, code)
+		}
+		if resp.Error.Code != tt.code || resp.Error.Message == "" {
+			t.Errorf("GET %s: unexpected error %+v", tt.target, resp.Error)
+		}
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	router := newTestRouter(t)
+	query := `{"query": "query($limit: Int) { taxon(rank: \"order\", id: \"4\") { id name descendantsCount parent { name } children(limit: $limit) { name children { id } } } }", "variables": {"limit": 1}}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := `{"data":{"taxon":{"children":[{"children":[{"id":"genus/7"}],"name":"Felidae"}],"descendantsCount":7,"id":"order/4","name":"Carnivora","parent":{"name":"Mammalia"}}}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestGraphQLGraphs(t *testing.T) {
+	router := newTestRouter(t)
+	query := `{"query": "{ taxon(rank: \"genus\", id: \"7\") { name } }"}`
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/graphql", http.StatusOK},
+		{"/api/v1/graphs/snapshot/graphql", http.StatusOK},
+		{"/api/v1/graphs/unknown/graphql", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(query))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("POST %s: expected status %d, got %d: %s", tt.target, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// batchCountingRepo counts the repo calls made for descendant counts and
+// lineages.
+type batchCountingRepo struct {
+	*MemoryTaxonRepo
+	calls map[string]int
+}
+
+func (repo batchCountingRepo) CountDescendants(rank string, id string) (int64, error) {
+	repo.calls["CountDescendants"]++
+	return repo.MemoryTaxonRepo.CountDescendants(rank, id)
+}
+
+func (repo batchCountingRepo) CountDescendantsBatch(ids []string) (map[string]int64, error) {
+	repo.calls["CountDescendantsBatch"]++
+	return repo.MemoryTaxonRepo.CountDescendantsBatch(ids)
+}
+
+func (repo batchCountingRepo) GetLineage(rank string, id string, root string) ([]Taxon, error) {
+	repo.calls["GetLineage"]++
+	return repo.MemoryTaxonRepo.GetLineage(rank, id, root)
+}
+
+func (repo batchCountingRepo) GetLineageBatch(ids []string, root string) (map[string][]Taxon, error) {
+	repo.calls["GetLineageBatch"]++
+	return repo.MemoryTaxonRepo.GetLineageBatch(ids, root)
+}
+
+func TestGraphQLBatching(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	repo := batchCountingRepo{memory, map[string]int{}}
+	router := newTestRouterWithRepo(t, repo, func(*Config) {})
+	query := "{ taxon(rank: \"order\", id: \"4\") { children(limit: 2) { descendantsCount lineage { id } } } }"
+	var resp struct {
+		Data struct {
+			Taxon struct {
+				Children []struct {
+					DescendantsCount int
+					Lineage          []TaxonResponse
+				}
+			}
+		}
+	}
+	if code := get(t, router, "/graphql?query="+url.QueryEscape(query), &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	children := resp.Data.Taxon.Children
+	if len(children) != 2 || children[0].DescendantsCount+children[1].DescendantsCount != 5 || len(children[0].Lineage) != 5 {
+		t.Errorf("Unexpected children %+v", children)
+	}
+	if want := "map[CountDescendantsBatch:1 GetLineageBatch:1]"; fmt.Sprint(repo.calls) != want {
+		t.Errorf("Expected calls %s, got %v", want, repo.calls)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	router := newTestRouter(t)
+	tests := []string{
+		// Too deep.
+		"{ taxon(rank: \"kingdom\", id: \"1\") { children { children { children { children { children { children { children { children { children { children { id } } } } } } } } } } } }",
+		// Too complex.
+		"{ search(q: \"a\", limit: 100) { children(limit: 500) { id name } } }",
+		// Too many subtree traversals.
+		"{ search(q: \"a\", limit: 20) { descendantsCount } }",
+		// Too complex, with a negative limit on a sibling field.
+		"{ search(q: \"a\", limit: 100) { children(limit: 50) { id name } } taxon(rank: \"kingdom\", id: \"1\") { children(limit: -1000000) { id } } }",
+		// Too complex with the default of the operation's variable.
+		"query($l: Int = 100) { search(q: \"a\", limit: $l) { children(limit: 50) { id name } } }",
+	}
+	for _, query := range tests {
+		var resp struct{ Errors []struct{ Message string } }
+		if code := get(t, router, "/graphql?query="+url.QueryEscape(query), &resp); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", code)
+		}
+		if len(resp.Errors) != 1 {
+			t.Errorf("Expected one error, got %v", resp.Errors)
+		}
+	}
+}
+
+func TestTaxonExport(t *testing.T) {
+	router := newTestRouter(t)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"newick", "((('F. catus','F. silvestris')Felis)Felidae,(('P. vitulina')Phoca)Phocidae)Carnivora;\n"},
+		{"csv", "id,parent_id,rank,name,url,depth\n" +
+			"genus/7,,Genus,Felis,https://en.wikipedia.org/wiki/Felis,0\n" +
+			"species/9,genus/7,Species,F. catus,https://en.wikipedia.org/wiki/Cat,1\n" +
+			"species/felis-silvestris,genus/7,Species,F. silvestris,https://en.wikipedia.org/wiki/European_wildcat,1\n"},
+		{"dot", "digraph \"Felis\" {\n" +
+			"  \"genus/7\" [label=\"Felis\", rank=\"Genus\", URL=\"https://en.wikipedia.org/wiki/Felis\"];\n" +
+			"  \"species/9\" [label=\"F. catus\", rank=\"Species\", URL=\"https://en.wikipedia.org/wiki/Cat\"];\n" +
+			"  \"genus/7\" -> \"species/9\";\n" +
+			"  \"species/felis-silvestris\" [label=\"F. silvestris\", rank=\"Species\", URL=\"https://en.wikipedia.org/wiki/European_wildcat\"];\n" +
+			"  \"genus/7\" -> \"species/felis-silvestris\";\n}\n"},
+	}
+	for _, tt := range tests {
+		target := "/api/v1/taxon/genus/7/export?format=" + tt.format
+		if tt.format == "newick" {
+			target = "/api/v1/taxon/order/4/export?format=newick"
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", target, rec.Code)
+		}
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("GET %s: expected %q, got %q", target, tt.want, got)
+		}
+		if status := rec.Result().Trailer.Get(api.HeaderExportStatus); status != api.ExportComplete {
+			t.Errorf("GET %s: expected the export to be marked complete, got %q", target, status)
+		}
+	}
+}
+
+// walkFailingRepo loses its database after the first taxon of a subtree.
+type walkFailingRepo struct {
+	*MemoryTaxonRepo
+}
+
+func (repo walkFailingRepo) WalkSubtree(rank string, id string, fn func(SubtreeNode) error) error {
+	return repo.MemoryTaxonRepo.WalkSubtree(rank, id, func(node SubtreeNode) error {
+		if err := fn(node); err != nil {
+			return err
+		}
+		return newSvcError(ErrUnavailable, "Database unavailable")
+	})
+}
+
+func TestTaxonExportIncomplete(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	router := newTestRouterWithRepo(t, walkFailingRepo{memory}, func(*Config) {})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/genus/7/export?format=csv", nil))
+	if rec.Code != http.StatusOK || rec.Result().Trailer.Get(api.HeaderExportStatus) != "" {
+		t.Errorf("Expected a truncated export not to be marked complete, got %d and trailers %v", rec.Code, rec.Result().Trailer)
+	}
+}
+
+func TestTaxonExportStructured(t *testing.T) {
+	router := newTestRouter(t)
+	var cy struct {
+		Elements []struct {
+			Group string
+			Data  map[string]string
+		}
+	}
+	if code := get(t, router, "/api/v1/taxon/genus/7/export?format=cytoscape-json", &cy); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(cy.Elements) != 5 || cy.Elements[2].Data["source"] != "genus/7" || cy.Elements[2].Data["target"] != "species/9" {
+		t.Errorf("Unexpected elements %+v", cy.Elements)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/genus/7/export?format=graphml", nil))
+	var graphml struct {
+		Nodes []struct {
+			Id string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+		} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &graphml); err != nil {
+		t.Fatalf("Invalid GraphML: %v", err)
+	}
+	if len(graphml.Nodes) != 3 || len(graphml.Edges) != 2 {
+		t.Errorf("Expected 3 nodes and 2 edges, got %+v", graphml)
+	}
+
+	var resp struct{ Error APIError }
+	if code := get(t, router, "/api/v1/taxon/genus/7/export?format=nexus", &resp); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+}
+
+func TestTaxonExportFilename(t *testing.T) {
+	router := newTestRouter(t)
+	// The file is named after the taxon's key, not the legacy key requested.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/species/11/export?format=csv", nil))
+	want := "attachment; filename=species-felis-silvestris.csv"
+	if got := rec.Header().Get(echo.HeaderContentDisposition); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestTaxonExportSharedChild(t *testing.T) {
+	memory, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	// Give Felis, and so both its species, a second parent.
+	memory.children["family/6"] = append(memory.children["family/6"], "genus/7")
+	memory.parents["genus/7"] = append(memory.parents["genus/7"], "family/6")
+	router := newTestRouterWithRepo(t, memory, func(*Config) {})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/order/4/export?format=graphml", nil))
+	var graphml struct {
+		Nodes []struct {
+			Id string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &graphml); err != nil {
+		t.Fatalf("Invalid GraphML: %v", err)
+	}
+	// Each taxon is a node once, and Felis gets an edge from both families.
+	if len(graphml.Nodes) != 8 || len(graphml.Edges) != 8 {
+		t.Errorf("Expected 8 nodes and 8 edges, got %+v", graphml)
+	}
+	seen := map[string]bool{}
+	for _, node := range graphml.Nodes {
+		if seen[node.Id] {
+			t.Errorf("Expected a single node %s", node.Id)
+		}
+		seen[node.Id] = true
+	}
+
+	// Newick lists Felis under its first parent only.
+	want := "((('F. catus','F. silvestris')Felis)Felidae,(('P. vitulina')Phoca)Phocidae)Carnivora;\n"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/taxon/order/4/export?format=newick", nil))
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestTaxonGetCrossRefs(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []CrossRef }
+	if code := get(t, router, "/api/v1/taxon/species/9/crossrefs", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ExternalId != "2435035" || resp.Data[0].Status != "exact" {
+		t.Errorf("Unexpected cross-references %+v", resp.Data)
+	}
+}
+
+func TestCrossRefsList(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []CrossRef }
+	if code := get(t, router, "/api/v1/crossrefs?source=gbif&status=conflicting", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].TaxonId != "species/felis-silvestris" || !reflect.DeepEqual(resp.Data[0].Conflicts, []string{"family"}) {
+		t.Errorf("Unexpected cross-references %+v", resp.Data)
+	}
+
+	// Matches are paged in order of taxon.
+	resp.Data = nil
+	if code := get(t, router, "/api/v1/crossrefs?offset=1&limit=1", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].TaxonId != "species/9" {
+		t.Errorf("Unexpected cross-references %+v", resp.Data)
+	}
+}
+
+func TestTaxonGetByWikidataId(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/by-wikidata/Q146", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp.Data.Id != "species/9" || resp.Data.WikidataId != "Q146" {
+		t.Errorf("Unexpected taxon %+v", resp.Data)
+	}
+	var errResp struct{ Error APIError }
+	if code := get(t, router, "/api/v1/taxon/by-wikidata/Q1", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/taxon/by-wikidata/146", &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+
+	// A homonym copy shares the QID, so the lookup is ambiguous.
+	repo, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	homonym := Taxon{TaxonBase: TaxonBase{Rank: "Species", Name: "F. catus", WikidataId: "Q146"}, Id: "species/felis-catus_8"}
+	if err := repo.CreateTaxon(homonym, "genus/8"); err != nil {
+		t.Fatal(err)
+	}
+	var conflictResp struct {
+		Error struct {
+			Details struct{ Candidates []TaxonResponse }
+		}
+	}
+	router = newTestRouterWithRepo(t, repo, func(*Config) {})
+	if code := get(t, router, "/api/v1/taxon/by-wikidata/Q146", &conflictResp); code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", code)
+	}
+	if candidates := conflictResp.Error.Details.Candidates; len(candidates) != 2 {
+		t.Errorf("Expected both taxa as candidates, got %+v", candidates)
+	}
+}
+
+func TestTaxonGetByUrl(t *testing.T) {
+	router := newTestRouter(t)
+	for _, input := range []string{
+		"https://en.wikipedia.org/wiki/Harbor_seal",
+		"https://en.m.wikipedia.org/wiki/Harbor_seal#Taxonomy",
+		"en.wikipedia.org/w/index.php?title=Harbor_seal&oldid=1",
+		"harbor seal",
+		"Harbor%20seal",
+	} {
+		var resp struct{ Data TaxonResponse }
+		if code := get(t, router, "/api/v1/taxon/by-url?url="+url.QueryEscape(input), &resp); code != http.StatusOK {
+			t.Errorf("Expected status 200 for '%s', got %d", input, code)
+		} else if resp.Data.Id != "species/10" {
+			t.Errorf("Unexpected taxon for '%s' %+v", input, resp.Data)
+		}
+	}
+	var errResp struct{ Error APIError }
+	if code := get(t, router, "/api/v1/taxon/by-url?url=Dog", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/taxon/by-url?url="+url.QueryEscape("https://example.org/wiki/Cat"), &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/taxon/by-url", &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+}
+
+func TestTaxonGetByAlternativeIds(t *testing.T) {
+	router := newTestRouter(t)
+	for _, id := range []string{"felis-silvestris", "Felis_silvestris", "11"} {
+		var resp struct{ Data TaxonResponse }
+		if code := get(t, router, "/api/v1/taxon/species/"+id, &resp); code != http.StatusOK {
+			t.Errorf("Expected status 200 for '%s', got %d", id, code)
+		} else if resp.Data.Id != "species/felis-silvestris" {
+			t.Errorf("Unexpected taxon for '%s' %+v", id, resp.Data)
+		}
+	}
+	var resp struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/species/11/lineage", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(resp.Data); len(ids) != 7 || ids[6] != "species/felis-silvestris" {
+		t.Errorf("Unexpected lineage %v", ids)
+	}
+}
+
+func TestSnapshotsList(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data []Snapshot }
+	if code := get(t, router, "/api/v1/snapshots", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].Seq != 1 || resp.Data[1].Id != "20261001T000000Z" {
+		t.Errorf("Unexpected snapshots %+v", resp.Data)
+	}
+}
+
+func TestSnapshotsDiff(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data SnapshotDiff }
+	if code := get(t, router, "/api/v1/snapshots/diff", &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	diff := resp.Data
+	ids := func(versions []TaxonVersion) []string {
+		ids := []string{}
+		for _, v := range versions {
+			ids = append(ids, v.TaxonId)
+		}
+		return ids
+	}
+	if diff.From.Seq != 1 || diff.To.Seq != 2 {
+		t.Errorf("Unexpected snapshots %+v %+v", diff.From, diff.To)
+	}
+	if got := ids(diff.Added); !reflect.DeepEqual(got, []string{"family/6", "genus/8"}) {
+		t.Errorf("Unexpected added taxa %v", got)
+	}
+	if got := ids(diff.Removed); !reflect.DeepEqual(got, []string{"species/12"}) {
+		t.Errorf("Unexpected removed taxa %v", got)
+	}
+	if len(diff.Renamed) != 1 || diff.Renamed[0].From.Name != "F. sylvestris" || diff.Renamed[0].To.TaxonId != "species/felis-silvestris" {
+		t.Errorf("Unexpected renamed taxa %+v", diff.Renamed)
+	}
+	if len(diff.Moved) != 1 || diff.Moved[0].To.TaxonId != "species/10" || diff.Moved[0].To.Parent != "genus/8" {
+		t.Errorf("Unexpected moved taxa %+v", diff.Moved)
+	}
+
+	var errResp struct{ Error APIError }
+	if code := get(t, router, "/api/v1/snapshots/diff?from=20261001T000000Z&to=nope", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/snapshots/diff?to=20260901T000000Z", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+}
+
+func TestAsOf(t *testing.T) {
+	router := newTestRouter(t)
+	var taxon struct{ Data TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/species/12?asOf=20260901T000000Z", &taxon); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if taxon.Data.Name != "F. lybica" {
+		t.Errorf("Unexpected taxon %+v", taxon.Data)
+	}
+	var errResp struct{ Error APIError }
+	if code := get(t, router, "/api/v1/taxon/species/12", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for current taxonomy, got %d", code)
+	}
+
+	var lineage struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/species/10/lineage?asOf=2026-09-15", &lineage); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(lineage.Data); len(ids) != 7 || ids[5] != "genus/7" {
+		t.Errorf("Unexpected lineage %v", ids)
+	}
+
+	var children struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/taxon/genus/7/children?asOf=2026-09-15T00:00:00Z", &children); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(children.Data); len(ids) != 4 {
+		t.Errorf("Unexpected children %v", ids)
+	}
+
+	var search struct{ Data []TaxonResponse }
+	if code := get(t, router, "/api/v1/search?q=sylvestris&asOf=20260901T000000Z", &search); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if ids := taxonIds(search.Data); !reflect.DeepEqual(ids, []string{"species/felis-sylvestris"}) {
+		t.Errorf("Unexpected search results %v", ids)
+	}
+
+	if code := get(t, router, "/api/v1/taxon/genus/7?asOf=yesterday", &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/taxon/genus/7?asOf=2020-01-01", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+}
+
+func TestTaxonCreateUpdate(t *testing.T) {
+	router := newTestRouter(t)
+	var resp struct{ Data TaxonResponse }
+	body := `{"rank": "species", "name": "F. margarita", "parent": "genus/7", "wikidataId": "Q182800"}`
+	if code := send(t, router, http.MethodPost, "/api/v1/taxon", body, &resp); code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	if resp.Data.Id != "species/felis-margarita" || resp.Data.Rank != "Species" || !resp.Data.Curated {
+		t.Errorf("Unexpected created taxon %+v", resp.Data)
+	}
+	var children struct{ Data []TaxonResponse }
+	get(t, router, "/api/v1/taxon/genus/7/children", &children)
+	if ids := taxonIds(children.Data); !reflect.DeepEqual(ids, []string{"species/9", "species/felis-silvestris", "species/felis-margarita"}) {
+		t.Errorf("Unexpected children %v", ids)
+	}
+	var errResp struct{ Error APIError }
+	if code := send(t, router, http.MethodPost, "/api/v1/taxon", body, &errResp); code != http.StatusConflict {
+		t.Errorf("Expected duplicate to be rejected with status 409, got %d", code)
+	}
+	if code := send(t, router, http.MethodPost, "/api/v1/taxon", `{"rank": "species", "name": "P. largha", "parent": "family/6"}`, &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected parent of wrong rank to be rejected with status 400, got %d", code)
+	}
+
+	body = `{"name": "Felis margarita", "parent": "genus/8"}`
+	if code := send(t, router, http.MethodPatch, "/api/v1/taxon/species/felis-margarita", body, &resp); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp.Data.Name != "Felis margarita" || resp.Data.WikidataId != "Q182800" {
+		t.Errorf("Unexpected updated taxon %+v", resp.Data)
+	}
+	var lineage struct{ Data []TaxonResponse }
+	get(t, router, "/api/v1/taxon/species/felis-margarita/lineage", &lineage)
+	if ids := taxonIds(lineage.Data); ids[len(ids)-2] != "genus/8" {
+		t.Errorf("Expected taxon to move to genus/8, got lineage %v", ids)
+	}
+
+	var audit struct{ Data []AuditEntry }
+	if code := send(t, router, http.MethodGet, "/api/v1/taxon/species/felis-margarita/audit", "", &audit); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(audit.Data) != 2 || audit.Data[0].Action != AuditCreate || audit.Data[1].Action != AuditUpdate {
+		t.Fatalf("Unexpected audit entries %+v", audit.Data)
+	}
+	update := audit.Data[1]
+	if update.User != "alice" || update.Before.Parent != "genus/7" || update.After.Parent != "genus/8" || update.Before.Name != "F. margarita" {
+		t.Errorf("Unexpected audit entry %+v", update)
+	}
+}
+
+func TestTaxonDelete(t *testing.T) {
+	router := newTestRouter(t)
+	var errResp struct{ Error APIError }
+	if code := send(t, router, http.MethodDelete, "/api/v1/taxon/genus/7", "", &errResp); code != http.StatusConflict {
+		t.Errorf("Expected deleting a taxon with children to fail with status 409, got %d", code)
+	}
+	if code := send(t, router, http.MethodDelete, "/api/v1/taxon/species/9", "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if code := get(t, router, "/api/v1/taxon/species/9", &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected deleted taxon to be gone, got status %d", code)
+	}
+
+	if code := send(t, router, http.MethodDelete, "/api/v1/taxon/genus/7?mergeInto=family/5", "", &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected merge into another rank to fail with status 400, got %d", code)
+	}
+	if code := send(t, router, http.MethodDelete, "/api/v1/taxon/genus/7?mergeInto=genus/8", "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	var children struct{ Data []TaxonResponse }
+	get(t, router, "/api/v1/taxon/genus/8/children", &children)
+	if ids := taxonIds(children.Data); !reflect.DeepEqual(ids, []string{"species/10", "species/felis-silvestris"}) {
+		t.Errorf("Expected children to be merged, got %v", ids)
+	}
+	var audit struct{ Data []AuditEntry }
+	send(t, router, http.MethodGet, "/api/v1/taxon/genus/8/audit", "", &audit)
+	if len(audit.Data) != 1 || audit.Data[0].Action != AuditMerge || audit.Data[0].TaxonId != "genus/7" {
+		t.Errorf("Expected merge in audit log of target, got %+v", audit.Data)
+	}
+}
+
+func TestCurationAsOfReadOnly(t *testing.T) {
+	router := newTestRouter(t)
+	var errResp struct{ Error APIError }
+	if code := send(t, router, http.MethodDelete, "/api/v1/taxon/species/10?asOf=20260901T000000Z", "", &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected write against a snapshot to fail with status 400, got %d", code)
+	}
+}
+
+// unreadyRepo is a repo whose database cannot be reached.
+type unreadyRepo struct {
+	*MemoryTaxonRepo
+}
+
+func (repo unreadyRepo) Ready(ctx context.Context) error {
+	return newSvcError(ErrUnavailable, "Database unavailable")
+}
+
+func TestHealthProbes(t *testing.T) {
+	// Probes answer without a token even when reads need one.
+	router := newTestRouterWith(t, func(cfg *Config) { cfg.AuthPublicReads = false })
+	var resp struct {
+		Status string
+		Graphs map[string]string
+	}
+	if code := get(t, router, "/healthz", &resp); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("Expected alive, got %d %+v", code, resp)
+	}
+	if code := get(t, router, "/readyz", &resp); code != http.StatusOK || resp.Status != "ready" || resp.Graphs["animal_kingdom"] != "ok" {
+		t.Errorf("Expected ready, got %d %+v", code, resp)
+	}
+
+	repo, _ := LoadMemoryTaxonRepo("testdata/taxa.json")
+	cfg := Config{GraphName: "animal_kingdom", TaxonRanks: TaxonRanks, AuthPublicReads: true, GraphQLMaxDepth: 10, GraphQLMaxComplexity: 5000}
+	taxonSvcs := map[string]*TaxonSvc{"animal_kingdom": NewTaxonSvc(unreadyRepo{repo}, cfg)}
+	health := NewHealth(taxonSvcs, time.Second)
+	router = NewRouter(cfg, taxonSvcs, NewRateLimiter(cfg, NewMemoryAPIKeyRepo()), health, NewMetrics())
+	if code := get(t, router, "/readyz", &resp); code != http.StatusServiceUnavailable || resp.Graphs["animal_kingdom"] != "Database unavailable" {
+		t.Errorf("Expected unavailable, got %d %+v", code, resp)
+	}
+	health.Drain()
+	if code := get(t, router, "/readyz", &resp); code != http.StatusServiceUnavailable || resp.Status != "draining" {
+		t.Errorf("Expected draining, got %d %+v", code, resp)
+	}
+	if code := get(t, router, "/healthz", &resp); code != http.StatusOK {
+		t.Errorf("Expected alive while draining, got %d", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	repo, err := LoadMemoryTaxonRepo("testdata/taxa.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	metrics := NewMetrics()
+	router := newTestRouterWithMetrics(t, repo, metrics, func(cfg *Config) { cfg.AuthPublicReads = false })
+	token := testToken(t, jwt.MapClaims{"sub": "alice"})
+	for _, target := range []string{"/api/v1/taxon/species/9", "/api/v1/taxon/species/nope", "/nope"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Metrics are not served by the API, but on the admin listener.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected metrics to be off the API, got status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected metrics, got status %d", rec.Code)
+	}
+	for _, line := range []string{
+		`graphvis_http_requests_total{code="200",method="GET",route="/api/v1/taxon/:rank/:id"} 1`,
+		`graphvis_http_requests_total{code="404",method="GET",route="/api/v1/taxon/:rank/:id"} 1`,
+		`graphvis_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`graphvis_http_request_duration_seconds_count{method="GET",route="/api/v1/taxon/:rank/:id"} 2`,
+		`graphvis_http_requests_in_flight 0`,
+		`graphvis_db_query_duration_seconds_count{graph="animal_kingdom",operation="Get",outcome="ok"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("Expected metrics to contain %q", line)
+		}
+	}
+}
+
+func TestHTTPCaching(t *testing.T) {
+	router := newTestRouterWith(t, func(cfg *Config) {
+		cfg.CacheControlTaxon = "max-age=60"
+		cfg.CacheControlChildren = "no-cache"
+	})
+	request := func(target string, header string, value string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("/api/v1/taxon/genus/7", "", "")
+	etag := rec.Header().Get(HeaderETag)
+	if rec.Code != http.StatusOK || etag != `"v0"` || rec.Header().Get(echo.HeaderCacheControl) != "max-age=60" {
+		t.Fatalf("Expected caching headers, got %d %v", rec.Code, rec.Header())
+	}
+	if cc := request("/api/v1/taxon/genus/7/children", "", "").Header().Get(echo.HeaderCacheControl); cc != "no-cache" {
+		t.Errorf("Expected the children Cache-Control, got %q", cc)
+	}
+	rec = request("/api/v1/taxon/genus/7", HeaderIfNoneMatch, `"v9", `+etag)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get(HeaderETag) != etag {
+		t.Errorf("Expected 304 Not Modified, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := request("/api/v1/taxon/genus/missing", "", ""); rec.Code != http.StatusNotFound || rec.Header().Get(HeaderETag) != "" {
+		t.Errorf("Expected an error without ETag, got %d %v", rec.Code, rec.Header())
+	}
+	for _, value := range []string{"*", etag} {
+		if rec := request("/api/v1/taxon/genus/missing/children", HeaderIfNoneMatch, value); rec.Code != http.StatusNotFound {
+			t.Errorf("If-None-Match %s: expected a missing taxon to be 404, got %d", value, rec.Code)
+		}
+	}
+	if rec := request("/api/v1/taxon/genus/7", HeaderIfNoneMatch, "*"); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an existing taxon matching *, got %d", rec.Code)
+	}
+
+	// A change gives the graph a new version.
+	var resp JSONResp
+	if code := send(t, router, http.MethodPatch, "/api/v1/taxon/genus/7", `{"name": "Felis L."}`, &resp); code != http.StatusOK {
+		t.Fatalf("Failed to update taxon: %d %v", code, resp)
+	}
+	rec = request("/api/v1/taxon/genus/7", HeaderIfNoneMatch, etag)
+	modified := rec.Header().Get(echo.HeaderLastModified)
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderETag) != `"v1"` || modified == "" {
+		t.Fatalf("Expected the new version, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := request("/api/v1/taxon/genus/7", echo.HeaderIfModifiedSince, modified); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 Not Modified since %s, got %d", modified, rec.Code)
+	}
+	if rec := request("/api/v1/taxon/genus/7", echo.HeaderIfModifiedSince, "Mon, 01 Jan 2024 00:00:00 GMT"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the taxon modified since 2024, got %d", rec.Code)
+	}
+
+	// Snapshots have tags of their own.
+	rec = request("/api/v1/taxon/genus/7?asOf=2026-09-15", "", "")
+	if etag := rec.Header().Get(HeaderETag); rec.Code != http.StatusOK || !strings.HasPrefix(etag, `"s`) || rec.Header().Get(echo.HeaderLastModified) == "" {
+		t.Errorf("Expected a snapshot ETag, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(newTestRouter(t))
+	defer server.Close()
+	c, err := client.New(server.URL, client.WithRetries(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	ctx := context.Background()
+
+	taxon, err := c.Get(ctx, "species", "9")
+	if err != nil || taxon.Name != "F. catus" || taxon.WikidataId != "Q146" {
+		t.Errorf("Unexpected taxon %+v %v", taxon, err)
+	}
+	lineage, err := c.Lineage(ctx, "genus", "7")
+	if err != nil || len(lineage) != 6 || lineage[5].Id != "genus/7" {
+		t.Errorf("Unexpected lineage %v %v", taxonIds(lineage), err)
+	}
+	it := c.IterSearch(ctx, "f", "", 1)
+	ids := []string{}
+	for it.Next() {
+		ids = append(ids, it.Taxon().Id)
+	}
+	if err := it.Err(); err != nil || !reflect.DeepEqual(ids, []string{"family/5", "genus/7", "species/9", "species/felis-silvestris"}) {
+		t.Errorf("Unexpected search results %v %v", ids, err)
+	}
+	children, err := c.Children(ctx, "genus", "7")
+	if err != nil || !reflect.DeepEqual(taxonIds(children), []string{"species/9", "species/felis-silvestris"}) {
+		t.Errorf("Unexpected children %v %v", taxonIds(children), err)
+	}
+
+	subtree := c.Graph("snapshot").Subtree(ctx, "family", "5")
+	defer subtree.Close()
+	nodes := []string{}
+	for subtree.Next() {
+		node := subtree.Node()
+		nodes = append(nodes, fmt.Sprintf("%s<%s@%d", node.Taxon.Id, node.ParentId, node.Depth))
+	}
+	if err := subtree.Err(); err != nil || !reflect.DeepEqual(nodes, []string{"family/5<@0", "genus/7<family/5@1", "species/9<genus/7@2", "species/felis-silvestris<genus/7@2"}) {
+		t.Errorf("Unexpected subtree %v %v", nodes, err)
+	}
+
+	_, err = c.Get(ctx, "genus", "999")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || apiErr.Code != "not_found" {
+		t.Errorf("Expected a not_found error, got %v", err)
+	}
+}
@@ -1,54 +1,396 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
-	arango "github.com/arangodb/go-driver"
+	"golang.org/x/text/unicode/norm"
 )
 
 type TaxonSvc struct {
-	db arango.Database
+	repo          TaxonRepo
+	ranks         []string
+	wikipediaSite string
+	redirects     RedirectResolver
+	history       *snapshotHistory
+	// readOnly is set on services answering against a snapshot.
+	readOnly bool
+	snapshot Snapshot
+	// cache holds recent lookups, or is nil if caching is disabled.
+	cache *lookupCache
 }
 
-func NewTaxonSvc(db arango.Database) *TaxonSvc {
-	return &TaxonSvc{db: db}
+func NewTaxonSvc(repo TaxonRepo, cfg Config) *TaxonSvc {
+	svc := &TaxonSvc{
+		repo:          repo,
+		ranks:         cfg.TaxonRanks,
+		wikipediaSite: cfg.WikipediaSite,
+		history:       newSnapshotHistory(cfg.SnapshotCacheSize),
+	}
+	if cfg.WikipediaRedirectTimeout > 0 {
+		svc.redirects = NewWikipediaRedirectResolver(cfg.WikipediaRedirectTimeout)
+	}
+	if cfg.CacheSize > 0 {
+		svc.cache = newLookupCache(cfg.CacheSize, cfg.CacheTTL, cfg.CacheVersionCheckInterval, repo.GetVersion)
+	}
+	return svc
+}
+
+// checkRank returns an error unless rank is one of the configured ranks. Ranks
+// are used as collection names, so this must be called before any lookup.
+func (svc *TaxonSvc) checkRank(rank string) error {
+	for _, r := range svc.ranks {
+		if r == rank {
+			return nil
+		}
+	}
+	return newSvcError(ErrNotFound, "Unknown rank '%s'", rank)
+}
+
+// Ready returns an error unless the graph can be queried.
+func (svc *TaxonSvc) Ready(ctx context.Context) error {
+	return svc.repo.Ready(ctx)
+}
+
+// Version returns the version of the graph, which changes with each crawl
+// and curation change. A service answering against a snapshot returns the
+// snapshot's sequence number and completion time.
+func (svc *TaxonSvc) Version() (GraphVersion, error) {
+	if svc.readOnly {
+		return GraphVersion{Version: int64(svc.snapshot.Seq), UpdatedAt: svc.snapshot.FinishedAt}, nil
+	}
+	if svc.cache != nil {
+		svc.cache.sync()
+		if version, ok := svc.cache.Version(); ok {
+			return version, nil
+		}
+	}
+	return svc.repo.GetVersion()
 }
 
-// Get returns a single taxon by ID.
+// Ranks returns the configured ranks in order with their taxon counts.
+func (svc *TaxonSvc) Ranks() ([]Rank, error) {
+	ranks := []Rank{}
+	for i, name := range svc.ranks {
+		count, err := svc.repo.Count(name)
+		if err != nil {
+			return ranks, err
+		}
+		ranks = append(ranks, Rank{Name: name, Order: i, Count: count})
+	}
+	return ranks, nil
+}
+
+// Get returns a single taxon by ID. Besides its stable key, a taxon may be
+// given by its scientific name, e.g. "Felis_catus", or by the key it had
+// before keys were migrated.
 func (svc *TaxonSvc) Get(rank string, id string) (Taxon, error) {
-	taxon := Taxon{}
-	col, err := svc.db.Collection(nil, rank)
-	if err != nil {
-		return taxon, err
+	if err := svc.checkRank(rank); err != nil {
+		return Taxon{}, err
 	}
-	_, err = col.ReadDocument(nil, id, &taxon)
-	if err != nil {
+	return cached(svc, "get:"+rank+"/"+id, func() (Taxon, error) {
+		return svc.get(rank, id)
+	})
+}
+
+func (svc *TaxonSvc) get(rank string, id string) (Taxon, error) {
+	taxon, err := svc.repo.Get(rank, id)
+	if !errors.Is(err, ErrNotFound) {
 		return taxon, err
 	}
-	return taxon, nil
+	if key := TaxonKey(id); key != id && key != "" {
+		if taxon, keyErr := svc.repo.Get(rank, key); !errors.Is(keyErr, ErrNotFound) {
+			return taxon, keyErr
+		}
+	}
+	taxa, legacyErr := svc.repo.FindByLegacyKey(rank, id)
+	if legacyErr != nil {
+		return taxon, legacyErr
+	}
+	if len(taxa) > 0 {
+		return taxa[0], nil
+	}
+	return taxon, err
 }
 
+// TaxonKey normalises a scientific name to a stable taxon key as the scraper
+// does: lower case with accents removed, with each run of other characters
+// replaced by a hyphen.
+func TaxonKey(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range norm.NFD.String(ligatures.Replace(strings.ToLower(name))) {
+		if unicode.Is(unicode.Mn, r) {
+			// The accent of a decomposed letter, e.g. the acute of é.
+			continue
+		}
+		if r >= utf8.RuneSelf || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			hyphen = true
+			continue
+		}
+		if hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		hyphen = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ligatures spells out the letters NFD does not decompose.
+var ligatures = strings.NewReplacer("æ", "ae", "œ", "oe", "ß", "ss", "ø", "o", "ł", "l", "đ", "d")
+
 // GetChildren returns a list of taxon children.
 func (svc *TaxonSvc) GetChildren(rank string, id string) ([]Taxon, error) {
-	taxa := []Taxon{}
-	query := "FOR v IN 1..1 INBOUND @start GRAPH 'animal_kingdom' RETURN v"
-	bindVars := map[string]interface{}{
-		"start": fmt.Sprintf("%s/%s", rank, id),
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return []Taxon{}, err
+	}
+	return cached(svc, "children:"+taxon.Id, func() ([]Taxon, error) {
+		return svc.repo.GetChildren(rank, taxon.Key())
+	})
+}
+
+// GetLineage returns the taxa on the path from the kingdom down to the given taxon.
+func (svc *TaxonSvc) GetLineage(rank string, id string) ([]Taxon, error) {
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return []Taxon{}, err
+	}
+	return cached(svc, "lineage:"+taxon.Id, func() ([]Taxon, error) {
+		return svc.repo.GetLineage(rank, taxon.Key(), svc.ranks[0])
+	})
+}
+
+// CountDescendants returns the number of taxa below the given taxon.
+func (svc *TaxonSvc) CountDescendants(rank string, id string) (int64, error) {
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return 0, err
+	}
+	return svc.repo.CountDescendants(rank, taxon.Key())
+}
+
+// GetChildrenBatch returns the children of each of the taxa with the given
+// document IDs (`<rank>/<id>`), keyed by ID.
+func (svc *TaxonSvc) GetChildrenBatch(ids []string) (map[string][]Taxon, error) {
+	if err := svc.checkDocumentIds(ids); err != nil {
+		return nil, err
+	}
+	return cachedBatch(svc, "children:", ids, func(ids []string) (map[string][]Taxon, error) {
+		children, err := svc.repo.GetChildrenBatch(ids)
+		if err != nil {
+			return nil, err
+		}
+		// Taxa without children are cached as GetChildren caches them.
+		for _, id := range ids {
+			if _, ok := children[id]; !ok {
+				children[id] = []Taxon{}
+			}
+		}
+		return children, nil
+	})
+}
+
+// GetParentsBatch returns the parents of each of the taxa with the given
+// document IDs (`<rank>/<id>`), keyed by ID.
+func (svc *TaxonSvc) GetParentsBatch(ids []string) (map[string][]Taxon, error) {
+	if err := svc.checkDocumentIds(ids); err != nil {
+		return nil, err
+	}
+	return svc.repo.GetParentsBatch(ids)
+}
+
+// GetLineageBatch returns the lineage of each of the taxa with the given
+// document IDs (`<rank>/<id>`), keyed by ID.
+func (svc *TaxonSvc) GetLineageBatch(ids []string) (map[string][]Taxon, error) {
+	if err := svc.checkDocumentIds(ids); err != nil {
+		return nil, err
+	}
+	return cachedBatch(svc, "lineage:", ids, func(ids []string) (map[string][]Taxon, error) {
+		return svc.repo.GetLineageBatch(ids, svc.ranks[0])
+	})
+}
+
+// CountDescendantsBatch returns the number of taxa below each of the taxa
+// with the given document IDs (`<rank>/<id>`), keyed by ID.
+func (svc *TaxonSvc) CountDescendantsBatch(ids []string) (map[string]int64, error) {
+	if err := svc.checkDocumentIds(ids); err != nil {
+		return nil, err
+	}
+	return svc.repo.CountDescendantsBatch(ids)
+}
+
+// checkDocumentIds returns an error unless all IDs are of configured ranks.
+func (svc *TaxonSvc) checkDocumentIds(ids []string) error {
+	for _, id := range ids {
+		rank, _, ok := strings.Cut(id, "/")
+		if !ok {
+			return newSvcError(ErrInvalid, "Invalid taxon reference '%s'", id)
+		}
+		if err := svc.checkRank(rank); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkSubtree calls fn for the given taxon and each taxon below it, in
+// depth-first pre-order. A taxon with several parents in the subtree is visited
+// once per parent but only walked below once; later visits are marked Seen.
+func (svc *TaxonSvc) WalkSubtree(rank string, id string, fn func(SubtreeNode) error) error {
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return err
+	}
+	visited := map[string]bool{}
+	// skipBelow is the depth of the last taxon seen before, whose subtree the
+	// repository walks again.
+	skipBelow := -1
+	return svc.repo.WalkSubtree(rank, taxon.Key(), func(node SubtreeNode) error {
+		if skipBelow >= 0 && node.Depth > skipBelow {
+			return nil
+		}
+		skipBelow = -1
+		if visited[node.Taxon.Id] {
+			node.Seen = true
+			skipBelow = node.Depth
+		}
+		visited[node.Taxon.Id] = true
+		return fn(node)
+	})
+}
+
+// GetCrossRefs returns the external checklist matches of a taxon.
+func (svc *TaxonSvc) GetCrossRefs(rank string, id string) ([]CrossRef, error) {
+	taxon, err := svc.Get(rank, id)
+	if err != nil {
+		return []CrossRef{}, err
+	}
+	return svc.repo.GetCrossRefs(rank, taxon.Key())
+}
+
+// ListCrossRefs returns up to limit checklist matches after skipping offset,
+// optionally filtered by source and match status.
+func (svc *TaxonSvc) ListCrossRefs(source string, status string, offset int, limit int) ([]CrossRef, error) {
+	return svc.repo.ListCrossRefs(source, status, offset, limit)
+}
+
+var wikidataQIDRegexp = regexp.MustCompile(`^Q[1-9][0-9]*$`)
+
+// GetByWikidataId returns the taxon with the given Wikidata QID. The lowest
+// rank holding the QID is searched where a page covers several ranks, e.g. a
+// monotypic genus. Homonym copies of a taxon share its page and QID, so a
+// conflict listing the candidates is returned if the rank holds several.
+func (svc *TaxonSvc) GetByWikidataId(qid string) (Taxon, error) {
+	if !wikidataQIDRegexp.MatchString(qid) {
+		return Taxon{}, newSvcError(ErrInvalid, "Invalid Wikidata QID '%s'", qid)
+	}
+	for i := len(svc.ranks) - 1; i >= 0; i-- {
+		taxa, err := svc.repo.FindByWikidataId(svc.ranks[i], qid)
+		if err != nil {
+			return Taxon{}, err
+		}
+		if len(taxa) > 1 {
+			candidates := make([]TaxonResponse, len(taxa))
+			for i, taxon := range taxa {
+				candidates[i] = TaxonResponse(taxon)
+			}
+			return Taxon{}, &SvcError{
+				Kind:    ErrConflict,
+				Message: fmt.Sprintf("Wikidata QID '%s' is held by %d taxa", qid, len(taxa)),
+				Details: JSONResp{"candidates": candidates},
+			}
+		}
+		if len(taxa) > 0 {
+			return taxa[0], nil
+		}
+	}
+	return Taxon{}, newSvcError(ErrNotFound, "No taxon with Wikidata QID '%s'", qid)
+}
+
+// findByPage returns the taxon of the lowest rank with the page URL.
+func (svc *TaxonSvc) findByPage(page WikipediaPage) ([]Taxon, error) {
+	for i := len(svc.ranks) - 1; i >= 0; i-- {
+		taxa, err := svc.repo.FindByUrls(svc.ranks[i], page.Urls())
+		if err != nil || len(taxa) > 0 {
+			return taxa, err
+		}
 	}
-	cursor, err := svc.db.Query(nil, query, bindVars)
+	return []Taxon{}, nil
+}
+
+// GetByUrl returns the taxon scraped from a Wikipedia page, given by URL or
+// bare title. If no taxon has the page URL and redirect resolution is enabled,
+// the page is looked up again under the title it redirects to.
+func (svc *TaxonSvc) GetByUrl(input string) (Taxon, error) {
+	page, err := ParseWikipediaPage(input, svc.wikipediaSite)
+	if err != nil {
+		return Taxon{}, err
+	}
+	taxa, err := svc.findByPage(page)
 	if err != nil {
-		return taxa, err
+		return Taxon{}, err
+	}
+	if len(taxa) == 0 && svc.redirects != nil {
+		target, err := svc.redirects.ResolveRedirect(page)
+		if err != nil {
+			return Taxon{}, err
+		}
+		if target != page {
+			if taxa, err = svc.findByPage(target); err != nil {
+				return Taxon{}, err
+			}
+		}
 	}
-	defer cursor.Close()
-	for {
-		var taxon Taxon
-		_, err := cursor.ReadDocument(nil, &taxon)
-		if arango.IsNoMoreDocuments(err) {
+	if len(taxa) == 0 {
+		return Taxon{}, newSvcError(ErrNotFound, "No taxon with Wikipedia page '%s'", page.Title)
+	}
+	return taxa[0], nil
+}
+
+// Search returns up to limit taxa whose name contains the query, skipping the
+// first offset. If rank is empty all ranks are searched, from the root down.
+func (svc *TaxonSvc) Search(query string, rank string, offset int, limit int) ([]Taxon, error) {
+	taxa := []Taxon{}
+	if query == "" {
+		return taxa, newSvcError(ErrInvalid, "Missing search query")
+	}
+	ranks := svc.ranks
+	if rank != "" {
+		if err := svc.checkRank(rank); err != nil {
+			return taxa, err
+		}
+		ranks = []string{rank}
+	}
+	// The skipped matches are read too, as they may span several ranks.
+	end := offset + limit
+	for _, r := range ranks {
+		if len(taxa) >= end {
 			break
-		} else if err != nil {
+		}
+		found, err := svc.repo.Search(r, query, end-len(taxa))
+		if err != nil {
 			return taxa, err
 		}
-		taxa = append(taxa, taxon)
+		taxa = append(taxa, found...)
+	}
+	return page(taxa, offset, limit), nil
+}
+
+// page returns up to limit taxa after the first offset.
+func page(taxa []Taxon, offset int, limit int) []Taxon {
+	if offset > len(taxa) {
+		offset = len(taxa)
+	}
+	end := offset + limit
+	if end > len(taxa) {
+		end = len(taxa)
 	}
-	return taxa, nil
+	return taxa[offset:end]
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestTaxonKey(t *testing.T) {
+	// Taxon IDs must be keyed exactly as the scraper keys them, so both copies
+	// of TaxonKey are tested against the scraper's cases.
+	data, err := os.ReadFile("../../wiki-scraper/testdata/taxon_keys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := TaxonKey(tt.Name); got != tt.Key {
+			t.Errorf("TaxonKey(%q): expected %q, got %q", tt.Name, tt.Key, got)
+		}
+	}
+}
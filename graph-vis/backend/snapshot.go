@@ -0,0 +1,231 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// snapshotHistory caches the taxonomy of recent snapshots in memory. The
+// taxa valid in a completed snapshot never change, so entries need no
+// invalidation.
+type snapshotHistory struct {
+	mu      sync.Mutex
+	max     int
+	seqs    []int
+	entries map[int]*snapshotEntry
+}
+
+// snapshotEntry is a snapshot being loaded or loaded. done is closed once
+// repo or err is set.
+type snapshotEntry struct {
+	done chan struct{}
+	repo *MemoryTaxonRepo
+	err  error
+}
+
+func newSnapshotHistory(max int) *snapshotHistory {
+	return &snapshotHistory{max: max, entries: map[int]*snapshotEntry{}}
+}
+
+// repo returns an in-memory repo holding the taxa of the snapshot, loading
+// them if the snapshot is not cached. Snapshots are loaded outside the lock,
+// and concurrent requests for a snapshot being loaded wait for that load. The
+// oldest loaded snapshot is evicted when the cache is full.
+func (h *snapshotHistory) repo(seq int, load func(int) ([]TaxonVersion, error)) (*MemoryTaxonRepo, error) {
+	h.mu.Lock()
+	if entry, ok := h.entries[seq]; ok {
+		h.mu.Unlock()
+		<-entry.done
+		return entry.repo, entry.err
+	}
+	entry := &snapshotEntry{done: make(chan struct{})}
+	h.entries[seq] = entry
+	h.mu.Unlock()
+
+	entry.repo, entry.err = loadSnapshotRepo(seq, load)
+	h.mu.Lock()
+	if entry.err != nil || h.max == 0 {
+		// Failed loads are retried by the next request.
+		delete(h.entries, seq)
+	} else {
+		if len(h.seqs) == h.max {
+			delete(h.entries, h.seqs[0])
+			h.seqs = h.seqs[1:]
+		}
+		h.seqs = append(h.seqs, seq)
+	}
+	h.mu.Unlock()
+	close(entry.done)
+	return entry.repo, entry.err
+}
+
+// loadSnapshotRepo loads the taxa of a snapshot into an in-memory repo.
+func loadSnapshotRepo(seq int, load func(int) ([]TaxonVersion, error)) (*MemoryTaxonRepo, error) {
+	versions, err := load(seq)
+	if err != nil {
+		return nil, err
+	}
+	fixture := MemoryFixture{Taxa: []Taxon{}, Edges: []Edge{}}
+	for _, v := range versions {
+		fixture.Taxa = append(fixture.Taxa, versionTaxon(v))
+		if v.Parent != "" {
+			fixture.Edges = append(fixture.Edges, Edge{From: v.TaxonId, To: v.Parent})
+		}
+	}
+	return NewMemoryTaxonRepo(fixture), nil
+}
+
+// findSnapshotAsOf returns the snapshot with the given ID or, given an RFC
+// 3339 timestamp or a date, the latest snapshot completed by then.
+func findSnapshotAsOf(snapshots []Snapshot, asOf string) (Snapshot, error) {
+	for _, snapshot := range snapshots {
+		if snapshot.Id == asOf {
+			return snapshot, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", asOf); err != nil {
+			return Snapshot{}, newSvcError(ErrInvalid, "Invalid asOf '%s', expected a snapshot ID, timestamp or date", asOf)
+		}
+	}
+	var found *Snapshot
+	for i := range snapshots {
+		if !snapshots[i].FinishedAt.After(t) {
+			found = &snapshots[i]
+		}
+	}
+	if found == nil {
+		return Snapshot{}, newSvcError(ErrNotFound, "No snapshot as of '%s'", asOf)
+	}
+	return *found, nil
+}
+
+// AsOf returns a service answering against the taxonomy as recorded in the
+// snapshot given by ID or the latest snapshot completed at the given time.
+func (svc *TaxonSvc) AsOf(asOf string) (*TaxonSvc, error) {
+	snapshots, err := svc.repo.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := findSnapshotAsOf(snapshots, asOf)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := svc.history.repo(snapshot.Seq, svc.repo.GetSnapshotTaxa)
+	if err != nil {
+		return nil, err
+	}
+	return &TaxonSvc{
+		repo:          repo,
+		ranks:         svc.ranks,
+		wikipediaSite: svc.wikipediaSite,
+		redirects:     svc.redirects,
+		history:       svc.history,
+		readOnly:      true,
+		snapshot:      snapshot,
+	}, nil
+}
+
+// Snapshots returns the completed crawl snapshots in order.
+func (svc *TaxonSvc) Snapshots() ([]Snapshot, error) {
+	return svc.repo.ListSnapshots()
+}
+
+// findSnapshot returns the snapshot with the given ID. If id is empty the
+// snapshot at index fallback is returned.
+func findSnapshot(snapshots []Snapshot, id string, fallback int) (Snapshot, error) {
+	if id == "" {
+		if fallback < 0 || fallback >= len(snapshots) {
+			return Snapshot{}, newSvcError(ErrNotFound, "Not enough snapshots to compare")
+		}
+		return snapshots[fallback], nil
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Id == id {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, newSvcError(ErrNotFound, "Snapshot '%s' not found", id)
+}
+
+// DiffSnapshots lists the taxa added, removed, renamed and moved between two
+// snapshots. If to is empty the latest snapshot is used and if from is empty
+// the one before to.
+func (svc *TaxonSvc) DiffSnapshots(from string, to string) (SnapshotDiff, error) {
+	diff := SnapshotDiff{}
+	snapshots, err := svc.repo.ListSnapshots()
+	if err != nil {
+		return diff, err
+	}
+	if diff.To, err = findSnapshot(snapshots, to, len(snapshots)-1); err != nil {
+		return diff, err
+	}
+	// Snapshots are numbered from 1, so the one before has index Seq-2.
+	if diff.From, err = findSnapshot(snapshots, from, diff.To.Seq-2); err != nil {
+		return diff, err
+	}
+	fromTaxa, err := svc.repo.GetSnapshotTaxa(diff.From.Seq)
+	if err != nil {
+		return diff, err
+	}
+	toTaxa, err := svc.repo.GetSnapshotTaxa(diff.To.Seq)
+	if err != nil {
+		return diff, err
+	}
+	diffTaxonVersions(&diff, fromTaxa, toTaxa)
+	return diff, nil
+}
+
+// diffTaxonVersions compares two snapshots' taxa by ID. Taxon IDs derive from
+// names, so a removed and an added taxon with the same Wikidata QID or page
+// are reported as a rename.
+func diffTaxonVersions(diff *SnapshotDiff, fromTaxa []TaxonVersion, toTaxa []TaxonVersion) {
+	diff.Added, diff.Removed = []TaxonVersion{}, []TaxonVersion{}
+	diff.Renamed, diff.Moved = []TaxonChange{}, []TaxonChange{}
+
+	from := map[string]TaxonVersion{}
+	for _, v := range fromTaxa {
+		from[v.TaxonId] = v
+	}
+	to := map[string]bool{}
+	for _, v := range toTaxa {
+		to[v.TaxonId] = true
+	}
+	removed := map[string]TaxonVersion{}
+	removedBy := map[string]string{}
+	for _, v := range fromTaxa {
+		if to[v.TaxonId] {
+			continue
+		}
+		removed[v.TaxonId] = v
+		if v.WikidataId != "" {
+			removedBy["qid:"+v.WikidataId] = v.TaxonId
+		}
+		removedBy["url:"+v.Url] = v.TaxonId
+	}
+
+	for _, v := range toTaxa {
+		if prev, ok := from[v.TaxonId]; ok {
+			if prev.Parent != v.Parent {
+				diff.Moved = append(diff.Moved, TaxonChange{From: prev, To: v})
+			}
+			continue
+		}
+		id, ok := removedBy["qid:"+v.WikidataId]
+		if v.WikidataId == "" || !ok {
+			id, ok = removedBy["url:"+v.Url]
+		}
+		if prev, stillRemoved := removed[id]; ok && stillRemoved {
+			diff.Renamed = append(diff.Renamed, TaxonChange{From: prev, To: v})
+			delete(removed, id)
+			continue
+		}
+		diff.Added = append(diff.Added, v)
+	}
+	for _, v := range fromTaxa {
+		if _, ok := removed[v.TaxonId]; ok {
+			diff.Removed = append(diff.Removed, v)
+		}
+	}
+}
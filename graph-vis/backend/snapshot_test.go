@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotHistory(t *testing.T) {
+	h := newSnapshotHistory(1)
+	var loads atomic.Int32
+	release := make(chan struct{})
+	slow := func(seq int) ([]TaxonVersion, error) {
+		loads.Add(1)
+		<-release
+		return []TaxonVersion{{TaxonId: "kingdom/1", Rank: "Kingdom", Name: "Animalia"}}, nil
+	}
+
+	// Concurrent requests for a snapshot share one load.
+	var wg sync.WaitGroup
+	repos := make([]*MemoryTaxonRepo, 3)
+	for i := range repos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repos[i], _ = h.repo(1, slow)
+		}(i)
+	}
+	// Another snapshot is loaded while the first is.
+	done := make(chan error)
+	go func() {
+		_, err := h.repo(2, func(int) ([]TaxonVersion, error) { return nil, nil })
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected snapshot 2 to load, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected snapshot 2 to load while snapshot 1 is loading")
+	}
+	close(release)
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected one load, got %d", n)
+	}
+	if repos[0] == nil || repos[0] != repos[1] || repos[1] != repos[2] {
+		t.Errorf("Expected the requests to share the loaded repo")
+	}
+
+	// Snapshot 2 was evicted by snapshot 1, and failed loads are not cached.
+	failing := func(int) ([]TaxonVersion, error) { return nil, errors.New("unavailable") }
+	if _, err := h.repo(2, failing); err == nil {
+		t.Errorf("Expected snapshot 2 to be loaded again")
+	}
+	if _, err := h.repo(1, failing); err != nil {
+		t.Errorf("Expected snapshot 1 to be cached, got %v", err)
+	}
+	if _, err := h.repo(2, slow); err != nil {
+		t.Errorf("Expected the failed load to be retried, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	http "net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// certReloader serves a certificate loaded from PEM files, reloading it when
+// the files change so that renewed certificates are used without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadIfChanged loads the certificate if either file was modified since it
+// was last loaded. The current certificate is kept if loading fails, e.g.
+// while the files are half written.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	modTime := time.Time{}
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch checks the certificate files for changes at the given interval.
+func (r *certReloader) watch(interval time.Duration, logger echo.Logger) {
+	for range time.Tick(interval) {
+		reloaded, err := r.reloadIfChanged()
+		if err != nil {
+			logger.Errorf("Failed to reload TLS certificate: %v", err)
+		} else if reloaded {
+			logger.Infof("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+}
+
+// newTLSConfig returns the server TLS config, offering HTTP/2.
+func newTLSConfig(certs *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// redirectToHTTPS redirects requests to the same host and path on the HTTPS
+// server listening on tlsAddr.
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port == "" || port == "443" {
+			host = strings.TrimSuffix(net.JoinHostPort(host, "443"), ":443")
+		} else {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	http "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 with the given
+// common name and returns it.
+func writeTestCert(t *testing.T, certFile string, keyFile string, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeTestCert(t, certFile, keyFile, "first")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		TLSConfig: newTLSConfig(certs),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.Proto))
+		}),
+	}
+	go server.Serve(tls.NewListener(listener, server.TLSConfig))
+	defer server.Close()
+
+	get := func(trusted *x509.Certificate) (*http.Response, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(trusted)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		}}
+		return client.Get("https://" + listener.Addr().String() + "/")
+	}
+	resp, err := get(first)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+	}
+
+	second := writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if reloaded, err := certs.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("Expected certificate to be reloaded, got %v, %v", reloaded, err)
+	}
+	if reloaded, _ := certs.reloadIfChanged(); reloaded {
+		t.Errorf("Expected unchanged certificate not to be reloaded")
+	}
+	resp, err = get(second)
+	if err != nil {
+		t.Fatalf("Expected reloaded certificate to be served: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		tlsAddr string
+		host    string
+		target  string
+		want    string
+	}{
+		{":443", "example.org", "/api/v1/ranks?asOf=2026-09-15", "https://example.org/api/v1/ranks?asOf=2026-09-15"},
+		{":443", "example.org:80", "/", "https://example.org/"},
+		{":8443", "example.org:8080", "/graphql", "https://example.org:8443/graphql"},
+		{":443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+		req.Host = tt.host
+		redirectToHTTPS(tt.tlsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s: expected redirect to %s, got %d %s", tt.host, tt.target, tt.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	router := newTestRouterWith(t, func(cfg *Config) { cfg.HSTSMaxAge = 365 * 24 * time.Hour })
+	for proto, want := range map[string]string{"https": "max-age=31536000", "": ""} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/ranks", nil)
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		router.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Strict-Transport-Security"); got != want {
+			t.Errorf("X-Forwarded-Proto %q: expected HSTS %q, got %q", proto, want, got)
+		}
+	}
+}
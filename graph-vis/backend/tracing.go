@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	http "net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewTracerProvider returns a tracer provider exporting spans as configured
+// by TRACES_EXPORTER, or nil if tracing is disabled.
+func NewTracerProvider(cfg Config) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracesExporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OTLPEndpoint, "/")+"/v1/traces"),
+			otlptracehttp.WithTimeout(10*time.Second),
+		)
+	default:
+		err = fmt.Errorf("unknown traces exporter '%s'", cfg.TracesExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracesSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("graph-vis-backend"))),
+	), nil
+}
+
+// Tracing middleware starts a server span for each request, continuing the
+// trace of a W3C traceparent header. The span is in the request context and
+// records the request ID.
+func Tracing() echo.MiddlewareFunc {
+	tracer := otel.Tracer("backend")
+	propagator := propagation.TraceContext{}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isProbe(c) {
+				return next(c)
+			}
+			req := c.Request()
+			ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			name := strings.TrimSpace(req.Method + " " + c.Path())
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(req.Method),
+					semconv.HTTPRoute(c.Path()),
+					semconv.URLPath(req.URL.Path),
+					attribute.String("request.id", requestID(c)),
+				),
+			)
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			if err != nil {
+				// Write the error response now to record its status.
+				c.Error(err)
+			}
+			status := c.Response().Status
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+				if err != nil {
+					span.RecordError(err)
+				}
+			}
+			return err
+		}
+	}
+}
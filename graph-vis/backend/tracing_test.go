@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracing(t *testing.T) {
+	// Spans are exported to a fake collector.
+	received := make(chan *tracepb.ResourceSpans, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/traces" || r.Header.Get(echo.HeaderContentType) != "application/x-protobuf" {
+			t.Errorf("Unexpected export request %s %s", r.Method, r.URL)
+		}
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil || len(req.ResourceSpans) != 1 {
+			t.Errorf("Invalid export request body")
+			received <- &tracepb.ResourceSpans{}
+			return
+		}
+		received <- req.ResourceSpans[0]
+	}))
+	defer collector.Close()
+	provider, err := NewTracerProvider(Config{TracesExporter: "otlp", OTLPEndpoint: collector.URL, TracesSampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	router := newTestRouter(t)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/taxon/species/9", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(rec, req)
+	requestID := rec.Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		t.Errorf("Expected a request ID")
+	}
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to export spans: %v", err)
+	}
+
+	rs := <-received
+	if len(rs.ScopeSpans) != 1 || len(rs.ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("Expected one span, got %v", rs)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if span.Name != "GET /api/v1/taxon/:rank/:id" || fmt.Sprintf("%x", span.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Unexpected span %v", span)
+	}
+	attrs := map[string]interface{}{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value.GetStringValue()
+		if _, ok := attr.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+			attrs[attr.Key] = attr.Value.GetIntValue()
+		}
+	}
+	if attrs["request.id"] != requestID || attrs["http.response.status_code"] != int64(200) {
+		t.Errorf("Unexpected span attributes %v", attrs)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	http "net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WikipediaPage identifies a Wikipedia page by site and title.
+type WikipediaPage struct {
+	// Site is the scheme and host, e.g. "https://en.wikipedia.org".
+	Site string
+	// Title is the decoded page title with spaces, e.g. "Felis catus".
+	Title string
+}
+
+// ParseWikipediaPage parses a Wikipedia page URL or a bare page title. Mobile
+// domains, `index.php?title=` URLs, fragments and URL-encoding are handled.
+// Bare titles are taken to be on the default site.
+func ParseWikipediaPage(input string, defaultSite string) (WikipediaPage, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return WikipediaPage{}, newSvcError(ErrInvalid, "Missing Wikipedia URL or title")
+	}
+	if !strings.Contains(input, "://") && !strings.Contains(input, "wikipedia.org/") {
+		title, err := url.PathUnescape(input)
+		if err != nil {
+			title = input
+		}
+		return newWikipediaPage(defaultSite, title)
+	}
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return WikipediaPage{}, newSvcError(ErrInvalid, "Invalid Wikipedia URL '%s'", input)
+	}
+	host := strings.ToLower(u.Hostname())
+	if !strings.HasSuffix(host, ".wikipedia.org") {
+		return WikipediaPage{}, newSvcError(ErrInvalid, "Not a Wikipedia URL '%s'", input)
+	}
+	host = strings.Replace(host, ".m.wikipedia.org", ".wikipedia.org", 1)
+
+	var title string
+	switch {
+	case strings.HasPrefix(u.Path, "/wiki/"):
+		title = strings.TrimPrefix(u.Path, "/wiki/")
+	case u.Query().Get("title") != "":
+		title = u.Query().Get("title")
+	default:
+		return WikipediaPage{}, newSvcError(ErrInvalid, "No page title in Wikipedia URL '%s'", input)
+	}
+	return newWikipediaPage("https://"+host, title)
+}
+
+// newWikipediaPage returns the page with the normalized title, or an error if
+// the title is empty, e.g. for an input of only underscores.
+func newWikipediaPage(site string, title string) (WikipediaPage, error) {
+	title = normalizeTitle(title)
+	if title == "" {
+		return WikipediaPage{}, newSvcError(ErrInvalid, "Missing Wikipedia page title")
+	}
+	return WikipediaPage{Site: site, Title: title}, nil
+}
+
+// normalizeTitle converts underscores to spaces and capitalises the first
+// letter, as Wikipedia does.
+func normalizeTitle(title string) string {
+	title = strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " ")
+	if title == "" {
+		return ""
+	}
+	r, size := utf8.DecodeRuneInString(title)
+	return string(unicode.ToUpper(r)) + title[size:]
+}
+
+// wikipediaEscape percent-encodes a title as Wikipedia does in page links.
+func wikipediaEscape(title string) string {
+	var b strings.Builder
+	for _, c := range []byte(strings.ReplaceAll(title, " ", "_")) {
+		if c < utf8.RuneSelf && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("-_.~;:@$!*(),/", c) >= 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Urls returns the forms in which the scraper may have stored the page URL.
+func (page WikipediaPage) Urls() []string {
+	path := strings.ReplaceAll(page.Title, " ", "_")
+	urls := []string{page.Site + "/wiki/" + wikipediaEscape(page.Title)}
+	if escaped := (&url.URL{Path: path}).EscapedPath(); escaped != wikipediaEscape(page.Title) {
+		urls = append(urls, page.Site+"/wiki/"+escaped)
+	}
+	if !strings.Contains(urls[0], path) {
+		urls = append(urls, page.Site+"/wiki/"+path)
+	}
+	return urls
+}
+
+// RedirectResolver resolves Wikipedia redirects to the target page.
+type RedirectResolver interface {
+	ResolveRedirect(page WikipediaPage) (WikipediaPage, error)
+}
+
+// WikipediaRedirectResolver resolves redirects with the MediaWiki API.
+type WikipediaRedirectResolver struct {
+	client *http.Client
+}
+
+func NewWikipediaRedirectResolver(timeout time.Duration) *WikipediaRedirectResolver {
+	return &WikipediaRedirectResolver{client: &http.Client{Timeout: timeout}}
+}
+
+func (r *WikipediaRedirectResolver) ResolveRedirect(page WikipediaPage) (WikipediaPage, error) {
+	query := url.Values{
+		"action":    {"query"},
+		"format":    {"json"},
+		"redirects": {"1"},
+		"titles":    {page.Title},
+	}
+	resp, err := r.client.Get(page.Site + "/w/api.php?" + query.Encode())
+	if err != nil {
+		return page, &SvcError{Kind: ErrUnavailable, Message: "Wikipedia unavailable", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return page, newSvcError(ErrUnavailable, "Wikipedia returned status %d", resp.StatusCode)
+	}
+	result := struct {
+		Query struct {
+			Redirects []struct {
+				To string `json:"to"`
+			} `json:"redirects"`
+		} `json:"query"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return page, &SvcError{Kind: ErrUnavailable, Message: "Invalid response from Wikipedia", Err: err}
+	}
+	if n := len(result.Query.Redirects); n > 0 {
+		page.Title = normalizeTitle(result.Query.Redirects[n-1].To)
+	}
+	return page, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseWikipediaPage(t *testing.T) {
+	tests := []struct {
+		input string
+		want  WikipediaPage
+	}{
+		{"https://en.m.wikipedia.org/wiki/Harbor_seal#Taxonomy", WikipediaPage{"https://en.wikipedia.org", "Harbor seal"}},
+		{"en.wikipedia.org/w/index.php?title=Harbor_seal&oldid=1", WikipediaPage{"https://en.wikipedia.org", "Harbor seal"}},
+		{"harbor  seal", WikipediaPage{"https://de.wikipedia.org", "Harbor seal"}},
+	}
+	for _, tt := range tests {
+		if page, err := ParseWikipediaPage(tt.input, "https://de.wikipedia.org"); err != nil || page != tt.want {
+			t.Errorf("ParseWikipediaPage(%q): expected %+v, got %+v %v", tt.input, tt.want, page, err)
+		}
+	}
+
+	// Inputs without a title are rejected rather than looked up.
+	for _, input := range []string{"", "_", "%20", "https://en.wikipedia.org/wiki/", "https://en.wikipedia.org/wiki/__", "https://example.org/wiki/Cat"} {
+		var svcErr *SvcError
+		if page, err := ParseWikipediaPage(input, "https://en.wikipedia.org"); !errors.As(err, &svcErr) || svcErr.Kind != ErrInvalid {
+			t.Errorf("ParseWikipediaPage(%q): expected an invalid input error, got %+v %v", input, page, err)
+		}
+	}
+}
+
+func TestWikipediaPageUrls(t *testing.T) {
+	page, err := ParseWikipediaPage("https://fr.m.wikipedia.org/wiki/F%C3%A9lin%27s", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://fr.wikipedia.org/wiki/F%C3%A9lin%27s",
+		"https://fr.wikipedia.org/wiki/Félin's",
+	}
+	if !reflect.DeepEqual(page.Urls(), want) {
+		t.Errorf("Unexpected URLs %v", page.Urls())
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChecklistTaxon is a row of an external checklist such as the GBIF
+// Backbone or the Catalogue of Life.
+type ChecklistTaxon struct {
+	Id     string
+	Name   string
+	Rank   string
+	Status string
+	// Ranks holds the higher classification keyed by lower case rank.
+	Ranks map[string]string
+}
+
+// dwcaMeta is the subset of a Darwin Core Archive meta.xml needed to read
+// the core file.
+type dwcaMeta struct {
+	Core struct {
+		FieldsTerminatedBy string `xml:"fieldsTerminatedBy,attr"`
+		IgnoreHeaderLines  int    `xml:"ignoreHeaderLines,attr"`
+		Location           string `xml:"files>location"`
+		Id                 struct {
+			Index int `xml:"index,attr"`
+		} `xml:"id"`
+		Fields []struct {
+			Index int    `xml:"index,attr"`
+			Term  string `xml:"term,attr"`
+		} `xml:"field"`
+	} `xml:"core"`
+}
+
+// checklistArchive opens files of a Darwin Core Archive given as a zip file
+// or an unpacked directory.
+type checklistArchive struct {
+	zip *zip.ReadCloser
+	dir string
+}
+
+func openChecklistArchive(path string) (*checklistArchive, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &checklistArchive{dir: path}, nil
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &checklistArchive{zip: zr}, nil
+}
+
+func (a *checklistArchive) Open(name string) (io.ReadCloser, error) {
+	if a.zip == nil {
+		return os.Open(filepath.Join(a.dir, name))
+	}
+	return a.zip.Open(name)
+}
+
+func (a *checklistArchive) Close() error {
+	if a.zip == nil {
+		return nil
+	}
+	return a.zip.Close()
+}
+
+// termName returns the local name of a Darwin Core term URI.
+func termName(term string) string {
+	return term[strings.LastIndexAny(term, "/#")+1:]
+}
+
+// canonicalName strips the authorship from a scientific name.
+func canonicalName(name string, authorship string) string {
+	if authorship != "" {
+		name = strings.TrimSuffix(name, authorship)
+	}
+	return strings.TrimSpace(name)
+}
+
+// ReadChecklist calls fn for each taxon in the core file of a Darwin Core
+// Archive checklist, streaming rows so that large checklists such as the
+// GBIF Backbone need not fit in memory.
+func ReadChecklist(path string, fn func(ChecklistTaxon) error) error {
+	archive, err := openChecklistArchive(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	metaFile, err := archive.Open("meta.xml")
+	if err != nil {
+		return fmt.Errorf("failed to open meta.xml: %w", err)
+	}
+	meta := dwcaMeta{}
+	err = xml.NewDecoder(metaFile).Decode(&meta)
+	metaFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to parse meta.xml: %w", err)
+	}
+	sep := strings.NewReplacer(`\t`, "\t").Replace(meta.Core.FieldsTerminatedBy)
+	if sep == "" {
+		sep = "\t"
+	}
+	columns := map[string]int{}
+	for _, field := range meta.Core.Fields {
+		columns[termName(field.Term)] = field.Index
+	}
+	if _, ok := columns["taxonID"]; !ok {
+		columns["taxonID"] = meta.Core.Id.Index
+	}
+	if _, ok := columns["scientificName"]; !ok {
+		return errors.New("checklist has no scientificName column")
+	}
+
+	core, err := archive.Open(meta.Core.Location)
+	if err != nil {
+		return fmt.Errorf("failed to open core file: %w", err)
+	}
+	defer core.Close()
+	scanner := bufio.NewScanner(core)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		if line < meta.Core.IgnoreHeaderLines {
+			continue
+		}
+		values := strings.Split(scanner.Text(), sep)
+		get := func(term string) string {
+			if i, ok := columns[term]; ok && i < len(values) {
+				return strings.TrimSpace(values[i])
+			}
+			return ""
+		}
+		name := get("canonicalName")
+		if name == "" {
+			name = canonicalName(get("scientificName"), get("scientificNameAuthorship"))
+		}
+		taxon := ChecklistTaxon{
+			Id:     get("taxonID"),
+			Name:   name,
+			Rank:   strings.ToLower(get("taxonRank")),
+			Status: strings.ToLower(get("taxonomicStatus")),
+			Ranks:  map[string]string{},
+		}
+		for _, rank := range TaxonRanks[:len(TaxonRanks)-1] {
+			if value := get(rank); value != "" {
+				taxon.Ranks[rank] = value
+			}
+		}
+		if err := fn(taxon); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
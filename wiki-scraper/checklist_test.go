@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestDwCA writes a core file and meta.xml as ExportDwCA lays them out.
+func writeTestDwCA(t *testing.T, dir string, rows ...TaxonLineage) {
+	t.Helper()
+	header := []string{}
+	for _, column := range dwcaColumns {
+		header = append(header, termName(column))
+	}
+	lines := []string{strings.Join(header, "\t")}
+	for _, row := range rows {
+		lines = append(lines, strings.Join(dwcaFields(row), "\t"))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "taxon.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var meta strings.Builder
+	if err := writeDwCAMeta(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.xml"), []byte(meta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// zipTestDwCA packs the files of an unpacked archive into a zip file.
+func zipTestDwCA(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dwca.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"taxon.txt", "meta.xml"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadChecklist(t *testing.T) {
+	felis := testLineage("genus/felis", "family/felidae",
+		"Kingdom", "Animalia", "Family", "Felidae", "Genus", "Felis")
+	dir := t.TempDir()
+	writeTestDwCA(t, dir, felis, testCat)
+
+	want := []ChecklistTaxon{
+		{
+			Id:    "genus/felis",
+			Name:  "Felis",
+			Rank:  "genus",
+			Ranks: map[string]string{"kingdom": "Animalia", "family": "Felidae", "genus": "Felis"},
+		},
+		{
+			Id:   "species/felis-catus",
+			Name: "Felis catus",
+			Rank: "species",
+			Ranks: map[string]string{
+				"kingdom": "Animalia", "phylum": "Chordata", "class": "Mammalia",
+				"order": "Carnivora", "family": "Felidae", "genus": "Felis",
+			},
+		},
+	}
+	// The archive written by the DwC-A export reads back the same unpacked
+	// and zipped.
+	for _, path := range []string{dir, zipTestDwCA(t, dir)} {
+		got := []ChecklistTaxon{}
+		err := ReadChecklist(path, func(ct ChecklistTaxon) error {
+			got = append(got, ct)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to read back as %+v, got %+v", path, want, got)
+		}
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	if got := canonicalName("Felis catus Linnaeus, 1758", "Linnaeus, 1758"); got != "Felis catus" {
+		t.Errorf("Expected the authorship to be stripped, got %q", got)
+	}
+	if got := canonicalName(" Felis catus ", ""); got != "Felis catus" {
+		t.Errorf("Unexpected name %q", got)
+	}
+	if got := termName("http://rs.tdwg.org/dwc/terms/taxonID"); got != "taxonID" {
+		t.Errorf("Unexpected term name %q", got)
+	}
+}
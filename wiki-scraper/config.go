@@ -12,8 +12,18 @@ const (
 	FamilyCollName  = "family"
 	GenusCollName   = "genus"
 	SpeciesCollName = "species"
+
+	CrossRefCollName     = "crossrefs"
+	SnapshotCollName     = "snapshots"
+	TaxonVersionCollName = "taxonVersions"
+	DeletedTaxonCollName = "deletedTaxa"
+	GraphVersionCollName = "graphVersions"
+	AuditCollName        = "audit"
 )
 
+// TaxonRanks lists the taxonomic ranks in order from the root of the hierarchy.
+var TaxonRanks = []string{KingdomCollName, PhylumCollName, ClassCollName, OrderCollName, FamilyCollName, GenusCollName, SpeciesCollName}
+
 // Config stores the app configuration.
 type Config struct {
 	CrawlerSeedURL             string `mapstructure:"CRAWLER_SEED_URL"`
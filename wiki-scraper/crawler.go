@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
@@ -32,13 +33,37 @@ func createTaxonomicLevelFromSelection(s *goquery.Selection, sUrl url.URL) (Taxo
 	return Taxon{Rank: taxLvlStrs[0], Name: taxLvlStrs[1], Url: url}, nil
 }
 
-func buildCrawlerOnHTML(c *colly.Collector, config Config, taxLvlColls map[string]arango.Collection) func(*colly.HTMLElement) {
+var wikidataQIDRegexp = regexp.MustCompile(`/(Q[1-9][0-9]*)$`)
+
+// wikidataQID returns the QID of the "Wikidata item" link of the page
+// containing the selection, or an empty string if there is none.
+func wikidataQID(s *goquery.Selection) string {
+	href := s.ParentsFiltered("html").Find("#t-wikibase a[href]").First().AttrOr("href", "")
+	match := wikidataQIDRegexp.FindStringSubmatch(href)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func buildCrawlerOnHTML(c *colly.Collector, config Config, taxLvlColls map[string]arango.Collection, recorder *CrawlRecorder) func(*colly.HTMLElement) {
+	// Wikidata QIDs of visited taxon pages by url. Taxa are often stored
+	// before their own page is visited, so QIDs are applied in both orders.
+	var wikidataIds sync.Map
+
 	return func(e *colly.HTMLElement) {
 		infoboxBiota := e.DOM.Find("table.infobox.biota")
 		if infoboxBiota.Length() != 1 {
 			return // No table.infobox.biota => this search path is a dead end.
 		}
 
+		pageUrl := e.Request.URL.String()
+		if qid := wikidataQID(e.DOM); qid != "" {
+			if _, seen := wikidataIds.LoadOrStore(pageUrl, qid); !seen {
+				setWikidataId(pageUrl, qid, taxLvlColls)
+			}
+		}
+
 		species := infoboxBiota.Find("tr:contains('Species')")
 		if species.Length() != 0 {
 			taxLvlSel := infoboxBiota.Find("tr:contains('Kingdom')")
@@ -54,9 +79,14 @@ func buildCrawlerOnHTML(c *colly.Collector, config Config, taxLvlColls map[strin
 				}
 				taxLvls = append(taxLvls, t)
 				if t.Rank == "Species" {
-					taxLvls[len(taxLvls)-1].Url = e.Request.URL.String()
+					taxLvls[len(taxLvls)-1].Url = pageUrl
+					for i := range taxLvls {
+						if qid, ok := wikidataIds.Load(taxLvls[i].Url); ok {
+							taxLvls[i].WikidataId = qid.(string)
+						}
+					}
 					fmt.Printf("Processing: %s\nGot: %v\n", e.Request.URL, taxLvls)
-					processTaxon(taxLvls, taxLvlColls)
+					processTaxon(taxLvls, collectionStore(taxLvlColls), recorder)
 					// Species is a leaf in the tree. Terminate the search here.
 					return
 				}
@@ -76,7 +106,7 @@ func buildCrawlerOnHTML(c *colly.Collector, config Config, taxLvlColls map[strin
 
 // CreateCollyCrawler creates a Colly crawler for extracting taxonomic data
 // from Wikipedia animal species pages.
-func CreateCollyCrawler(config Config, taxLvlColls map[string]arango.Collection) *colly.Collector {
+func CreateCollyCrawler(config Config, taxLvlColls map[string]arango.Collection, recorder *CrawlRecorder) *colly.Collector {
 	c := colly.NewCollector(
 		colly.AllowedDomains(config.CrawlerAllowedDomain),
 		colly.URLFilters(
@@ -98,7 +128,7 @@ func CreateCollyCrawler(config Config, taxLvlColls map[string]arango.Collection)
 	// })
 
 	// HTML handler function.
-	c.OnHTML("#bodyContent", buildCrawlerOnHTML(c, config, taxLvlColls))
+	c.OnHTML("#bodyContent", buildCrawlerOnHTML(c, config, taxLvlColls, recorder))
 
 	return c
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+// Cross-reference match statuses.
+const (
+	MatchExact       = "exact"
+	MatchFuzzy       = "fuzzy"
+	MatchConflicting = "conflicting"
+	MatchMissing     = "missing"
+)
+
+// maxMatchDistance is the largest edit distance between names accepted as a
+// fuzzy match.
+const maxMatchDistance = 2
+
+// crossRefCandidate is a checklist taxon which may match a scraped taxon.
+type crossRefCandidate struct {
+	taxon     ChecklistTaxon
+	distance  int
+	conflicts []string
+}
+
+// better reports whether c is a better match than other. Accepted names are
+// preferred, then consistent placement, then closer names.
+func (c *crossRefCandidate) better(other *crossRefCandidate) bool {
+	if other == nil {
+		return true
+	}
+	if accepted, otherAccepted := c.taxon.Status == "accepted", other.taxon.Status == "accepted"; accepted != otherAccepted {
+		return accepted
+	}
+	if len(c.conflicts) != len(other.conflicts) {
+		return len(c.conflicts) < len(other.conflicts)
+	}
+	return c.distance < other.distance
+}
+
+// crossRefMatcher matches scraped taxa against a streamed checklist. Names
+// are indexed with their variants of up to maxMatchDistance deletions so that
+// candidates within that edit distance are found without comparing every pair.
+type crossRefMatcher struct {
+	taxa     []TaxonLineage
+	names    []string
+	variants map[string][]int
+	best     []*crossRefCandidate
+}
+
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(name, "†", ""))), " ")
+}
+
+// nameVariants returns the name and every distinct variant with up to the
+// given number of characters deleted. Two names within that edit distance
+// share at least one variant.
+func nameVariants(name string, deletions int) []string {
+	seen := map[string]bool{name: true}
+	variants := []string{name}
+	level := []string{name}
+	for d := 0; d < deletions; d++ {
+		next := []string{}
+		for _, v := range level {
+			runes := []rune(v)
+			for i := range runes {
+				variant := string(runes[:i]) + string(runes[i+1:])
+				if !seen[variant] {
+					seen[variant] = true
+					next = append(next, variant)
+				}
+			}
+		}
+		variants = append(variants, next...)
+		level = next
+	}
+	return variants
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func newCrossRefMatcher(taxa []TaxonLineage) *crossRefMatcher {
+	m := &crossRefMatcher{
+		taxa:     taxa,
+		names:    make([]string, len(taxa)),
+		variants: map[string][]int{},
+		best:     make([]*crossRefCandidate, len(taxa)),
+	}
+	for i, taxon := range taxa {
+		m.names[i] = normalizeName(taxon.ScientificName())
+		rank := strings.ToLower(taxon.Taxon().Rank)
+		for _, variant := range nameVariants(m.names[i], maxMatchDistance) {
+			m.variants[rank+"|"+variant] = append(m.variants[rank+"|"+variant], i)
+		}
+	}
+	return m
+}
+
+// conflicts returns the ranks above the taxon whose names differ between the
+// scraped lineage and the checklist classification.
+func (m *crossRefMatcher) conflicts(i int, ct ChecklistTaxon) []string {
+	conflicts := []string{}
+	ranks := m.taxa[i].Ranks()
+	for _, rank := range TaxonRanks {
+		if rank == strings.ToLower(m.taxa[i].Taxon().Rank) {
+			break
+		}
+		if ours, theirs := ranks[rank], ct.Ranks[rank]; ours != "" && theirs != "" && normalizeName(ours) != normalizeName(theirs) {
+			conflicts = append(conflicts, rank)
+		}
+	}
+	return conflicts
+}
+
+// Add considers a checklist taxon as a candidate for all matching taxa.
+func (m *crossRefMatcher) Add(ct ChecklistTaxon) {
+	name := normalizeName(ct.Name)
+	seen := map[int]bool{}
+	for _, variant := range nameVariants(name, maxMatchDistance) {
+		for _, i := range m.variants[ct.Rank+"|"+variant] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			distance := levenshtein(m.names[i], name)
+			if distance > maxMatchDistance {
+				continue
+			}
+			candidate := &crossRefCandidate{taxon: ct, distance: distance, conflicts: m.conflicts(i, ct)}
+			if candidate.better(m.best[i]) {
+				m.best[i] = candidate
+			}
+		}
+	}
+}
+
+// CrossRefs returns the match for every scraped taxon.
+func (m *crossRefMatcher) CrossRefs(source string, matchedAt time.Time) []CrossRef {
+	crossRefs := []CrossRef{}
+	for i, taxon := range m.taxa {
+		crossRef := CrossRef{
+			Key:       source + "-" + strings.ReplaceAll(taxon.Id, "/", "-"),
+			TaxonId:   taxon.Id,
+			Source:    source,
+			Status:    MatchMissing,
+			MatchedAt: matchedAt,
+		}
+		if best := m.best[i]; best != nil {
+			crossRef.ExternalId = best.taxon.Id
+			crossRef.ExternalName = best.taxon.Name
+			crossRef.ExternalStatus = best.taxon.Status
+			crossRef.Conflicts = best.conflicts
+			switch {
+			case len(best.conflicts) > 0:
+				crossRef.Status = MatchConflicting
+			case best.distance > 0:
+				crossRef.Status = MatchFuzzy
+			default:
+				crossRef.Status = MatchExact
+			}
+		}
+		crossRefs = append(crossRefs, crossRef)
+	}
+	return crossRefs
+}
+
+// MatchChecklist matches every scraped taxon against a checklist Darwin Core
+// Archive by name, rank and lineage.
+func MatchChecklist(config Config, db arango.Database, path string, source string) ([]CrossRef, error) {
+	taxa := []TaxonLineage{}
+	err := WalkTaxonLineages(config, db, func(row TaxonLineage) error {
+		taxa = append(taxa, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	matcher := newCrossRefMatcher(taxa)
+	err = ReadChecklist(path, func(ct ChecklistTaxon) error {
+		if kingdom := ct.Ranks[KingdomCollName]; kingdom != "" && !strings.EqualFold(kingdom, config.KingdomName) {
+			return nil
+		}
+		matcher.Add(ct)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matcher.CrossRefs(source, time.Now().UTC()), nil
+}
+
+func getOrCreateCrossRefCollection(db arango.Database) (arango.Collection, error) {
+	exists, err := db.CollectionExists(nil, CrossRefCollName)
+	if err != nil {
+		return nil, err
+	}
+	var coll arango.Collection
+	if exists {
+		coll, err = db.Collection(nil, CrossRefCollName)
+	} else {
+		coll, err = db.CreateCollection(nil, CrossRefCollName, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range [][]string{{"taxonId"}, {"source", "status"}} {
+		if _, _, err := coll.EnsurePersistentIndex(nil, fields, nil); err != nil {
+			return nil, err
+		}
+	}
+	return coll, nil
+}
+
+// StoreCrossRefs replaces the stored cross-references of the source with the
+// given ones. Those of taxa no longer matched, e.g. since deleted, are removed.
+func StoreCrossRefs(db arango.Database, source string, crossRefs []CrossRef) error {
+	coll, err := getOrCreateCrossRefCollection(db)
+	if err != nil {
+		return err
+	}
+	ctx := arango.WithOverwriteMode(context.Background(), arango.OverwriteModeReplace)
+	const batchSize = 1000
+	for start := 0; start < len(crossRefs); start += batchSize {
+		end := start + batchSize
+		if end > len(crossRefs) {
+			end = len(crossRefs)
+		}
+		_, errs, err := coll.CreateDocuments(ctx, crossRefs[start:end])
+		if err != nil {
+			return err
+		}
+		if err := errs.FirstNonNil(); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(crossRefs))
+	for _, crossRef := range crossRefs {
+		keys = append(keys, crossRef.Key)
+	}
+	query := `FOR c IN @@coll
+		FILTER c.source == @source AND c._key NOT IN @keys
+		REMOVE c IN @@coll`
+	bindVars := map[string]interface{}{"@coll": CrossRefCollName, "source": source, "keys": keys}
+	cursor, err := db.Query(nil, query, bindVars)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale cross-references of '%s': %w", source, err)
+	}
+	return cursor.Close()
+}
+
+func runCrossRef(config Config, args []string) {
+	flags := flag.NewFlagSet("crossref", flag.ExitOnError)
+	path := flags.String("checklist", "", "Path of a checklist Darwin Core Archive, zipped or unpacked.")
+	source := flags.String("source", "gbif", "Name of the checklist, e.g. 'gbif' or 'col'.")
+	flags.Parse(args)
+	if *path == "" {
+		log.Fatal("Missing -checklist")
+	}
+
+	_, taxLvlColls, err := GetOrCreateCollections(config)
+	if err != nil {
+		log.Fatalf("Failed to create collections: %v", err)
+	}
+	db := taxLvlColls[KingdomCollName].Database()
+
+	crossRefs, err := MatchChecklist(config, db, *path, *source)
+	if err != nil {
+		log.Fatalf("Failed to match checklist: %v", err)
+	}
+	if err := StoreCrossRefs(db, *source, crossRefs); err != nil {
+		log.Fatalf("Failed to store cross-references: %v", err)
+	}
+	counts := map[string]int{}
+	for _, crossRef := range crossRefs {
+		counts[crossRef.Status]++
+	}
+	fmt.Printf("Matched %d taxa against '%s': %d exact, %d fuzzy, %d conflicting, %d missing\n",
+		len(crossRefs), *source, counts[MatchExact], counts[MatchFuzzy], counts[MatchConflicting], counts[MatchMissing])
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"felis", "felis", 0},
+		{"felis", "feliss", 1},
+		{"felis", "fells", 1},
+		{"felis", "flies", 2},
+		{"", "abc", 3},
+		{"über", "uber", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q): expected %d, got %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+	// Names two substitutions apart share a variant with two deletions.
+	shared := false
+	falys := map[string]bool{}
+	for _, v := range nameVariants("falys", 2) {
+		falys[v] = true
+	}
+	for _, v := range nameVariants("felis", 2) {
+		shared = shared || falys[v]
+	}
+	if !shared {
+		t.Errorf("Expected the variants of %q and %q to overlap", "felis", "falys")
+	}
+	if got := normalizeName(" †Felis   Catus "); got != "felis catus" {
+		t.Errorf("Unexpected normalized name %q", got)
+	}
+}
+
+func TestCrossRefMatcher(t *testing.T) {
+	felis := testLineage("genus/felis", "family/felidae",
+		"Kingdom", "Animalia", "Family", "Felidae", "Genus", "Felis")
+	aotus := testLineage("genus/aotus", "family/aotidae",
+		"Kingdom", "Animalia", "Family", "Aotidae", "Genus", "Aotus")
+	leo := testLineage("species/panthera-leo", "genus/panthera",
+		"Kingdom", "Animalia", "Genus", "Panthera", "Species", "P. leo")
+	lynx := testLineage("species/lynx-lynx", "genus/lynx",
+		"Kingdom", "Animalia", "Genus", "Lynx", "Species", "L. lynx")
+	m := newCrossRefMatcher([]TaxonLineage{testCat, felis, aotus, leo, lynx})
+
+	for _, ct := range []ChecklistTaxon{
+		// The accepted name wins over an exact synonym.
+		{Id: "1", Name: "Felis catus", Rank: "species", Status: "synonym", Ranks: map[string]string{"genus": "Felis"}},
+		{Id: "3", Name: "Felis catus", Rank: "species", Status: "accepted", Ranks: map[string]string{"genus": "Felis", "family": "Felidae"}},
+		// Names further than maxMatchDistance never match.
+		{Id: "2", Name: "Felis catus Linnaeus", Rank: "species", Status: "accepted", Ranks: map[string]string{"genus": "Felis"}},
+		// Names only match within the same rank.
+		{Id: "4", Name: "Felis", Rank: "species", Status: "accepted"},
+		{Id: "5", Name: "Feliss", Rank: "genus", Status: "accepted", Ranks: map[string]string{"family": "Felidae"}},
+		{Id: "6", Name: "Aotus", Rank: "genus", Status: "accepted", Ranks: map[string]string{"kingdom": "Plantae", "family": "Fabaceae"}},
+		{Id: "7", Name: "Panthera tigris", Rank: "species", Status: "accepted"},
+		// Two substitutions are within maxMatchDistance.
+		{Id: "8", Name: "Lunx lanx", Rank: "species", Status: "accepted"},
+	} {
+		m.Add(ct)
+	}
+
+	matchedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	want := []CrossRef{
+		{
+			Key: "gbif-species-felis-catus", TaxonId: "species/felis-catus", Source: "gbif", Status: MatchExact,
+			ExternalId: "3", ExternalName: "Felis catus", ExternalStatus: "accepted", Conflicts: []string{}, MatchedAt: matchedAt,
+		},
+		{
+			Key: "gbif-genus-felis", TaxonId: "genus/felis", Source: "gbif", Status: MatchFuzzy,
+			ExternalId: "5", ExternalName: "Feliss", ExternalStatus: "accepted", Conflicts: []string{}, MatchedAt: matchedAt,
+		},
+		{
+			Key: "gbif-genus-aotus", TaxonId: "genus/aotus", Source: "gbif", Status: MatchConflicting,
+			ExternalId: "6", ExternalName: "Aotus", ExternalStatus: "accepted", Conflicts: []string{"kingdom", "family"}, MatchedAt: matchedAt,
+		},
+		{
+			Key: "gbif-species-panthera-leo", TaxonId: "species/panthera-leo", Source: "gbif", Status: MatchMissing, MatchedAt: matchedAt,
+		},
+		{
+			Key: "gbif-species-lynx-lynx", TaxonId: "species/lynx-lynx", Source: "gbif", Status: MatchFuzzy,
+			ExternalId: "8", ExternalName: "Lunx lanx", ExternalStatus: "accepted", Conflicts: []string{}, MatchedAt: matchedAt,
+		},
+	}
+	if got := m.CrossRefs("gbif", matchedAt); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
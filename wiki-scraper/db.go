@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -94,11 +95,55 @@ func createArangoDBCollections(config Config, graph arango.Graph) (map[string]ar
 			coll, _ = graph.VertexCollection(nil, taxLvlCollName)
 			fmt.Printf("Using existing collection '%s'\n", coll.Name())
 		}
+		// Wikidata QIDs are stable external IDs used for lookups. Homonym
+		// copies of a taxon share its page and QID, so the index is not
+		// unique, and a unique one left by an earlier version is dropped.
+		if err := dropUniqueIndex(coll, "wikidataId"); err != nil {
+			log.Fatalf("Failed to drop index: %v", err)
+		}
+		_, _, err = coll.EnsurePersistentIndex(nil, []string{"wikidataId"}, &arango.EnsurePersistentIndexOptions{
+			Sparse: true,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create index: %v", err)
+		}
+		// Keys from before stable keys were introduced, kept by migrate-keys.
+		_, _, err = coll.EnsurePersistentIndex(nil, []string{"legacyKey"}, &arango.EnsurePersistentIndexOptions{
+			Unique: true,
+			Sparse: true,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create index: %v", err)
+		}
+		// Page URLs are used for lookups by Wikipedia URL or title. Several
+		// taxa may share a page, so the index is not unique.
+		_, _, err = coll.EnsurePersistentIndex(nil, []string{"url"}, nil)
+		if err != nil {
+			log.Fatalf("Failed to create index: %v", err)
+		}
 		taxLvlColls[taxLvlCollName] = coll
 	}
 	return taxLvlColls, nil
 }
 
+// dropUniqueIndex removes a unique persistent index on field alone, if any.
+func dropUniqueIndex(coll arango.Collection, field string) error {
+	indexes, err := coll.Indexes(nil)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		fields := index.Fields()
+		if index.Type() == arango.PersistentIndex && index.Unique() && len(fields) == 1 && fields[0] == field {
+			if err := index.Remove(nil); err != nil {
+				return err
+			}
+			fmt.Printf("Dropped unique index on '%s' in collection '%s'\n", field, coll.Name())
+		}
+	}
+	return nil
+}
+
 func createArangoDBEdgeCollections(config Config, graph arango.Graph, taxLvlColls map[string]arango.Collection) (map[string]arango.Collection, error) {
 	var taxLvlEdgeCollNames []string = []string{
 		KingdomCollName + "Members",
@@ -155,46 +200,217 @@ func GetOrCreateCollections(config Config) (arango.Graph, map[string]arango.Coll
 	return graph, taxLvlColls, nil
 }
 
-func addTaxonToCollection(taxon Taxon, taxLvlColls map[string]arango.Collection) arango.DocumentID {
-	coll, ok := taxLvlColls[strings.ToLower(taxon.Rank)]
-	if !ok {
-		// Taxonomic heirerchy level not tracked in collections.
-		fmt.Printf("Skipping taxonomic level '%s'\n", taxon.Rank)
-		return ""
-	}
-	// Check if taxon already exists in collection.
-	query := fmt.Sprintf("FOR t IN %s FILTER t.name == '%s' RETURN t", coll.Name(), taxon.Name)
-	cursor, err := coll.Database().Query(nil, query, nil)
+// parentTaxonIds returns the IDs of the stored parents of a taxon.
+func parentTaxonIds(taxLvlColls map[string]arango.Collection, id arango.DocumentID, rankParent string) []arango.DocumentID {
+	edgeColl := taxLvlColls[fmt.Sprintf("%sMembers", rankParent)]
+	query := "FOR e IN @@edges FILTER e._from == @id RETURN e._to"
+	bindVars := map[string]interface{}{"@edges": edgeColl.Name(), "id": id}
+	cursor, err := edgeColl.Database().Query(nil, query, bindVars)
 	if err != nil {
 		log.Fatalf("Failed to query collection: %v", err)
 	}
 	defer cursor.Close()
-	var qTaxon Taxon
-	var id arango.DocumentID = ""
+	ids := []arango.DocumentID{}
 	for {
-		qMeta, err := cursor.ReadDocument(nil, &qTaxon)
+		var idParent arango.DocumentID
+		_, err := cursor.ReadDocument(nil, &idParent)
 		if arango.IsNoMoreDocuments(err) {
 			break
 		} else if err != nil {
 			log.Fatalf("Failed to read document: %v", err)
 		}
-		if qTaxon.Name == taxon.Name {
-			// Taxon already exists in collection.
-			id = qMeta.ID
-			fmt.Printf("Found document with id '%s' in collection '%s'\n", id, coll.Name())
-			break
+		ids = append(ids, idParent)
+	}
+	return ids
+}
+
+// TaxonState tells how the scraper may treat a stored taxon.
+type TaxonState int
+
+const (
+	// TaxonScraped taxa are kept up to date by the scraper.
+	TaxonScraped TaxonState = iota
+	// TaxonCurated taxa were edited by hand and are left as they are.
+	TaxonCurated
+	// TaxonDeleted taxa were deleted by hand and are not stored again.
+	TaxonDeleted
+)
+
+// deletedTaxon looks up the tombstone the backend leaves for a taxon deleted
+// by hand. A merged taxon's tombstone gives the ID of the taxon it was
+// merged into.
+func deletedTaxon(db arango.Database, id arango.DocumentID) (mergedInto arango.DocumentID, deleted bool) {
+	query := "RETURN DOCUMENT(@id)"
+	tombstoneId := DeletedTaxonCollName + "/" + strings.Replace(string(id), "/", ":", 1)
+	cursor, err := db.Query(nil, query, map[string]interface{}{"id": tombstoneId})
+	if err != nil {
+		log.Fatalf("Failed to query collection: %v", err)
+	}
+	defer cursor.Close()
+	var tombstone *struct {
+		MergedInto arango.DocumentID `json:"mergedInto"`
+	}
+	if _, err := cursor.ReadDocument(nil, &tombstone); err != nil {
+		log.Fatalf("Failed to read document: %v", err)
+	}
+	if tombstone == nil {
+		return "", false
+	}
+	return tombstone.MergedInto, true
+}
+
+// keyAction tells how to store a scraped taxon given what is stored under one
+// of its candidate keys.
+type keyAction int
+
+const (
+	// keyCreate stores the taxon under the key.
+	keyCreate keyAction = iota
+	// keyFound uses the taxon stored under the key, the scraped one.
+	keyFound
+	// keyCurated uses the taxon stored under the key as it was edited by hand.
+	keyCurated
+	// keyMerged uses the taxon a taxon deleted by hand was merged into.
+	keyMerged
+	// keyDeleted skips a taxon deleted by hand.
+	keyDeleted
+	// keyHomonym tries the next key, as a taxon of the same name with another
+	// parent is stored under the key.
+	keyHomonym
+)
+
+// storedTaxon is what is stored under a candidate key of a scraped taxon.
+type storedTaxon struct {
+	found   bool
+	curated bool
+	// parents are the IDs of the stored parents, only read for taxa found and
+	// not curated.
+	parents []arango.DocumentID
+	// deleted is set if a taxon under the key was deleted by hand, and
+	// mergedInto if it was merged into another.
+	deleted    bool
+	mergedInto arango.DocumentID
+}
+
+// decideKey decides how to store a scraped taxon with the parent idParent
+// given what is stored under a candidate key. Curated taxa are used whatever
+// their parent, and taxa deleted by hand are not stored again.
+func decideKey(stored storedTaxon, idParent arango.DocumentID) keyAction {
+	switch {
+	case !stored.found && stored.mergedInto != "":
+		return keyMerged
+	case !stored.found && stored.deleted:
+		return keyDeleted
+	case !stored.found:
+		return keyCreate
+	case stored.curated:
+		return keyCurated
+	case idParent == "" || len(stored.parents) == 0:
+		return keyFound
+	}
+	for _, id := range stored.parents {
+		if id == idParent {
+			return keyFound
 		}
 	}
-	if id == "" {
-		// Taxon does not exist in collection. Create it.
-		meta, err := coll.CreateDocument(nil, taxon)
-		if err != nil {
-			log.Fatalf("Failed to create document: %v", err)
+	return keyHomonym
+}
+
+// readStoredTaxon reads what decideKey needs to know of the taxon stored
+// under a key, and the taxon itself if found.
+func readStoredTaxon(coll arango.Collection, key string, idParent arango.DocumentID, rankParent string, taxLvlColls map[string]arango.Collection) (storedTaxon, Taxon) {
+	id := arango.NewDocumentID(coll.Name(), key)
+	var taxon Taxon
+	stored := storedTaxon{}
+	_, err := coll.ReadDocument(nil, key, &taxon)
+	switch {
+	case arango.IsNotFound(err):
+		stored.mergedInto, stored.deleted = deletedTaxon(coll.Database(), id)
+	case err != nil:
+		log.Fatalf("Failed to read document: %v", err)
+	default:
+		stored.found, stored.curated = true, taxon.Curated
+		if !taxon.Curated && idParent != "" {
+			stored.parents = parentTaxonIds(taxLvlColls, id, rankParent)
+		}
+	}
+	return stored, taxon
+}
+
+// addTaxonToCollection stores the taxon at the end of the lineage under its
+// stable key and returns its ID. A taxon stored under the key with another
+// parent is a homonym, so the taxon is stored under a key including the parent's.
+// Curated taxa are returned unchanged whatever their parent, and taxa deleted
+// by hand are not stored again; the ID of the taxon a deleted taxon was merged
+// into is returned instead.
+func addTaxonToCollection(lineage TaxonLineage, idParent arango.DocumentID, rankParent string, taxLvlColls map[string]arango.Collection) (arango.DocumentID, TaxonState) {
+	taxon := lineage.Taxon()
+	coll, ok := taxLvlColls[strings.ToLower(taxon.Rank)]
+	if !ok {
+		// Taxonomic heirerchy level not tracked in collections.
+		fmt.Printf("Skipping taxonomic level '%s'\n", taxon.Rank)
+		return "", TaxonScraped
+	}
+	key := TaxonKey(lineage.ScientificName())
+	if key == "" {
+		fmt.Printf("Skipping taxon '%s' with no usable name\n", taxon.Name)
+		return "", TaxonScraped
+	}
+	keys := []string{key}
+	if idParent != "" {
+		keys = append(keys, HomonymKey(key, idParent.Key()))
+	}
+
+	for _, key := range keys {
+		id := arango.NewDocumentID(coll.Name(), key)
+		stored, qTaxon := readStoredTaxon(coll, key, idParent, rankParent, taxLvlColls)
+		action := decideKey(stored, idParent)
+		if action == keyCreate {
+			// Taxon does not exist in collection. Create it.
+			taxon.Key = key
+			meta, err := coll.CreateDocument(nil, taxon)
+			if err == nil {
+				fmt.Printf("Created document with id '%s' in collection '%s'\n", meta.ID, coll.Name())
+				return meta.ID, TaxonScraped
+			}
+			if !arango.IsConflict(err) {
+				log.Fatalf("Failed to create document: %v", err)
+			}
+			// Created concurrently by another crawler thread, unless the
+			// conflict is on another unique index.
+			stored, qTaxon = readStoredTaxon(coll, key, idParent, rankParent, taxLvlColls)
+			if !stored.found {
+				fmt.Printf("Failed to create document '%s' in collection '%s': %v\n", key, coll.Name(), err)
+				return "", TaxonScraped
+			}
+			action = decideKey(stored, idParent)
+		}
+		switch action {
+		case keyMerged:
+			fmt.Printf("Taxon '%s' was merged into '%s'\n", id, stored.mergedInto)
+			return stored.mergedInto, TaxonCurated
+		case keyDeleted:
+			fmt.Printf("Skipping taxon '%s' deleted by a curator\n", id)
+			return "", TaxonDeleted
+		case keyCurated:
+			fmt.Printf("Found curated document with id '%s' in collection '%s'\n", id, coll.Name())
+			return id, TaxonCurated
+		case keyHomonym:
+			continue
 		}
-		id = meta.ID
-		fmt.Printf("Created document with id '%s' in collection '%s'\n", id, coll.Name())
+		// Taxon already exists in collection.
+		fmt.Printf("Found document with id '%s' in collection '%s'\n", id, coll.Name())
+		if taxon.WikidataId != "" && qTaxon.WikidataId == "" {
+			// Taxon was created before its Wikidata QID was known.
+			_, err := coll.UpdateDocument(nil, key, map[string]string{"wikidataId": taxon.WikidataId})
+			if err != nil {
+				fmt.Printf("Failed to set Wikidata QID of '%s': %v\n", id, err)
+			}
+		}
+		return id, TaxonScraped
 	}
-	return id
+	fmt.Printf("Skipping taxon '%s' with conflicting keys\n", taxon.Name)
+	return "", TaxonScraped
 }
 
 func addParentTaxonLinkToEdgeCollection(taxLvlColls map[string]arango.Collection, id, idParent arango.DocumentID, rankParent string) {
@@ -237,3 +453,53 @@ func addParentTaxonLinkToEdgeCollection(taxLvlColls map[string]arango.Collection
 		fmt.Printf("Created edge from '%s' to '%s' in collection '%s'\n", id, idParent, edgeColl.Name())
 	}
 }
+
+// WalkTaxonLineages calls fn for every taxon reachable from a kingdom in the
+// graph, streaming results from the database.
+func WalkTaxonLineages(config Config, db arango.Database, fn func(TaxonLineage) error) error {
+	query := `FOR k IN @@kingdom
+		FOR v, e, p IN 0..@depth INBOUND k GRAPH @graph
+			OPTIONS {order: "bfs", uniqueVertices: "global"}
+			RETURN {id: v._id, parent: e._to, lineage: p.vertices}`
+	bindVars := map[string]interface{}{
+		"@kingdom": KingdomCollName,
+		"graph":    config.GraphName,
+		"depth":    len(TaxonRanks) - 1,
+	}
+	ctx := arango.WithQueryStream(context.Background(), true)
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for {
+		var row TaxonLineage
+		_, err := cursor.ReadDocument(ctx, &row)
+		if arango.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setWikidataId sets the Wikidata QID on stored taxa whose page is at the given url.
+func setWikidataId(url string, qid string, taxLvlColls map[string]arango.Collection) {
+	for _, rank := range TaxonRanks {
+		coll := taxLvlColls[rank]
+		query := `FOR t IN @@coll
+			FILTER t.url == @url AND t.wikidataId == null
+			UPDATE t WITH {wikidataId: @qid} IN @@coll`
+		bindVars := map[string]interface{}{"@coll": coll.Name(), "url": url, "qid": qid}
+		cursor, err := coll.Database().Query(nil, query, bindVars)
+		if err != nil {
+			fmt.Printf("Failed to set Wikidata QID '%s' for '%s' in collection '%s': %v\n", qid, url, coll.Name(), err)
+			continue
+		}
+		cursor.Close()
+	}
+}
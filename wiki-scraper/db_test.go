@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+func TestDecideKey(t *testing.T) {
+	felidae := arango.DocumentID("family/felidae")
+	tests := []struct {
+		name     string
+		stored   storedTaxon
+		idParent arango.DocumentID
+		want     keyAction
+	}{
+		{"new", storedTaxon{}, felidae, keyCreate},
+		{"same parent", storedTaxon{found: true, parents: []arango.DocumentID{"family/canidae", felidae}}, felidae, keyFound},
+		{"no parent yet", storedTaxon{found: true}, felidae, keyFound},
+		{"root", storedTaxon{found: true, parents: []arango.DocumentID{"family/canidae"}}, "", keyFound},
+		{"homonym", storedTaxon{found: true, parents: []arango.DocumentID{"family/fabaceae"}}, felidae, keyHomonym},
+		// Curated taxa keep the parent given by hand.
+		{"curated", storedTaxon{found: true, curated: true}, felidae, keyCurated},
+		{"deleted", storedTaxon{deleted: true}, felidae, keyDeleted},
+		{"merged", storedTaxon{deleted: true, mergedInto: "genus/catus"}, felidae, keyMerged},
+	}
+	for _, tt := range tests {
+		if got := decideKey(tt.stored, tt.idParent); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
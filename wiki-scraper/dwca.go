@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+const dwcTermsNS = "http://rs.tdwg.org/dwc/terms/"
+
+// dwcaColumns lists the columns of the taxon.txt core file as Darwin Core
+// terms. The taxonID column is the core ID.
+var dwcaColumns = []string{
+	dwcTermsNS + "taxonID",
+	dwcTermsNS + "parentNameUsageID",
+	dwcTermsNS + "scientificName",
+	dwcTermsNS + "taxonRank",
+	dwcTermsNS + "kingdom",
+	dwcTermsNS + "phylum",
+	dwcTermsNS + "class",
+	dwcTermsNS + "order",
+	dwcTermsNS + "family",
+	dwcTermsNS + "genus",
+	"http://purl.org/dc/terms/references",
+}
+
+// dwcaField removes characters which would break the tab-delimited format.
+func dwcaField(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+func dwcaFields(row TaxonLineage) []string {
+	taxon := row.Taxon()
+	ranks := row.Ranks()
+	fields := []string{
+		row.Id,
+		row.Parent,
+		row.ScientificName(),
+		strings.ToLower(taxon.Rank),
+		ranks[KingdomCollName],
+		ranks[PhylumCollName],
+		ranks[ClassCollName],
+		ranks[OrderCollName],
+		ranks[FamilyCollName],
+		ranks[GenusCollName],
+		taxon.Url,
+	}
+	for i := range fields {
+		fields[i] = dwcaField(fields[i])
+	}
+	return fields
+}
+
+func writeDwCATaxa(w io.Writer, config Config, db arango.Database) (int, error) {
+	header := []string{}
+	for _, column := range dwcaColumns {
+		header = append(header, column[strings.LastIndex(column, "/")+1:])
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(header, "\t")); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err := WalkTaxonLineages(config, db, func(row TaxonLineage) error {
+		if _, err := fmt.Fprintln(w, strings.Join(dwcaFields(row), "\t")); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeDwCAMeta(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<archive xmlns="http://rs.tdwg.org/dwc/text/" metadata="eml.xml">
+  <core encoding="UTF-8" fieldsTerminatedBy="\t" linesTerminatedBy="\n" fieldsEnclosedBy="" ignoreHeaderLines="1" rowType="http://rs.tdwg.org/dwc/terms/Taxon">
+    <files>
+      <location>taxon.txt</location>
+    </files>
+    <id index="0"/>
+`)
+	for i, column := range dwcaColumns {
+		fmt.Fprintf(&b, "    <field index=\"%d\" term=\"%s\"/>\n", i, column)
+	}
+	b.WriteString("  </core>\n</archive>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeDwCAEML(w io.Writer, config Config, title string, pubDate time.Time) error {
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<eml:eml xmlns:eml="eml://ecoinformatics.org/eml-2.1.1" packageId="%s" system="http://gbif.org" scope="system" xml:lang="en">
+  <dataset>
+    <title>%s</title>
+    <creator>
+      <organizationName>Animal Kingdom Graph</organizationName>
+    </creator>
+    <pubDate>%s</pubDate>
+    <language>en</language>
+    <abstract>
+      <para>Taxonomic hierarchy of the %s kingdom scraped from the infoboxes of Wikipedia species pages, starting at %s.</para>
+    </abstract>
+    <contact>
+      <organizationName>Animal Kingdom Graph</organizationName>
+    </contact>
+  </dataset>
+</eml:eml>
+`,
+		xmlEscape(fmt.Sprintf("%s/%s", config.GraphName, pubDate.Format("20060102T150405Z"))),
+		xmlEscape(title),
+		pubDate.Format("2006-01-02"),
+		xmlEscape(config.KingdomName),
+		xmlEscape(config.CrawlerSeedURL),
+	)
+	return err
+}
+
+// ExportDwCA writes the taxonomy graph as a zipped Darwin Core Archive.
+func ExportDwCA(w io.Writer, config Config, db arango.Database, title string) (int, error) {
+	zw := zip.NewWriter(w)
+	taxa, err := zw.Create("taxon.txt")
+	if err != nil {
+		return 0, err
+	}
+	count, err := writeDwCATaxa(taxa, config, db)
+	if err != nil {
+		return count, err
+	}
+	meta, err := zw.Create("meta.xml")
+	if err != nil {
+		return count, err
+	}
+	if err := writeDwCAMeta(meta); err != nil {
+		return count, err
+	}
+	eml, err := zw.Create("eml.xml")
+	if err != nil {
+		return count, err
+	}
+	if err := writeDwCAEML(eml, config, title, time.Now().UTC()); err != nil {
+		return count, err
+	}
+	return count, zw.Close()
+}
+
+func runExportDwCA(config Config, args []string) {
+	flags := flag.NewFlagSet("export-dwca", flag.ExitOnError)
+	output := flags.String("o", "dwca.zip", "Path of the archive to write.")
+	title := flags.String("title", fmt.Sprintf("%s taxonomy from Wikipedia", config.KingdomName), "Dataset title for eml.xml.")
+	flags.Parse(args)
+
+	_, taxLvlColls, err := GetOrCreateCollections(config)
+	if err != nil {
+		log.Fatalf("Failed to create collections: %v", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create archive: %v", err)
+	}
+	count, err := ExportDwCA(f, config, taxLvlColls[KingdomCollName].Database(), *title)
+	if err != nil {
+		log.Fatalf("Failed to export archive: %v", err)
+	}
+	// The archive is only complete once the file is flushed and closed.
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write archive: %v", err)
+	}
+	fmt.Printf("Exported %d taxa to '%s'\n", count, *output)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLineage builds a lineage from alternating ranks and names, from the
+// kingdom down.
+func testLineage(id string, parent string, ranksAndNames ...string) TaxonLineage {
+	tl := TaxonLineage{Id: id, Parent: parent}
+	for i := 0; i+1 < len(ranksAndNames); i += 2 {
+		tl.Lineage = append(tl.Lineage, Taxon{
+			Rank: ranksAndNames[i],
+			Name: ranksAndNames[i+1],
+			Url:  "https://en.wikipedia.org/wiki/" + strings.ReplaceAll(ranksAndNames[i+1], " ", "_"),
+		})
+	}
+	return tl
+}
+
+var testCat = testLineage("species/felis-catus", "genus/felis",
+	"Kingdom", "Animalia", "Phylum", "Chordata", "Class", "Mammalia",
+	"Order", "Carnivora", "Family", "Felidae", "Genus", "Felis", "Species", "F. catus")
+
+func TestDwCAFields(t *testing.T) {
+	want := []string{
+		"species/felis-catus", "genus/felis", "Felis catus", "species",
+		"Animalia", "Chordata", "Mammalia", "Carnivora", "Felidae", "Felis",
+		"https://en.wikipedia.org/wiki/F._catus",
+	}
+	if got := dwcaFields(testCat); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(want) != len(dwcaColumns) {
+		t.Errorf("Expected a field for each of the %d columns", len(dwcaColumns))
+	}
+
+	tabs := testLineage("family/felidae", "order/carnivora", "Family", "Feli\tdae\n")
+	if got := dwcaFields(tabs)[2]; got != "Feli dae " {
+		t.Errorf("Expected tabs and newlines to be replaced, got %q", got)
+	}
+}
+
+func TestWriteDwCAMeta(t *testing.T) {
+	var b strings.Builder
+	if err := writeDwCAMeta(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := struct {
+		Location string `xml:"core>files>location"`
+		Id       struct {
+			Index int `xml:"index,attr"`
+		} `xml:"core>id"`
+		Fields []struct {
+			Index int    `xml:"index,attr"`
+			Term  string `xml:"term,attr"`
+		} `xml:"core>field"`
+	}{}
+	if err := xml.Unmarshal([]byte(b.String()), &meta); err != nil {
+		t.Fatalf("Expected valid XML, got %v", err)
+	}
+	if meta.Location != "taxon.txt" || meta.Id.Index != 0 {
+		t.Errorf("Unexpected core file %q with ID column %d", meta.Location, meta.Id.Index)
+	}
+	if len(meta.Fields) != len(dwcaColumns) {
+		t.Fatalf("Expected %d fields, got %d", len(dwcaColumns), len(meta.Fields))
+	}
+	for i, field := range meta.Fields {
+		if field.Index != i || field.Term != dwcaColumns[i] {
+			t.Errorf("Expected field %d to be %s, got %d %s", i, dwcaColumns[i], field.Index, field.Term)
+		}
+	}
+}
+
+func TestWriteDwCAEML(t *testing.T) {
+	config := Config{
+		GraphName:      "taxonomy",
+		KingdomName:    "Animalia",
+		CrawlerSeedURL: "https://en.wikipedia.org/wiki/Animal?a=1&b=2",
+	}
+	pubDate := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	var b strings.Builder
+	if err := writeDwCAEML(&b, config, "Cats & <dogs>", pubDate); err != nil {
+		t.Fatal(err)
+	}
+
+	eml := struct {
+		PackageId string `xml:"packageId,attr"`
+		Title     string `xml:"dataset>title"`
+		PubDate   string `xml:"dataset>pubDate"`
+		Abstract  string `xml:"dataset>abstract>para"`
+	}{}
+	if err := xml.Unmarshal([]byte(b.String()), &eml); err != nil {
+		t.Fatalf("Expected valid XML, got %v", err)
+	}
+	if eml.PackageId != "taxonomy/20240301T123000Z" {
+		t.Errorf("Unexpected package ID %q", eml.PackageId)
+	}
+	if eml.Title != "Cats & <dogs>" {
+		t.Errorf("Unexpected title %q", eml.Title)
+	}
+	if eml.PubDate != "2024-03-01" {
+		t.Errorf("Unexpected publication date %q", eml.PubDate)
+	}
+	if !strings.Contains(eml.Abstract, config.CrawlerSeedURL) {
+		t.Errorf("Expected the abstract to name the seed URL, got %q", eml.Abstract)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/arangodb/go-driver v1.6.0
 	github.com/gocolly/colly v1.2.0
 	github.com/spf13/viper v1.16.0
+	golang.org/x/text v0.11.0
 )
 
 require (
@@ -34,7 +35,6 @@ require (
 	github.com/temoto/robotstxt v1.1.2 // indirect
 	golang.org/x/net v0.12.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
-	golang.org/x/text v0.11.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	arango "github.com/arangodb/go-driver"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TaxonKey returns the stable document key for a scientific name: the name in
+// lower case with accents removed and each run of other characters replaced by
+// a hyphen, e.g. "felis-catus" or "equides". The backend normalises taxon IDs
+// in the same way; testdata/taxon_keys.json pins both to the same output.
+func TaxonKey(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range norm.NFD.String(ligatures.Replace(strings.ToLower(name))) {
+		if unicode.Is(unicode.Mn, r) {
+			// The accent of a decomposed letter, e.g. the acute of é.
+			continue
+		}
+		if r >= utf8.RuneSelf || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			hyphen = true
+			continue
+		}
+		if hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		hyphen = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ligatures spells out the letters NFD does not decompose.
+var ligatures = strings.NewReplacer("æ", "ae", "œ", "oe", "ß", "ss", "ø", "o", "ł", "l", "đ", "d")
+
+// HomonymKey returns the key of a taxon whose name is already used by a taxon
+// of the same rank with a different parent, e.g. "aotus_fabaceae".
+func HomonymKey(key string, parentKey string) string {
+	return key + "_" + parentKey
+}
+
+// keyMigration maps the current IDs of taxa to their stable IDs. Taxa deleted
+// by hand are kept apart, as only the references to them are moved.
+type keyMigration struct {
+	taxa    map[string]string
+	deleted map[string]string
+}
+
+// planKeyMigration returns the new document ID of every stored or deleted
+// taxon whose key is not yet its stable key.
+func planKeyMigration(config Config, db arango.Database) (keyMigration, error) {
+	rows := []TaxonLineage{}
+	err := WalkTaxonLineages(config, db, func(row TaxonLineage) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return keyMigration{}, err
+	}
+	deleted, err := deletedTaxonLineages(db)
+	if err != nil {
+		return keyMigration{}, err
+	}
+	return planKeys(rows, deleted), nil
+}
+
+// deletedTaxonLineages returns the taxa deleted by hand, as recorded by their
+// tombstones and the audit log. Each lineage holds the deleted taxon below its
+// parent, if the parent is still stored, so that species names are expanded.
+func deletedTaxonLineages(db arango.Database) ([]TaxonLineage, error) {
+	for _, name := range []string{DeletedTaxonCollName, AuditCollName} {
+		if exists, err := db.CollectionExists(nil, name); err != nil || !exists {
+			return nil, err
+		}
+	}
+	query := `FOR t IN @@deleted
+		LET before = FIRST(
+			FOR a IN @@audit
+				FILTER a.taxonId == t.taxonId AND a.action == "delete" AND a.before != null
+				SORT a.at DESC
+				LIMIT 1
+				RETURN a.before)
+		FILTER before != null
+		LET parent = before.parent ? DOCUMENT(before.parent) : null
+		RETURN {id: t.taxonId, parent: before.parent, lineage: APPEND(parent ? [parent] : [], [before])}`
+	return queryAll[TaxonLineage](db, query, map[string]interface{}{"@deleted": DeletedTaxonCollName, "@audit": AuditCollName})
+}
+
+// planKeys plans the stable keys of stored taxa, walked from the kingdom down
+// so that parents are keyed before the homonyms below them, and then of
+// deleted taxa.
+func planKeys(rows []TaxonLineage, deleted []TaxonLineage) keyMigration {
+	// Current keys are taken, including stable keys of migrated taxa.
+	used := map[string]bool{}
+	for _, row := range rows {
+		used[row.Id] = true
+	}
+	plan := keyMigration{taxa: map[string]string{}, deleted: map[string]string{}}
+	for i, row := range append(rows, deleted...) {
+		rank, oldKey, _ := strings.Cut(row.Id, "/")
+		key := TaxonKey(row.ScientificName())
+		if key == oldKey || key == "" {
+			continue
+		}
+		if used[rank+"/"+key] && row.Parent != "" {
+			parentId := row.Parent
+			if id, ok := plan.taxa[parentId]; ok {
+				parentId = id
+			}
+			_, parentKey, _ := strings.Cut(parentId, "/")
+			key = HomonymKey(key, parentKey)
+		}
+		if used[rank+"/"+key] {
+			// Unresolvable clash. Keep the current key.
+			continue
+		}
+		used[rank+"/"+key] = true
+		if i < len(rows) {
+			plan.taxa[row.Id] = rank + "/" + key
+		} else {
+			plan.deleted[row.Id] = rank + "/" + key
+		}
+	}
+	return plan
+}
+
+// inTransaction runs fn in a stream transaction writing to the given
+// collections, so that either all or none of its changes are applied.
+func inTransaction(db arango.Database, collNames []string, fn func(ctx context.Context) error) error {
+	tid, err := db.BeginTransaction(nil, arango.TransactionCollections{Write: collNames}, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(arango.WithTransactionID(context.Background(), tid)); err != nil {
+		if abortErr := db.AbortTransaction(nil, tid, nil); abortErr != nil {
+			fmt.Printf("Failed to abort transaction: %v\n", abortErr)
+		}
+		return err
+	}
+	return db.CommitTransaction(nil, tid, nil)
+}
+
+// execQuery runs an AQL query which returns no results.
+func execQuery(ctx context.Context, db arango.Database, query string, bindVars map[string]interface{}) error {
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
+// repointReferences moves the references to taxa held outside the graph to
+// their new IDs.
+func repointReferences(ctx context.Context, db arango.Database, refColls []string, ids map[string]string) error {
+	oldIds := []string{}
+	for oldId := range ids {
+		oldIds = append(oldIds, oldId)
+	}
+	for _, collName := range refColls {
+		queries := []string{}
+		switch collName {
+		case CrossRefCollName, TaxonVersionCollName:
+			queries = append(queries, `FOR c IN @@coll
+				FILTER c.taxonId IN @old OR c.parent IN @old
+				LET patch = {taxonId: @ids[c.taxonId] || c.taxonId}
+				UPDATE c WITH HAS(c, "parent") ? MERGE(patch, {parent: @ids[c.parent] || c.parent}) : patch IN @@coll`)
+		case AuditCollName:
+			queries = append(queries, `FOR a IN @@coll
+				FILTER a.taxonId IN @old OR a.mergedInto IN @old OR a.before.parent IN @old OR a.after.parent IN @old
+				UPDATE a WITH MERGE(
+					{taxonId: @ids[a.taxonId] || a.taxonId},
+					HAS(a, "mergedInto") ? {mergedInto: @ids[a.mergedInto] || a.mergedInto} : {},
+					a.before ? {before: {id: @ids[a.before.id] || a.before.id, parent: @ids[a.before.parent] || a.before.parent}} : {},
+					a.after ? {after: {id: @ids[a.after.id] || a.after.id, parent: @ids[a.after.parent] || a.after.parent}} : {}
+				) IN @@coll`)
+		case DeletedTaxonCollName:
+			// Tombstones are keyed by the ID of the deleted taxon.
+			queries = append(queries, `FOR t IN @@coll
+				FILTER t.taxonId IN @old
+				INSERT MERGE(UNSET(t, "_key", "_id", "_rev"), {
+					_key: SUBSTITUTE(@ids[t.taxonId], "/", ":", 1),
+					taxonId: @ids[t.taxonId]
+				}) INTO @@coll`, `FOR t IN @@coll
+				FILTER t.taxonId IN @old
+				REMOVE t IN @@coll`, `FOR t IN @@coll
+				FILTER t.mergedInto IN @old
+				UPDATE t WITH {mergedInto: @ids[t.mergedInto]} IN @@coll`)
+		}
+		for _, query := range queries {
+			bindVars := map[string]interface{}{"@coll": collName, "old": oldIds, "ids": ids}
+			if err := execQuery(ctx, db, query, bindVars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateKeyBatch moves the given taxa of one rank to their new keys in a
+// single transaction. Copies are stored first, keeping the old key as
+// legacyKey, then edges and other references are repointed and finally the
+// old documents removed.
+func migrateKeyBatch(db arango.Database, coll arango.Collection, edgeColls []arango.Collection, refColls []string, ids map[string]string) error {
+	collNames := []string{coll.Name()}
+	for _, edgeColl := range edgeColls {
+		collNames = append(collNames, edgeColl.Name())
+	}
+	collNames = append(collNames, refColls...)
+	return inTransaction(db, collNames, func(ctx context.Context) error {
+		oldKeys := []string{}
+		for oldId := range ids {
+			_, key, _ := strings.Cut(oldId, "/")
+			oldKeys = append(oldKeys, key)
+		}
+		docs := make([]map[string]interface{}, len(oldKeys))
+		_, errs, err := coll.ReadDocuments(ctx, oldKeys, docs)
+		if err != nil {
+			return err
+		}
+		if err := errs.FirstNonNil(); err != nil {
+			return err
+		}
+		for i, doc := range docs {
+			_, newKey, _ := strings.Cut(ids[coll.Name()+"/"+oldKeys[i]], "/")
+			delete(doc, "_id")
+			delete(doc, "_rev")
+			doc["_key"] = newKey
+			doc["legacyKey"] = oldKeys[i]
+		}
+		_, errs, err = coll.CreateDocuments(ctx, docs)
+		if err != nil {
+			return err
+		}
+		if err := errs.FirstNonNil(); err != nil {
+			return err
+		}
+
+		oldIds := []string{}
+		for oldId := range ids {
+			oldIds = append(oldIds, oldId)
+		}
+		for _, edgeColl := range edgeColls {
+			query := `FOR e IN @@edges
+				FILTER e._from IN @old OR e._to IN @old
+				UPDATE e WITH {_from: @ids[e._from] || e._from, _to: @ids[e._to] || e._to} IN @@edges`
+			bindVars := map[string]interface{}{"@edges": edgeColl.Name(), "old": oldIds, "ids": ids}
+			if err := execQuery(ctx, db, query, bindVars); err != nil {
+				return err
+			}
+		}
+		if err := repointReferences(ctx, db, refColls, ids); err != nil {
+			return err
+		}
+
+		_, errs, err = coll.RemoveDocuments(ctx, oldKeys)
+		if err != nil {
+			return err
+		}
+		return errs.FirstNonNil()
+	})
+}
+
+// MigrateKeys moves taxa stored with database-generated keys to their stable
+// keys, along with the tombstones and audit entries of taxa deleted by hand.
+// Each batch is moved in a transaction, so the migration can be run again
+// after a failure; taxa already migrated are left unchanged.
+func MigrateKeys(config Config, db arango.Database, taxLvlColls map[string]arango.Collection, dryRun bool) (int, error) {
+	plan, err := planKeyMigration(config, db)
+	if err != nil {
+		return 0, err
+	}
+	count := len(plan.taxa) + len(plan.deleted)
+	if dryRun {
+		for oldId, newId := range plan.taxa {
+			fmt.Printf("%s -> %s\n", oldId, newId)
+		}
+		for oldId, newId := range plan.deleted {
+			fmt.Printf("%s -> %s (deleted)\n", oldId, newId)
+		}
+		return count, nil
+	}
+
+	refColls := []string{}
+	for _, collName := range []string{CrossRefCollName, TaxonVersionCollName, DeletedTaxonCollName, AuditCollName} {
+		if exists, err := db.CollectionExists(nil, collName); err != nil {
+			return 0, err
+		} else if exists {
+			refColls = append(refColls, collName)
+		}
+	}
+	edgeColls := []arango.Collection{}
+	for _, rank := range TaxonRanks[:len(TaxonRanks)-1] {
+		edgeColls = append(edgeColls, taxLvlColls[rank+"Members"])
+	}
+	const batchSize = 1000
+	for _, rank := range TaxonRanks {
+		batch := map[string]string{}
+		for oldId, newId := range plan.taxa {
+			if !strings.HasPrefix(oldId, rank+"/") {
+				continue
+			}
+			batch[oldId] = newId
+			if len(batch) == batchSize {
+				if err := migrateKeyBatch(db, taxLvlColls[rank], edgeColls, refColls, batch); err != nil {
+					return 0, err
+				}
+				batch = map[string]string{}
+			}
+		}
+		if len(batch) > 0 {
+			if err := migrateKeyBatch(db, taxLvlColls[rank], edgeColls, refColls, batch); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(plan.deleted) > 0 {
+		err := inTransaction(db, refColls, func(ctx context.Context) error {
+			return repointReferences(ctx, db, refColls, plan.deleted)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func runMigrateKeys(config Config, args []string) {
+	flags := flag.NewFlagSet("migrate-keys", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Print the planned key changes without applying them.")
+	flags.Parse(args)
+
+	_, taxLvlColls, err := GetOrCreateCollections(config)
+	if err != nil {
+		log.Fatalf("Failed to create collections: %v", err)
+	}
+	count, err := MigrateKeys(config, taxLvlColls[KingdomCollName].Database(), taxLvlColls, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to migrate keys: %v", err)
+	}
+	if *dryRun {
+		fmt.Printf("Would migrate %d taxa to stable keys\n", count)
+		return
+	}
+	fmt.Printf("Migrated %d taxa to stable keys\n", count)
+	if err := BumpGraphVersion(config, taxLvlColls[KingdomCollName].Database()); err != nil {
+		log.Fatalf("Failed to bump graph version: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTaxonKey(t *testing.T) {
+	// The backend's copy of TaxonKey is tested against the same cases.
+	data, err := os.ReadFile("testdata/taxon_keys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := TaxonKey(tt.Name); got != tt.Key {
+			t.Errorf("TaxonKey(%q): expected %q, got %q", tt.Name, tt.Key, got)
+		}
+	}
+}
+
+func TestHomonymKey(t *testing.T) {
+	// Homonyms of the same rank are told apart by their parent.
+	plant, monkey := HomonymKey(TaxonKey("Aotus"), TaxonKey("Fabaceae")), HomonymKey(TaxonKey("Aotus"), TaxonKey("Aotidae"))
+	if plant != "aotus_fabaceae" || monkey != "aotus_aotidae" {
+		t.Errorf("Unexpected homonym keys %q and %q", plant, monkey)
+	}
+	// The separator cannot occur in a taxon key, so a homonym key never
+	// equals the key of another name.
+	if TaxonKey("Aotus fabaceae") == plant {
+		t.Errorf("Expected homonym key %q to differ from taxon keys", plant)
+	}
+}
+
+func TestPlanKeys(t *testing.T) {
+	rows := []TaxonLineage{
+		testLineage("genus/1", "", "Genus", "Aotus"),
+		testLineage("genus/aotus-fabaceae", "", "Genus", "Aotus fabaceae"),
+		testLineage("genus/2", "family/fabaceae", "Family", "Fabaceae", "Genus", "Aotus"),
+		testLineage("species/felis-catus", "genus/felis", "Genus", "Felis", "Species", "F. catus"),
+		testLineage("species/3", "genus/1", "Genus", "Aotus", "Species", "A. trivirgatus"),
+	}
+	deleted := []TaxonLineage{
+		testLineage("species/4", "genus/felis", "Genus", "Felis", "Species", "F. silvestris"),
+		testLineage("species/5", "genus/felis", "Species", "Felis catus"),
+	}
+	plan := planKeys(rows, deleted)
+
+	wantTaxa := map[string]string{
+		"genus/1": "genus/aotus",
+		// The homonym takes its parent's key.
+		"genus/2":   "genus/aotus_fabaceae",
+		"species/3": "species/aotus-trivirgatus",
+	}
+	if !reflect.DeepEqual(plan.taxa, wantTaxa) {
+		t.Errorf("Expected %v, got %v", wantTaxa, plan.taxa)
+	}
+	// Stored taxa keep their keys; a deleted taxon is keyed as a homonym.
+	wantDeleted := map[string]string{
+		"species/4": "species/felis-silvestris",
+		"species/5": "species/felis-catus_felis",
+	}
+	if !reflect.DeepEqual(plan.deleted, wantDeleted) {
+		t.Errorf("Expected %v, got %v", wantDeleted, plan.deleted)
+	}
+}
@@ -6,9 +6,20 @@ package main
 // — but it has been estimated there are around 7.77 million animal species in total.
 
 import (
+	"fmt"
 	"log"
+	"os"
 )
 
+const usage = `Usage: wiki_scraper [command] [flags]
+
+Commands:
+  crawl        Crawl Wikipedia and store the taxonomy graph (default).
+  export-dwca  Export the taxonomy graph as a Darwin Core Archive.
+  crossref     Match taxa against a GBIF Backbone or Catalogue of Life checklist.
+  migrate-keys Move taxa with database-generated keys to stable keys.
+`
+
 func main() {
 	var err error
 
@@ -18,18 +29,54 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	cmd, args := "crawl", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "crawl":
+		runCrawl(config)
+	case "export-dwca":
+		runExportDwCA(config, args)
+	case "crossref":
+		runCrossRef(config, args)
+	case "migrate-keys":
+		runMigrateKeys(config, args)
+	default:
+		log.Fatalf("Unknown command '%s'\n%s", cmd, usage)
+	}
+}
+
+func runCrawl(config Config) {
 	// Get ArangoDB collections.
 	_, taxLvlColls, err := GetOrCreateCollections(config)
 	if err != nil {
 		log.Fatalf("Failed to create collections: %v", err)
 	}
 
+	// Record the crawl as a snapshot.
+	db := taxLvlColls[KingdomCollName].Database()
+	snapshot, err := StartSnapshot(config, db)
+	if err != nil {
+		log.Fatalf("Failed to start snapshot: %v", err)
+	}
+	recorder := NewCrawlRecorder()
+
 	// Create Colly crawler.
-	c := CreateCollyCrawler(config, taxLvlColls)
+	c := CreateCollyCrawler(config, taxLvlColls, recorder)
 
 	// Start crawler at seed url.
 	c.Visit(config.CrawlerSeedURL)
 
 	// Wait for all threads to finish.
 	c.Wait()
+
+	snapshot, err = FinishSnapshot(config, db, snapshot, recorder)
+	if err != nil {
+		log.Fatalf("Failed to finish snapshot '%s': %v", snapshot.Key, err)
+	}
+	fmt.Printf("Recorded snapshot '%s' (#%d) with %d taxa\n", snapshot.Key, snapshot.Seq, snapshot.Taxa)
+	if err := BumpGraphVersion(config, db); err != nil {
+		log.Fatalf("Failed to bump graph version: %v", err)
+	}
 }
@@ -1,7 +1,68 @@
 package main
 
+import (
+	"strings"
+	"time"
+)
+
 type Taxon struct {
-	Rank string `json:"rank"`
-	Name string `json:"name"`
-	Url  string `json:"url"`
+	Key        string `json:"_key,omitempty"`
+	Rank       string `json:"rank"`
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	WikidataId string `json:"wikidataId,omitempty"`
+	// Curated is set on taxa edited by hand through the backend API. The
+	// scraper neither changes them nor their parent.
+	Curated bool `json:"curated,omitempty"`
+}
+
+// TaxonLineage is a stored taxon with the taxa above it.
+type TaxonLineage struct {
+	Id     string `json:"id"`
+	Parent string `json:"parent"`
+	// Lineage holds the taxa from the kingdom down to this taxon.
+	Lineage []Taxon `json:"lineage"`
+}
+
+// Taxon returns the taxon the lineage leads to.
+func (tl TaxonLineage) Taxon() Taxon {
+	return tl.Lineage[len(tl.Lineage)-1]
+}
+
+// Ranks returns the names in the lineage keyed by lower case rank.
+func (tl TaxonLineage) Ranks() map[string]string {
+	ranks := map[string]string{}
+	for _, t := range tl.Lineage {
+		ranks[strings.ToLower(t.Rank)] = t.Name
+	}
+	return ranks
+}
+
+// ScientificName returns the taxon name, with the genus of species names
+// expanded from the abbreviated form shown in Wikipedia infoboxes, e.g.
+// "F. catus" to "Felis catus".
+func (tl TaxonLineage) ScientificName() string {
+	taxon := tl.Taxon()
+	if strings.ToLower(taxon.Rank) != SpeciesCollName {
+		return taxon.Name
+	}
+	genus := tl.Ranks()[GenusCollName]
+	abbrev, epithet, ok := strings.Cut(taxon.Name, " ")
+	if ok && len(abbrev) == 2 && abbrev[1] == '.' && strings.HasPrefix(genus, abbrev[:1]) {
+		return genus + " " + epithet
+	}
+	return taxon.Name
+}
+
+// CrossRef links a scraped taxon to a taxon in an external checklist.
+type CrossRef struct {
+	Key            string    `json:"_key"`
+	TaxonId        string    `json:"taxonId"`
+	Source         string    `json:"source"`
+	Status         string    `json:"status"`
+	ExternalId     string    `json:"externalId,omitempty"`
+	ExternalName   string    `json:"externalName,omitempty"`
+	ExternalStatus string    `json:"externalStatus,omitempty"`
+	Conflicts      []string  `json:"conflicts,omitempty"`
+	MatchedAt      time.Time `json:"matchedAt"`
 }
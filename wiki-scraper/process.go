@@ -23,7 +23,34 @@ func checkTaxonSequence(taxLvls []Taxon) error {
 	return nil
 }
 
-func processTaxon(taxLvls []Taxon, taxLvlColls map[string]arango.Collection) {
+// taxonStore stores the taxa of scraped lineages.
+type taxonStore interface {
+	// AddTaxon stores the taxon at the end of the lineage below the parent
+	// and returns its ID, as addTaxonToCollection does.
+	AddTaxon(lineage TaxonLineage, idParent arango.DocumentID, rankParent string) (arango.DocumentID, TaxonState)
+	// Parents returns the IDs of the stored parents of a taxon.
+	Parents(id arango.DocumentID, rankParent string) []arango.DocumentID
+	// Link stores the edge from a taxon to its parent unless it exists.
+	Link(id arango.DocumentID, idParent arango.DocumentID, rankParent string)
+}
+
+// collectionStore stores taxa in the rank and edge collections of the graph,
+// keyed by collection name.
+type collectionStore map[string]arango.Collection
+
+func (s collectionStore) AddTaxon(lineage TaxonLineage, idParent arango.DocumentID, rankParent string) (arango.DocumentID, TaxonState) {
+	return addTaxonToCollection(lineage, idParent, rankParent, s)
+}
+
+func (s collectionStore) Parents(id arango.DocumentID, rankParent string) []arango.DocumentID {
+	return parentTaxonIds(s, id, rankParent)
+}
+
+func (s collectionStore) Link(id arango.DocumentID, idParent arango.DocumentID, rankParent string) {
+	addParentTaxonLinkToEdgeCollection(s, id, idParent, rankParent)
+}
+
+func processTaxon(taxLvls []Taxon, store taxonStore, recorder *CrawlRecorder) {
 	// Check all required taxonomic levels are present.
 	err := checkTaxonSequence(taxLvls)
 	if err != nil {
@@ -34,12 +61,22 @@ func processTaxon(taxLvls []Taxon, taxLvlColls map[string]arango.Collection) {
 	var rankParent string = ""
 
 	// Store taxonomic data for all taxonomic levels in ArangoDB.
-	for _, taxon := range taxLvls {
-		id := addTaxonToCollection(taxon, taxLvlColls)
+	for i, taxon := range taxLvls {
+		id, state := store.AddTaxon(TaxonLineage{Lineage: taxLvls[:i+1]}, idParent, rankParent)
+		if state == TaxonDeleted {
+			// Taxa below a deleted taxon have no parent to attach to.
+			return
+		}
 		if id != "" {
-			if idParent != "" {
-				addParentTaxonLinkToEdgeCollection(taxLvlColls, id, idParent, rankParent)
+			if state == TaxonCurated && idParent != "" {
+				// Curated taxa keep the parent they were given by hand.
+				if parents := store.Parents(id, rankParent); len(parents) > 0 {
+					idParent = parents[0]
+				}
+			} else if idParent != "" {
+				store.Link(id, idParent, rankParent)
 			}
+			recorder.Record(id, idParent)
 			idParent = id
 			rankParent = strings.ToLower(taxon.Rank)
 		}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+// fakeStore stores taxa under the keys of their names, with the taxa curated,
+// deleted and merged by hand given up front.
+type fakeStore struct {
+	// curated maps the IDs of curated taxa to the parent given by hand.
+	curated map[arango.DocumentID]arango.DocumentID
+	deleted map[arango.DocumentID]bool
+	merged  map[arango.DocumentID]arango.DocumentID
+	links   map[arango.DocumentID]arango.DocumentID
+}
+
+func (s *fakeStore) AddTaxon(lineage TaxonLineage, idParent arango.DocumentID, rankParent string) (arango.DocumentID, TaxonState) {
+	taxon := lineage.Taxon()
+	id := arango.NewDocumentID(strings.ToLower(taxon.Rank), TaxonKey(taxon.Name))
+	if into, ok := s.merged[id]; ok {
+		return into, TaxonCurated
+	}
+	if s.deleted[id] {
+		return "", TaxonDeleted
+	}
+	if _, ok := s.curated[id]; ok {
+		return id, TaxonCurated
+	}
+	return id, TaxonScraped
+}
+
+func (s *fakeStore) Parents(id arango.DocumentID, rankParent string) []arango.DocumentID {
+	if parent, ok := s.curated[id]; ok {
+		return []arango.DocumentID{parent}
+	}
+	return nil
+}
+
+func (s *fakeStore) Link(id arango.DocumentID, idParent arango.DocumentID, rankParent string) {
+	s.links[id] = idParent
+}
+
+func TestProcessTaxon(t *testing.T) {
+	lineage := testLineage("species/felis-catus", "genus/felis",
+		"Kingdom", "Animalia", "Phylum", "Chordata", "Class", "Mammalia",
+		"Order", "Carnivora", "Family", "Felidae", "Genus", "Felis", "Species", "Felis catus").Lineage
+	scraped := map[arango.DocumentID]arango.DocumentID{
+		"phylum/chordata":     "kingdom/animalia",
+		"class/mammalia":      "phylum/chordata",
+		"order/carnivora":     "class/mammalia",
+		"family/felidae":      "order/carnivora",
+		"genus/felis":         "family/felidae",
+		"species/felis-catus": "genus/felis",
+	}
+	// with returns the scraped parents changed by the given ones, a missing
+	// parent meaning no entry.
+	with := func(changes map[arango.DocumentID]arango.DocumentID) map[arango.DocumentID]arango.DocumentID {
+		parents := map[arango.DocumentID]arango.DocumentID{}
+		for id, parent := range scraped {
+			parents[id] = parent
+		}
+		for id, parent := range changes {
+			if parent == "" {
+				delete(parents, id)
+			} else {
+				parents[id] = parent
+			}
+		}
+		return parents
+	}
+	withRoot := func(parents map[arango.DocumentID]arango.DocumentID) map[arango.DocumentID]arango.DocumentID {
+		recorded := map[arango.DocumentID]arango.DocumentID{"kingdom/animalia": ""}
+		for id, parent := range parents {
+			recorded[id] = parent
+		}
+		return recorded
+	}
+
+	tests := []struct {
+		name         string
+		store        fakeStore
+		wantLinks    map[arango.DocumentID]arango.DocumentID
+		wantRecorded map[arango.DocumentID]arango.DocumentID
+	}{
+		{
+			name:         "scraped",
+			wantLinks:    scraped,
+			wantRecorded: withRoot(scraped),
+		},
+		{
+			// A curated taxon keeps the parent given by hand.
+			name:         "curated",
+			store:        fakeStore{curated: map[arango.DocumentID]arango.DocumentID{"genus/felis": "family/pantherinae"}},
+			wantLinks:    with(map[arango.DocumentID]arango.DocumentID{"genus/felis": ""}),
+			wantRecorded: withRoot(with(map[arango.DocumentID]arango.DocumentID{"genus/felis": "family/pantherinae"})),
+		},
+		{
+			// Taxa below a deleted taxon are not stored.
+			name:  "deleted",
+			store: fakeStore{deleted: map[arango.DocumentID]bool{"family/felidae": true}},
+			wantLinks: with(map[arango.DocumentID]arango.DocumentID{
+				"family/felidae": "", "genus/felis": "", "species/felis-catus": "",
+			}),
+			wantRecorded: withRoot(with(map[arango.DocumentID]arango.DocumentID{
+				"family/felidae": "", "genus/felis": "", "species/felis-catus": "",
+			})),
+		},
+		{
+			// Taxa below a merged taxon are stored below the taxon it was
+			// merged into, which keeps its parent.
+			name: "merged",
+			store: fakeStore{
+				merged:  map[arango.DocumentID]arango.DocumentID{"genus/felis": "genus/catus"},
+				curated: map[arango.DocumentID]arango.DocumentID{"genus/catus": "family/felidae"},
+			},
+			wantLinks: with(map[arango.DocumentID]arango.DocumentID{
+				"genus/felis": "", "species/felis-catus": "genus/catus",
+			}),
+			wantRecorded: withRoot(with(map[arango.DocumentID]arango.DocumentID{
+				"genus/felis": "", "genus/catus": "family/felidae", "species/felis-catus": "genus/catus",
+			})),
+		},
+	}
+	for _, tt := range tests {
+		store := tt.store
+		store.links = map[arango.DocumentID]arango.DocumentID{}
+		recorder := NewCrawlRecorder()
+		processTaxon(lineage, &store, recorder)
+		if !reflect.DeepEqual(store.links, tt.wantLinks) {
+			t.Errorf("%s: expected links %v, got %v", tt.name, tt.wantLinks, store.links)
+		}
+		if !reflect.DeepEqual(recorder.parents, tt.wantRecorded) {
+			t.Errorf("%s: expected recorded parents %v, got %v", tt.name, tt.wantRecorded, recorder.parents)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+// Snapshot statuses.
+const (
+	SnapshotRunning  = "running"
+	SnapshotComplete = "complete"
+)
+
+// SnapshotConfig records the crawler settings a snapshot was made with.
+type SnapshotConfig struct {
+	SeedURL       string `json:"seedUrl"`
+	AllowedDomain string `json:"allowedDomain"`
+	MaxTreeDepth  int    `json:"maxTreeDepth"`
+	KingdomName   string `json:"kingdomName"`
+}
+
+// Snapshot is the record of a crawl. Completed snapshots of a graph are
+// numbered in order by Seq.
+type Snapshot struct {
+	Key        string         `json:"_key"`
+	Graph      string         `json:"graph"`
+	Seq        int            `json:"seq,omitempty"`
+	Status     string         `json:"status"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	Config     SnapshotConfig `json:"config"`
+	Taxa       int            `json:"taxa"`
+}
+
+// TaxonVersion is the state of a taxon over an interval of snapshots. It is
+// valid from snapshot ValidFrom up to but excluding ValidTo, which is null
+// while the version is current.
+type TaxonVersion struct {
+	Graph      string `json:"graph"`
+	TaxonId    string `json:"taxonId"`
+	Rank       string `json:"rank"`
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	WikidataId string `json:"wikidataId,omitempty"`
+	Parent     string `json:"parent,omitempty"`
+	ValidFrom  int    `json:"validFrom"`
+	ValidTo    *int   `json:"validTo"`
+}
+
+// sameAs reports whether two versions describe the same taxon state.
+func (v TaxonVersion) sameAs(other TaxonVersion) bool {
+	return v.Rank == other.Rank && v.Name == other.Name && v.Url == other.Url &&
+		v.WikidataId == other.WikidataId && v.Parent == other.Parent
+}
+
+// CrawlRecorder collects the taxa and parent links stored during a crawl.
+// It is safe for concurrent use by crawler threads.
+type CrawlRecorder struct {
+	mu      sync.Mutex
+	parents map[arango.DocumentID]arango.DocumentID
+}
+
+func NewCrawlRecorder() *CrawlRecorder {
+	return &CrawlRecorder{parents: map[arango.DocumentID]arango.DocumentID{}}
+}
+
+// Record notes a stored taxon and its parent. Where pages disagree on the
+// parent of a taxon, the smallest ID is kept so snapshots are deterministic.
+func (r *CrawlRecorder) Record(id arango.DocumentID, idParent arango.DocumentID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current, ok := r.parents[id]; !ok || (idParent != "" && (current == "" || idParent < current)) {
+		r.parents[id] = idParent
+	}
+}
+
+func getOrCreateCollection(db arango.Database, name string, indexes [][]string) (arango.Collection, error) {
+	exists, err := db.CollectionExists(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	var coll arango.Collection
+	if exists {
+		coll, err = db.Collection(nil, name)
+	} else {
+		coll, err = db.CreateCollection(nil, name, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range indexes {
+		if _, _, err := coll.EnsurePersistentIndex(nil, fields, nil); err != nil {
+			return nil, err
+		}
+	}
+	return coll, nil
+}
+
+// snapshotKey returns the key of a snapshot of a graph started at the given
+// time. The snapshots of every graph in a database share a collection, so the
+// key names the graph as well as the time.
+func snapshotKey(graph string, startedAt time.Time) string {
+	return graph + "-" + startedAt.Format("20060102T150405Z")
+}
+
+// StartSnapshot records the start of a crawl.
+func StartSnapshot(config Config, db arango.Database) (Snapshot, error) {
+	coll, err := getOrCreateCollection(db, SnapshotCollName, [][]string{{"graph", "seq"}})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	startedAt := time.Now().UTC()
+	snapshot := Snapshot{
+		Key:       snapshotKey(config.GraphName, startedAt),
+		Graph:     config.GraphName,
+		Status:    SnapshotRunning,
+		StartedAt: startedAt,
+		Config: SnapshotConfig{
+			SeedURL:       config.CrawlerSeedURL,
+			AllowedDomain: config.CrawlerAllowedDomain,
+			MaxTreeDepth:  config.CrawlerMaxTreeDepth,
+			KingdomName:   config.KingdomName,
+		},
+	}
+	_, err = coll.CreateDocument(nil, snapshot)
+	return snapshot, err
+}
+
+// queryAll runs a query and reads every result into a slice.
+func queryAll[T any](db arango.Database, query string, bindVars map[string]interface{}) ([]T, error) {
+	ctx := arango.WithQueryStream(context.Background(), true)
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	results := []T{}
+	for {
+		var result T
+		_, err := cursor.ReadDocument(ctx, &result)
+		if arango.IsNoMoreDocuments(err) {
+			return results, nil
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+}
+
+// recordedVersions reads the current state of the recorded taxa.
+func recordedVersions(config Config, db arango.Database, recorder *CrawlRecorder) ([]TaxonVersion, error) {
+	ids := make([]arango.DocumentID, 0, len(recorder.parents))
+	for id := range recorder.parents {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	versions := []TaxonVersion{}
+	const batchSize = 1000
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		query := `FOR id IN @ids
+			LET t = DOCUMENT(id)
+			FILTER t != null
+			RETURN {taxonId: t._id, rank: t.rank, name: t.name, url: t.url, wikidataId: t.wikidataId}`
+		batch, err := queryAll[TaxonVersion](db, query, map[string]interface{}{"ids": ids[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			batch[i].Graph = config.GraphName
+			batch[i].Parent = string(recorder.parents[arango.DocumentID(batch[i].TaxonId)])
+		}
+		versions = append(versions, batch...)
+	}
+	return versions, nil
+}
+
+// FinishSnapshot numbers a completed crawl and updates the taxon versions:
+// versions of taxa which changed or were not seen are closed and versions of
+// new or changed taxa opened.
+func FinishSnapshot(config Config, db arango.Database, snapshot Snapshot, recorder *CrawlRecorder) (Snapshot, error) {
+	snapshotColl, err := db.Collection(nil, SnapshotCollName)
+	if err != nil {
+		return snapshot, err
+	}
+	versionColl, err := getOrCreateCollection(db, TaxonVersionCollName, [][]string{
+		{"graph", "taxonId"},
+		{"graph", "validFrom"},
+		{"graph", "validTo"},
+	})
+	if err != nil {
+		return snapshot, err
+	}
+
+	seqs, err := queryAll[int](db, `RETURN MAX(
+			FOR s IN @@coll
+				FILTER s.graph == @graph AND s.status == @status
+				RETURN s.seq
+		) || 0`, map[string]interface{}{"@coll": SnapshotCollName, "graph": config.GraphName, "status": SnapshotComplete})
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Seq = seqs[0] + 1
+
+	versions, err := recordedVersions(config, db, recorder)
+	if err != nil {
+		return snapshot, err
+	}
+	type openVersion struct {
+		Key string `json:"_key"`
+		TaxonVersion
+	}
+	open, err := queryAll[openVersion](db, `FOR v IN @@coll
+		FILTER v.graph == @graph AND v.validTo == null
+		RETURN v`, map[string]interface{}{"@coll": TaxonVersionCollName, "graph": config.GraphName})
+	if err != nil {
+		return snapshot, err
+	}
+	openByTaxon := map[string]openVersion{}
+	for _, v := range open {
+		openByTaxon[v.TaxonId] = v
+	}
+
+	created := []TaxonVersion{}
+	closed := []string{}
+	for _, v := range versions {
+		if current, ok := openByTaxon[v.TaxonId]; ok {
+			delete(openByTaxon, v.TaxonId)
+			if current.sameAs(v) {
+				continue
+			}
+			closed = append(closed, current.Key)
+		}
+		v.ValidFrom = snapshot.Seq
+		created = append(created, v)
+	}
+	for _, v := range openByTaxon {
+		// Not seen in this crawl.
+		closed = append(closed, v.Key)
+	}
+
+	const batchSize = 1000
+	for start := 0; start < len(closed); start += batchSize {
+		end := start + batchSize
+		if end > len(closed) {
+			end = len(closed)
+		}
+		patches := make([]map[string]int, end-start)
+		for i := range patches {
+			patches[i] = map[string]int{"validTo": snapshot.Seq}
+		}
+		if _, errs, err := versionColl.UpdateDocuments(nil, closed[start:end], patches); err != nil {
+			return snapshot, err
+		} else if err := errs.FirstNonNil(); err != nil {
+			return snapshot, err
+		}
+	}
+	for start := 0; start < len(created); start += batchSize {
+		end := start + batchSize
+		if end > len(created) {
+			end = len(created)
+		}
+		if _, errs, err := versionColl.CreateDocuments(nil, created[start:end]); err != nil {
+			return snapshot, err
+		} else if err := errs.FirstNonNil(); err != nil {
+			return snapshot, err
+		}
+	}
+
+	finishedAt := time.Now().UTC()
+	snapshot.Status = SnapshotComplete
+	snapshot.FinishedAt = &finishedAt
+	snapshot.Taxa = len(versions)
+	_, err = snapshotColl.ReplaceDocument(nil, snapshot.Key, snapshot)
+	return snapshot, err
+}
+
+// BumpGraphVersion increments the version of the graph, stored under the
+// graph name, telling backends to drop their cached lookups.
+func BumpGraphVersion(config Config, db arango.Database) error {
+	if _, err := getOrCreateCollection(db, GraphVersionCollName, nil); err != nil {
+		return err
+	}
+	cursor, err := db.Query(nil, `UPSERT {_key: @graph}
+		INSERT {_key: @graph, version: 1, updatedAt: @now}
+		UPDATE {version: OLD.version + 1, updatedAt: @now}
+		IN @@coll`, map[string]interface{}{
+		"@coll": GraphVersionCollName,
+		"graph": config.GraphName,
+		"now":   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+func TestCrawlRecorder(t *testing.T) {
+	// Pages are crawled concurrently and in any order, so the recorded
+	// parents must not depend on the order taxa are stored in.
+	records := [][2]arango.DocumentID{
+		{"species/felis-catus", "genus/felis"},
+		{"species/felis-catus", ""},
+		{"species/felis-catus", "genus/catus"},
+		{"genus/felis", ""},
+		{"kingdom/animalia", ""},
+	}
+	want := map[arango.DocumentID]arango.DocumentID{
+		"species/felis-catus": "genus/catus",
+		"genus/felis":         "",
+		"kingdom/animalia":    "",
+	}
+	for _, order := range [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0}, {1, 2, 0, 4, 3}} {
+		recorder := NewCrawlRecorder()
+		var wg sync.WaitGroup
+		for _, i := range order {
+			record := records[i]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder.Record(record[0], record[1])
+			}()
+		}
+		wg.Wait()
+		if !reflect.DeepEqual(recorder.parents, want) {
+			t.Errorf("Expected %v, got %v", want, recorder.parents)
+		}
+	}
+}
+
+func TestTaxonVersionSameAs(t *testing.T) {
+	validTo := 3
+	v := TaxonVersion{
+		Graph: "taxonomy", TaxonId: "species/felis-catus", Rank: "Species", Name: "F. catus",
+		Url: "https://en.wikipedia.org/wiki/Cat", Parent: "genus/felis", ValidFrom: 1,
+	}
+	// The interval a version is valid over is not part of its state.
+	other := v
+	other.ValidFrom, other.ValidTo = 2, &validTo
+	if !v.sameAs(other) {
+		t.Errorf("Expected versions differing only in validity to be the same")
+	}
+	for _, change := range []func(*TaxonVersion){
+		func(v *TaxonVersion) { v.Rank = "Subspecies" },
+		func(v *TaxonVersion) { v.Name = "F. silvestris" },
+		func(v *TaxonVersion) { v.Url = "https://en.wikipedia.org/wiki/Felis_catus" },
+		func(v *TaxonVersion) { v.WikidataId = "Q146" },
+		func(v *TaxonVersion) { v.Parent = "" },
+	} {
+		changed := v
+		change(&changed)
+		if v.sameAs(changed) {
+			t.Errorf("Expected %+v to differ from %+v", changed, v)
+		}
+	}
+}
+
+func TestSnapshotKey(t *testing.T) {
+	startedAt := time.Date(2026, 10, 1, 6, 30, 5, 0, time.UTC)
+	// Crawls of different graphs started in the same second get their own keys.
+	if a, b := snapshotKey("animal_kingdom", startedAt), snapshotKey("plant_kingdom", startedAt); a == b {
+		t.Errorf("Expected the keys of different graphs to differ, got %q", a)
+	}
+	if got := snapshotKey("animal_kingdom", startedAt); got != "animal_kingdom-20261001T063005Z" {
+		t.Errorf("Unexpected snapshot key %q", got)
+	}
+}